@@ -2,61 +2,153 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"github.com/gin-gonic/gin"
-
-	"go-im/internal/handler"
-	"go-im/internal/repository"
+	"go-im/internal/config"
+	"go-im/internal/logging"
 	"go-im/internal/service"
+	"go-im/internal/tracing"
 )
 
-const serverAddr = ":8080"
+// ackFlushTimeout 为退出前写入剩余已读位点的超时。
+const ackFlushTimeout = 5 * time.Second
 
 func main() {
-	// 构建依赖
-	db, err := repository.NewDB()
+	configPath := flag.String("config", os.Getenv("IM_CONFIG"), "YAML 配置文件路径，也可通过 IM_CONFIG 指定")
+	flag.Parse()
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatalf("连接数据库失败: %v", err)
+		log.Fatalf("加载配置失败: %v", err)
 	}
-	connManager := service.NewConnectionManager()
-	msgRepo := repository.NewMessageRepository(db)
-	msgSvc := service.NewMessageService(msgRepo)
-	wsHandler := handler.NewWebSocketHandler(connManager, msgSvc)
 
-	// 初始化 Gin，引入基础日志与 panic 恢复
-	router := gin.New()
-	router.Use(gin.Logger(), gin.Recovery())
+	// 日志级别可热更新；标准库 log 与 gin 的输出同样经由该 logger，DSN 与令牌字段统一脱敏
+	logLevel := new(slog.LevelVar)
+	level, _ := config.ParseLogLevel(cfg.Runtime.LogLevel) // Load 已校验
+	logLevel.Set(level)
+	logger := logging.New(os.Stderr, cfg.Log.Format, logLevel)
+	slog.SetDefault(logger)
+
+	// 子命令：server migrate up|down|status，server config print
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			err = runMigrate(cfg, args[1:], logger)
+		case "config":
+			err = runConfig(cfg, args[1:])
+		default:
+			err = fmt.Errorf("unknown command %q", args[0])
+		}
+		if err != nil {
+			fatal(logger, args[0]+" 失败", err)
+		}
+		return
+	}
 
-	// WebSocket 路由；REST API 可在 /api 组下扩展
-	router.GET("/ws", wsHandler.HandleWebSocket)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal(logger, "初始化链路追踪失败", err)
+	}
+
+	// 构建依赖
+	srv, err := newServer(cfg, *configPath, logLevel, logger)
+	if err != nil {
+		fatal(logger, "初始化服务失败", err)
+	}
+	srv.registerMetrics()
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	srv.runBackground(bgCtx)
 
 	httpServer := &http.Server{
-		Addr:    serverAddr,
-		Handler: router,
+		Addr:    cfg.Server.Addr,
+		Handler: srv.router,
 	}
 
 	go func() {
-		log.Printf("WebSocket/Gin 服务启动，监听 %s", serverAddr)
+		logger.Info("WebSocket/Gin 服务启动", "addr", cfg.Server.Addr, "log_format", cfg.Log.Format)
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("服务启动失败: %v", err)
+			fatal(logger, "服务启动失败", err)
 		}
 	}()
 
-	// 监听系统信号，优雅退出
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	<-stop
+	// 监听系统信号：SIGHUP 重新加载运行时配置，SIGINT/SIGTERM 优雅退出
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if _, _, err := srv.reload.Reload(); err != nil {
+			logger.Warn("重新加载配置失败，保持原配置", "err", err)
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// 先排空 WebSocket（Shutdown 不跟踪已升级的连接），再关闭 HTTP 服务、后台任务与连接池
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
+	if err := srv.ws.Drain(ctx, cfg.Server.DrainJitter); err != nil {
+		logger.Warn("排空连接未完成", "err", err)
+	}
 	if err := httpServer.Shutdown(ctx); err != nil {
-		log.Printf("服务关闭异常: %v", err)
+		logger.Warn("服务关闭异常", "err", err)
+	}
+	stopBackground()
+	// 排空连接可能已用尽关闭超时，剩余位点使用独立的短超时写入
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), ackFlushTimeout)
+	if err := srv.acks.Flush(flushCtx); err != nil {
+		logger.Warn("写入剩余已读位点失败", "err", err)
+	}
+	cancelFlush()
+	if srv.notifier != nil {
+		srv.notifier.Flush()
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Warn("导出剩余 span 失败", "err", err)
+	}
+	if err := srv.store.close(); err != nil {
+		logger.Warn("关闭数据库连接失败", "err", err)
+	}
+	logger.Info("服务已关闭")
+}
+
+// fatal 记录错误并以非零状态退出。
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// newNotifier 按配置选择离线通知通道：Webhook 优先，其次 File；
+// 都未配置时返回 nil，离线用户不发通知。
+func newNotifier(cfg config.NotifyConfig, devices service.DeviceStore, settings service.SettingsReader, logger *slog.Logger) (*service.NotificationService, error) {
+	var provider service.NotificationProvider
+	if cfg.Webhook != "" {
+		provider = service.NewWebhookProvider(cfg.Webhook)
+	} else if cfg.File != "" {
+		provider = service.NewFileSinkProvider(cfg.File)
+	} else {
+		return nil, nil
+	}
+	return service.NewNotificationService(devices, settings, provider, service.DefaultNotificationTemplates, cfg.Privacy, cfg.CollapseWindow, logger)
+}
+
+// runConfig 实现 config 子命令，print 输出每一项生效值及其来源。
+func runConfig(cfg *config.Config, args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New("usage: server config print")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, e := range cfg.Entries() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Key, e.Value, e.Source)
 	}
-	log.Println("服务已关闭")
+	return w.Flush()
 }
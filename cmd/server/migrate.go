@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"go-im/internal/config"
+	"go-im/internal/repository"
+)
+
+const migrateUsage = "usage: server migrate up | down [steps] | status"
+
+// checkSchema 拒绝在未迁移到最新版本的数据库上启动，表结构变更须先执行 migrate up。
+// 只有 SQLite 内存库（本地开发与测试）每次启动都是空库，启动时直接迁移到最新版本。
+func checkSchema(db *gorm.DB, cfg config.StorageConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if cfg.InMemorySQLite() {
+		return repository.MigrateLatest(ctx, db)
+	}
+	m, err := repository.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	return m.Check(ctx)
+}
+
+// runMigrate 实现 migrate 子命令，数据库连接配置与服务启动相同。
+func runMigrate(cfg *config.Config, args []string, logger *slog.Logger) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	if cfg.Storage.Backend != "sql" {
+		return errors.New("migrate requires storage.backend=sql")
+	}
+	db, err := repository.OpenConfig(cfg.Storage, logger)
+	if err != nil {
+		return err
+	}
+	m, err := repository.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			fmt.Printf("applied  %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return errors.New(migrateUsage)
+			}
+		}
+		reverted, err := m.Down(ctx, steps)
+		for _, mig := range reverted {
+			fmt.Printf("reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+		return err
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%-24s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	"go-im/internal/config"
+	"go-im/internal/service"
+)
+
+// reloader 重新读取配置并把 runtime 段应用到运行中的组件，其余配置的修改只提示需重启。
+type reloader struct {
+	path        string
+	runtime     *config.Runtime
+	logLevel    *slog.LevelVar
+	connManager *service.ConnectionManager
+	logger      *slog.Logger
+
+	mu   sync.Mutex
+	boot *config.Config // 启动时的配置，非 runtime 段的修改始终相对它判断
+}
+
+func newReloader(path string, cfg *config.Config, runtime *config.Runtime, logLevel *slog.LevelVar, connManager *service.ConnectionManager, logger *slog.Logger) *reloader {
+	r := &reloader{path: path, runtime: runtime, logLevel: logLevel, connManager: connManager, logger: logger, boot: cfg}
+	r.apply(cfg.Runtime)
+	return r
+}
+
+// Reload 加载并校验配置，校验失败时保持原配置不变。返回生效的运行时配置与需重启才能生效的键。
+func (r *reloader) Reload() (*config.RuntimeConfig, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.Load(r.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	restart := r.boot.RestartRequired(next)
+	if len(restart) > 0 {
+		r.logger.Warn("以下配置修改需重启后生效", "keys", restart)
+	}
+	r.apply(next.Runtime)
+	r.logger.Info("运行时配置已重新加载", "log_level", next.Runtime.LogLevel)
+	return r.runtime.Load(), restart, nil
+}
+
+func (r *reloader) apply(rt config.RuntimeConfig) {
+	level, _ := config.ParseLogLevel(rt.LogLevel) // Load 已校验
+	r.runtime.Store(rt)
+	r.logLevel.Set(level)
+	r.connManager.SetMaxConnections(rt.MaxConnections)
+	for _, userID := range rt.BannedUsers {
+		if r.connManager.Kick(userID) {
+			r.logger.Info("已断开被封禁用户的连接", "user", userID)
+		}
+	}
+}
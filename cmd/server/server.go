@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+
+	"go-im/internal/config"
+	"go-im/internal/handler"
+	"go-im/internal/metrics"
+	"go-im/internal/service"
+)
+
+// server 汇总装配好的服务端组件，main 与集成测试使用同一套装配。
+type server struct {
+	router      *gin.Engine
+	ws          *handler.WebSocketHandler
+	connManager *service.ConnectionManager
+	tracker     *service.DeliveryTracker
+	limiter     *service.RateLimiter
+	acks        *service.AckCoalescer
+	batcher     *service.MessageBatcher      // write_batch_size 为 1 时为 nil
+	notifier    *service.NotificationService // 未配置离线通知时为 nil
+	reload      *reloader
+	store       *storage
+	cfg         *config.Config
+	logger      *slog.Logger
+}
+
+// newServer 按配置构建存储、各业务服务与路由，后台任务由 runBackground 启动。
+func newServer(cfg *config.Config, configPath string, logLevel *slog.LevelVar, logger *slog.Logger) (*server, error) {
+	store, err := newStorage(cfg.Storage, logger)
+	if err != nil {
+		return nil, err
+	}
+	connManager := service.NewConnectionManager()
+	runtime := config.NewRuntime(cfg.Runtime)
+	reload := newReloader(configPath, cfg, runtime, logLevel, connManager, logger)
+
+	notifier, err := newNotifier(cfg.Notify, store.devices, store.conversations, logger)
+	if err != nil {
+		store.close()
+		return nil, err
+	}
+	var offline service.OfflineNotifier
+	if notifier != nil {
+		offline = notifier
+	}
+
+	tracker := service.NewDeliveryTracker(store.delivery, cfg.Delivery.AckTimeout, cfg.Delivery.MaxRetries)
+	pushSvc := service.NewPushService(connManager, tracker, offline)
+	// 聊天消息默认经组提交写库，write_batch_size 为 1 时每条消息单独提交
+	var saver service.MessageSaver = store.messages
+	var batcher *service.MessageBatcher
+	if cfg.Storage.WriteBatchSize > 1 {
+		batcher = service.NewMessageBatcher(store.messages, cfg.Storage.WriteBatchSize, cfg.Storage.WriteBatchDelay, logger)
+		saver = batcher
+	}
+	// 热点会话的最近消息在写库后进入缓存，拉取完全命中时不访问数据库
+	var pullStore service.PullStorage = store.pull
+	if cfg.Pull.CacheConversations > 0 {
+		cache := service.NewRecentMessageCache(cfg.Pull.CacheConversations, cfg.Pull.CacheMessages)
+		saver = service.NewCachingSaver(saver, cache)
+		pullStore = service.NewCachingPullStorage(store.pull, cache)
+	}
+	msgSvc := service.NewMessageService(saver, store.members, service.NewFanout(store.members, pushSvc), logger)
+	pullSvc := service.NewPullService(pullStore, store.hidden, store.members, cfg.Pull.PageSize, cfg.Pull.BatchMaxConversations, cfg.Pull.BatchMaxMessages)
+	convSvc := service.NewConversationService(store.conversations, store.members, pushSvc)
+	hideSvc := service.NewHideService(store.messages, store.hidden, store.members, pushSvc)
+	limiter := service.NewRateLimiter()
+	acks := service.NewAckCoalescer(store.acks, store.members, cfg.Pull.AckFlushInterval, logger)
+	wsHandler := handler.NewWebSocketHandler(connManager, msgSvc, pullSvc, convSvc, hideSvc, tracker, limiter, acks, cfg.WebSocket, runtime, logger)
+	deviceHandler := handler.NewDeviceHandler(service.NewDeviceService(store.devices), logger)
+	convHandler := handler.NewConversationHandler(convSvc, hideSvc, logger)
+	adminHandler := handler.NewAdminHandler(cfg.Server.AdminToken, reload.Reload, connManager, convSvc, logger)
+
+	// 初始化 Gin，引入访问日志与 panic 恢复
+	router := gin.New()
+	router.Use(handler.AccessLog(logger), gin.Recovery())
+
+	// WebSocket 路由；REST API 在 /api 组下扩展
+	router.GET("/ws", wsHandler.HandleWebSocket)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	handler.NewHealthHandler(wsHandler, readinessChecks(store), logger).Register(router)
+	api := router.Group("/api")
+	deviceHandler.Register(api)
+	convHandler.Register(api)
+	adminHandler.Register(router.Group("/admin"))
+
+	return &server{
+		router:      router,
+		ws:          wsHandler,
+		connManager: connManager,
+		tracker:     tracker,
+		limiter:     limiter,
+		acks:        acks,
+		batcher:     batcher,
+		notifier:    notifier,
+		reload:      reload,
+		store:       store,
+		cfg:         cfg,
+		logger:      logger,
+	}, nil
+}
+
+// registerMetrics 注册依赖运行中组件的指标，每个进程只调用一次。
+func (s *server) registerMetrics() {
+	metrics.RegisterGauge("online_connections", "Online WebSocket connections; a user online on several devices counts once per device.", func() float64 {
+		return float64(s.connManager.Count())
+	})
+	metrics.RegisterGauge("online_users", "Users with at least one online WebSocket connection.", func() float64 {
+		return float64(s.connManager.Users())
+	})
+	metrics.RegisterGauge("delivery_pending", "Pushes waiting for a client deliver ack.", func() float64 {
+		return float64(s.tracker.PendingTotal())
+	})
+	metrics.RegisterGauge("ack_pending", "Read ack positions buffered in memory, waiting to be written.", func() float64 {
+		return float64(s.acks.Pending())
+	})
+	if s.notifier != nil {
+		metrics.RegisterGauge("notify_pending", "Offline notifications held in the collapse window.", func() float64 {
+			return float64(s.notifier.PendingBatches())
+		})
+	}
+	if s.store.sqlDB != nil {
+		metrics.RegisterDBStats(s.store.sqlDB)
+	}
+}
+
+// runBackground 启动重投、限流清理、ACK 刷新、去重清理、归档与组提交等后台任务，ctx 取消时退出。
+func (s *server) runBackground(ctx context.Context) {
+	go s.tracker.Run(ctx)
+	go s.limiter.Run(ctx)
+	go s.acks.Run(ctx)
+	go service.NewDedupJanitor(s.store.messages, s.cfg.Storage.DedupWindow, s.logger).Run(ctx)
+	if s.cfg.Retention.Enabled() {
+		go service.NewRetentionJanitor(s.store.retention, service.NewFileArchive(s.cfg.Retention.ArchiveDir), s.cfg.Retention, s.logger).Run(ctx)
+	}
+	if s.batcher != nil {
+		go s.batcher.Run(ctx)
+	}
+}
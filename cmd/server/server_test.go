@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"go-im/internal/config"
+	"go-im/internal/model"
+)
+
+// packet 是测试客户端收到的下行包，Payload 保留原始 JSON 便于按指令解析。
+type packet struct {
+	Cmd     model.CmdType   `json:"cmd"`
+	Code    model.ErrorCode `json:"code"`
+	MsgId   string          `json:"msg_id"`
+	Seq     int64           `json:"seq"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func TestMemoryBackendDeliversChatBetweenMembers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	members := filepath.Join(t.TempDir(), "members.yaml")
+	if err := os.WriteFile(members, []byte("group_t: [u1, u2]\n"), 0o600); err != nil {
+		t.Fatalf("write members: %v", err)
+	}
+	cfg := config.Default()
+	cfg.Storage.Backend = "memory"
+	cfg.Storage.MemoryMembers = members
+
+	srv, err := newServer(cfg, "", new(slog.LevelVar), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.runBackground(ctx)
+	hs := httptest.NewServer(srv.router)
+	defer hs.Close()
+
+	u1, u2, u3 := dialUser(t, hs, "u1"), dialUser(t, hs, "u2"), dialUser(t, hs, "u3")
+	deadline := time.Now().Add(time.Second)
+	for srv.connManager.Count() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	send(t, u1, model.InputPacket{Cmd: model.CmdChat, MsgId: "m1", ConversationId: "group_t", Payload: json.RawMessage(`{"content":"hi"}`)})
+	if reply := receive(t, u1); reply.Code != model.CodeOK || reply.MsgId != "m1" || reply.Seq != 1 {
+		t.Fatalf("sender should get seq 1, got %+v", reply)
+	}
+	push := receive(t, u2)
+	var msg model.ChatMessage
+	if err := json.Unmarshal(push.Payload, &msg); err != nil {
+		t.Fatalf("decode push: %v", err)
+	}
+	if push.Cmd != model.CmdChat || msg.Content != "hi" || msg.SenderId != "u1" || msg.Seq != 1 {
+		t.Fatalf("member should receive the chat, got %+v %+v", push, msg)
+	}
+
+	send(t, u3, model.InputPacket{Cmd: model.CmdChat, MsgId: "m2", ConversationId: "group_t", Payload: json.RawMessage(`{"content":"hey"}`)})
+	if reply := receive(t, u3); reply.Code != model.CodeNotMember {
+		t.Fatalf("non-member should be rejected, got %+v", reply)
+	}
+}
+
+func dialUser(t *testing.T, hs *httptest.Server, userID string) *websocket.Conn {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http")+"/ws?user_id="+userID, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", userID, err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func send(t *testing.T, ws *websocket.Conn, in model.InputPacket) {
+	t.Helper()
+	if err := ws.WriteJSON(in); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
+
+func receive(t *testing.T, ws *websocket.Conn) packet {
+	t.Helper()
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var p packet
+	if err := ws.ReadJSON(&p); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	return p
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"go-im/internal/config"
+	"go-im/internal/handler"
+	"go-im/internal/repository"
+	"go-im/internal/service"
+)
+
+type messageStore interface {
+	service.MessageBatchSaver
+	service.DedupPurger
+	service.MessageFinder
+}
+
+type deviceStore interface {
+	service.DeviceRegistry
+	service.DeviceStore
+}
+
+type memberStore interface {
+	service.MemberLookup
+	service.MembershipChecker
+}
+
+type conversationStore interface {
+	service.ConversationStore
+	service.SettingsReader
+}
+
+type hiddenStore interface {
+	service.HiddenStore
+	service.HiddenFilter
+}
+
+// storage 汇总各服务依赖的仓储实现。
+type storage struct {
+	messages      messageStore
+	pull          service.PullStorage
+	acks          service.AckStore
+	delivery      service.DeliveryStore
+	members       memberStore
+	devices       deviceStore
+	conversations conversationStore
+	hidden        hiddenStore
+	retention     service.RetentionStore
+	sqlDB         *sql.DB      // memory 后端为 nil
+	close         func() error // 关闭底层连接池
+}
+
+// newStorage 按配置选择存储后端：sql 按 driver 连接 MySQL 或 SQLite，启动前检查表结构版本；
+// memory 不依赖任何外部服务，重启后数据丢失，会话成员从 memory_members 文件载入。
+func newStorage(cfg config.StorageConfig, logger *slog.Logger) (*storage, error) {
+	switch cfg.Backend {
+	case "memory":
+		mem := repository.NewMemoryStore()
+		if cfg.MemoryMembers != "" {
+			n, err := loadMembers(mem, cfg.MemoryMembers)
+			if err != nil {
+				return nil, err
+			}
+			logger.Info("已载入会话成员", "file", cfg.MemoryMembers, "conversations", n)
+		}
+		return &storage{
+			messages:      mem,
+			pull:          mem,
+			acks:          mem,
+			delivery:      mem,
+			members:       mem,
+			devices:       mem,
+			conversations: mem,
+			hidden:        mem,
+			retention:     mem,
+			close:         func() error { return nil },
+		}, nil
+	case "sql":
+		db, err := repository.OpenConfig(cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSchema(db, cfg); err != nil {
+			return nil, err
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		pullRepo := repository.NewPullRepository(db)
+		return &storage{
+			messages:      repository.NewMessageRepository(db),
+			pull:          pullRepo,
+			acks:          pullRepo,
+			delivery:      pullRepo,
+			members:       repository.NewMemberRepository(db),
+			devices:       repository.NewDeviceRepository(db),
+			conversations: repository.NewConversationRepository(db),
+			hidden:        repository.NewHiddenMessageRepository(db),
+			retention:     repository.NewArchiveRepository(db),
+			sqlDB:         sqlDB,
+			close:         sqlDB.Close,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage backend %q", cfg.Backend)
+	}
+}
+
+// loadMembers 从 YAML 文件（会话 ID -> 用户 ID 列表）载入会话成员，返回会话数。
+func loadMembers(mem *repository.MemoryStore, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("read members %s: %w", path, err)
+	}
+	var members map[string][]string
+	if err := yaml.Unmarshal(data, &members); err != nil {
+		return 0, fmt.Errorf("parse members %s: %w", path, err)
+	}
+	for conversationID, users := range members {
+		if conversationID == "" {
+			return 0, fmt.Errorf("members %s: empty conversation id", path)
+		}
+		for _, userID := range users {
+			if userID == "" {
+				return 0, fmt.Errorf("members %s: empty user id in %s", path, conversationID)
+			}
+			mem.AddMember(conversationID, userID)
+		}
+	}
+	return len(members), nil
+}
+
+// readinessChecks 返回存储相关的就绪检查，memory 后端没有外部依赖。
+func readinessChecks(store *storage) map[string]handler.ReadinessCheck {
+	checks := make(map[string]handler.ReadinessCheck)
+	if store.sqlDB != nil {
+		checks["db"] = store.sqlDB.PingContext
+	}
+	return checks
+}
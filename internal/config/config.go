@@ -0,0 +1,594 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-im/internal/model"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 配置来源，用于 config print 展示每一项的生效值从何而来。
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+)
+
+// Config 是服务端的全部配置。每个叶子字段的 yaml 标签为文件中的键，env 标签为覆盖它的环境变量。
+// 优先级：环境变量 > 配置文件 > 默认值。
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	WebSocket WebSocketConfig `yaml:"websocket"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Pull      PullConfig      `yaml:"pull"`
+	Delivery  DeliveryConfig  `yaml:"delivery"`
+	Notify    NotifyConfig    `yaml:"notify"`
+	Retention RetentionConfig `yaml:"retention"`
+	Log       LogConfig       `yaml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Runtime   RuntimeConfig   `yaml:"runtime"`
+
+	sources map[string]string
+}
+
+type ServerConfig struct {
+	Addr            string        `yaml:"addr" env:"IM_SERVER_ADDR"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"IM_SHUTDOWN_TIMEOUT"`     // 停机时排空连接与关闭服务的总时限
+	DrainJitter     time.Duration `yaml:"drain_jitter" env:"IM_DRAIN_JITTER"`             // 下线通知中客户端重连延迟的随机上限
+	AdminToken      string        `yaml:"admin_token" env:"IM_ADMIN_TOKEN" secret:"true"` // 为空时不开放管理接口
+}
+
+type WebSocketConfig struct {
+	ReadDeadline   time.Duration `yaml:"read_deadline" env:"IM_WS_READ_DEADLINE"`     // 允许心跳丢 2-3 次（30s/跳）
+	WriteTimeout   time.Duration `yaml:"write_timeout" env:"IM_WS_WRITE_TIMEOUT"`     // 写超时防止阻塞
+	RequestTimeout time.Duration `yaml:"request_timeout" env:"IM_WS_REQUEST_TIMEOUT"` // 单条指令的处理超时
+}
+
+// StorageConfig 选择存储后端。Backend 为 sql 时按 Driver 连接 MySQL 或 SQLite，memory 为纯内存。
+type StorageConfig struct {
+	Backend         string        `yaml:"backend" env:"IM_STORAGE"`
+	Driver          string        `yaml:"driver" env:"IM_DB_DRIVER"`
+	MySQLDSN        string        `yaml:"mysql_dsn" env:"IM_MYSQL_DSN" secret:"true"`
+	SQLitePath      string        `yaml:"sqlite_path" env:"IM_SQLITE_PATH"`
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"IM_DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"IM_DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"IM_DB_CONN_MAX_LIFETIME"`
+	WriteBatchSize  int           `yaml:"write_batch_size" env:"IM_DB_WRITE_BATCH_SIZE"`   // 组提交单个事务最多写入的聊天消息数，1 表示不合并
+	WriteBatchDelay time.Duration `yaml:"write_batch_delay" env:"IM_DB_WRITE_BATCH_DELAY"` // 组提交收集消息的最长等待
+	DedupWindow     time.Duration `yaml:"dedup_window" env:"IM_DEDUP_WINDOW"`              // msg_id 去重记录的保留时长，窗口内的重发返回已有 seq
+	MemoryMembers   string        `yaml:"memory_members" env:"IM_MEMORY_MEMBERS"`          // memory 后端启动时载入的会话成员文件（YAML，会话 ID -> 用户 ID 列表）
+}
+
+// DSN 返回当前驱动对应的连接串。
+func (s StorageConfig) DSN() string {
+	if s.Driver == "sqlite" {
+		return s.SQLitePath
+	}
+	return s.MySQLDSN
+}
+
+// InMemorySQLite 报告是否使用 SQLite 内存库（:memory: 或 mode=memory），内存库每次启动都是空库。
+func (s StorageConfig) InMemorySQLite() bool {
+	return s.Driver == "sqlite" && (s.SQLitePath == ":memory:" || strings.Contains(s.SQLitePath, "mode=memory"))
+}
+
+type PullConfig struct {
+	PageSize              int           `yaml:"page_size" env:"IM_PULL_PAGE_SIZE"`                             // 客户端未指定时的单页条数，也是批量拉取中单个会话的上限
+	BatchMaxConversations int           `yaml:"batch_max_conversations" env:"IM_PULL_BATCH_MAX_CONVERSATIONS"` // 批量拉取单次最多的会话数
+	BatchMaxMessages      int           `yaml:"batch_max_messages" env:"IM_PULL_BATCH_MAX_MESSAGES"`           // 批量拉取单次最多返回的消息数
+	AckFlushInterval      time.Duration `yaml:"ack_flush_interval" env:"IM_ACK_FLUSH_INTERVAL"`                // 已读 ACK 在内存中合并后写库的周期，0 表示每次 ACK 直接写库
+	CacheConversations    int           `yaml:"cache_conversations" env:"IM_PULL_CACHE_CONVERSATIONS"`         // 热点消息缓存最多缓存的会话数，默认 0 即关闭；仅在每个会话只由本节点写入时开启
+	CacheMessages         int           `yaml:"cache_messages" env:"IM_PULL_CACHE_MESSAGES"`                   // 每个会话缓存的最近消息数，应大于 page_size
+}
+
+type DeliveryConfig struct {
+	AckTimeout time.Duration `yaml:"ack_timeout" env:"IM_DELIVERY_ACK_TIMEOUT"` // 送达回执超时，超时后重投
+	MaxRetries int           `yaml:"max_retries" env:"IM_DELIVERY_MAX_RETRIES"` // 最多重投次数，之后提示客户端主动拉取
+}
+
+// NotifyConfig 配置离线通知通道：Webhook 优先，其次 File；都为空时不发离线通知。
+type NotifyConfig struct {
+	Webhook        string        `yaml:"webhook" env:"IM_NOTIFY_WEBHOOK" secret:"true"`
+	File           string        `yaml:"file" env:"IM_NOTIFY_FILE"`
+	Privacy        bool          `yaml:"privacy" env:"IM_NOTIFY_PRIVACY"` // 通知正文不含消息内容
+	CollapseWindow time.Duration `yaml:"collapse_window" env:"IM_NOTIFY_COLLAPSE"`
+}
+
+// RetentionConfig 配置消息保留策略，按会话类型（见 model.ConversationType）设置保留时长。
+// 超过保留时长的消息先归档为 ArchiveDir 下 gzip 压缩的 JSONL 文件，再分批从 timeline_message 删除；
+// 每个会话始终保留最新一条消息，seq 分配与会话列表不受影响。
+type RetentionConfig struct {
+	Policies   map[string]time.Duration `yaml:"policies" env:"IM_RETENTION_POLICIES"`       // 会话类型 -> 保留时长，0 表示永久保留；环境变量格式 private=0,group=4320h
+	Default    time.Duration            `yaml:"default" env:"IM_RETENTION_DEFAULT"`         // 未列出的会话类型的保留时长，0 表示永久保留
+	Interval   time.Duration            `yaml:"interval" env:"IM_RETENTION_INTERVAL"`       // 归档任务的执行周期
+	BatchSize  int                      `yaml:"batch_size" env:"IM_RETENTION_BATCH_SIZE"`   // 单个归档文件与单次删除的最多条数
+	ArchiveDir string                   `yaml:"archive_dir" env:"IM_RETENTION_ARCHIVE_DIR"` // 归档文件目录
+}
+
+// Enabled 表示是否有会话类型配置了有限的保留时长。
+func (r RetentionConfig) Enabled() bool {
+	if r.Default > 0 {
+		return true
+	}
+	for _, keep := range r.Policies {
+		if keep > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// LogConfig 配置日志输出格式，日志级别可热更新，见 RuntimeConfig.LogLevel。
+type LogConfig struct {
+	Format string `yaml:"format" env:"IM_LOG_FORMAT"` // text | json
+}
+
+// TracingConfig 配置链路追踪导出：none 不导出；otlp 经 OTLP/HTTP 发往 Endpoint；
+// file 把 span 逐行以 JSON 追加到 File，用于测试与本地排查。
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" env:"IM_TRACE_EXPORTER"`
+	Endpoint    string  `yaml:"endpoint" env:"IM_TRACE_ENDPOINT"` // 如 http://localhost:4318，为空时使用 OTEL_EXPORTER_OTLP_ENDPOINT
+	File        string  `yaml:"file" env:"IM_TRACE_FILE"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"IM_TRACE_SAMPLE_RATIO"` // 新链路的采样比例，上游已采样的链路始终采样
+}
+
+// RuntimeConfig 是可热更新的运行时限制，SIGHUP 或管理接口触发重新加载后对存量连接立即生效。
+// 其余配置项修改后需重启。
+type RuntimeConfig struct {
+	ReadLimit          int64           `yaml:"read_limit" env:"IM_WS_READ_LIMIT" json:"read_limit"`                            // 单条消息最大字节数
+	MaxConnections     int             `yaml:"max_connections" env:"IM_MAX_CONNECTIONS" json:"max_connections"`                // 在线连接上限，0 表示不限
+	LogLevel           string          `yaml:"log_level" env:"IM_LOG_LEVEL" json:"log_level"`                                  // debug / info / warn / error
+	MinProtocolVersion int             `yaml:"min_protocol_version" env:"IM_MIN_PROTOCOL_VERSION" json:"min_protocol_version"` // 低于该版本的客户端握手时被拒绝并提示升级
+	RateLimit          RateLimitConfig `yaml:"rate_limit" json:"rate_limit"`
+	FeatureFlags       map[string]bool `yaml:"feature_flags" env:"IM_FEATURE_FLAGS" json:"feature_flags"` // 环境变量格式 name=true,other=false
+	BannedUsers        []string        `yaml:"banned_users" env:"IM_BANNED_USERS" json:"banned_users"`    // 环境变量以逗号分隔
+}
+
+// RateLimitConfig 配置客户端指令的分层限流与违规升级。任一维度超限即拒绝该指令并记一次违规，
+// 窗口内违规达到 MuteAfter 次后临时禁言（拒绝聊天消息），达到 DisconnectAfter 次后断开连接。
+type RateLimitConfig struct {
+	User            RateLimit            `yaml:"user" env:"IM_RATE_USER" json:"user"`                         // 同一用户所有连接合计
+	Connection      RateLimit            `yaml:"connection" env:"IM_RATE_CONNECTION" json:"connection"`       // 单条连接
+	Conversation    RateLimit            `yaml:"conversation" env:"IM_RATE_CONVERSATION" json:"conversation"` // 同一用户在单个会话内
+	Commands        map[string]RateLimit `yaml:"commands" env:"IM_RATE_COMMANDS" json:"commands"`             // 单条连接上按指令限流，环境变量格式 chat=5/10,pull=2/5
+	Window          time.Duration        `yaml:"window" env:"IM_RATE_WINDOW" json:"window"`                   // 违规计数窗口
+	MuteAfter       int                  `yaml:"mute_after" env:"IM_RATE_MUTE_AFTER" json:"mute_after"`       // 0 表示不禁言
+	MuteDuration    time.Duration        `yaml:"mute_duration" env:"IM_RATE_MUTE_DURATION" json:"mute_duration"`
+	DisconnectAfter int                  `yaml:"disconnect_after" env:"IM_RATE_DISCONNECT_AFTER" json:"disconnect_after"` // 0 表示不断开
+}
+
+// RateLimit 是一个令牌桶的参数，Rate 为每秒补充的令牌数，0 表示不限；环境变量格式为 rate/burst。
+type RateLimit struct {
+	Rate  float64 `yaml:"rate" json:"rate"`
+	Burst int     `yaml:"burst" json:"burst"`
+}
+
+func (r RateLimit) String() string {
+	return fmt.Sprintf("%g/%d", r.Rate, r.Burst)
+}
+
+func (r RateLimit) valid() bool {
+	return r.Rate >= 0 && (r.Rate == 0 || r.Burst > 0)
+}
+
+func parseRateLimit(raw string) (RateLimit, error) {
+	rate, burst, ok := strings.Cut(strings.TrimSpace(raw), "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q must be rate/burst", raw)
+	}
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil {
+		return RateLimit{}, err
+	}
+	b, err := strconv.Atoi(burst)
+	if err != nil {
+		return RateLimit{}, err
+	}
+	return RateLimit{Rate: r, Burst: b}, nil
+}
+
+// Enabled 返回功能开关是否打开，未配置的开关视为关闭。
+func (r *RuntimeConfig) Enabled(flag string) bool {
+	return r.FeatureFlags[flag]
+}
+
+// Banned 判断用户是否被封禁。
+func (r *RuntimeConfig) Banned(userID string) bool {
+	for _, id := range r.BannedUsers {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Default 返回默认配置，MySQL 默认指向 docker-compose 中的本地实例。
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ShutdownTimeout: 10 * time.Second,
+			DrainJitter:     5 * time.Second,
+		},
+		WebSocket: WebSocketConfig{
+			ReadDeadline:   90 * time.Second,
+			WriteTimeout:   10 * time.Second,
+			RequestTimeout: 3 * time.Second,
+		},
+		Storage: StorageConfig{
+			Backend:         "sql",
+			Driver:          "mysql",
+			MySQLDSN:        "im_user:im_pass123@tcp(localhost:8848)/go_im?parseTime=true&charset=utf8mb4&loc=Local",
+			SQLitePath:      ":memory:",
+			MaxOpenConns:    20,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: time.Hour,
+			WriteBatchSize:  64,
+			WriteBatchDelay: 2 * time.Millisecond,
+			DedupWindow:     24 * time.Hour,
+		},
+		Pull: PullConfig{
+			PageSize:              50,
+			BatchMaxConversations: 200,
+			BatchMaxMessages:      1000,
+			AckFlushInterval:      time.Second,
+			CacheConversations:    0,
+			CacheMessages:         128,
+		},
+		Delivery: DeliveryConfig{
+			AckTimeout: 10 * time.Second,
+			MaxRetries: 3,
+		},
+		Notify: NotifyConfig{CollapseWindow: 3 * time.Second},
+		Retention: RetentionConfig{
+			Interval:   time.Hour,
+			BatchSize:  1000,
+			ArchiveDir: "data/archive",
+		},
+		Log: LogConfig{Format: "text"},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Runtime: RuntimeConfig{
+			ReadLimit:          4 << 10,
+			LogLevel:           "info",
+			MinProtocolVersion: model.ProtocolV1,
+			RateLimit: RateLimitConfig{
+				User:         RateLimit{Rate: 50, Burst: 100},
+				Connection:   RateLimit{Rate: 30, Burst: 60},
+				Conversation: RateLimit{Rate: 5, Burst: 20},
+				Commands: map[string]RateLimit{
+					"chat":       {Rate: 10, Burst: 20},
+					"pull":       {Rate: 5, Burst: 10},
+					"batch_pull": {Rate: 1, Burst: 5},
+				},
+				Window:          time.Minute,
+				MuteAfter:       20,
+				MuteDuration:    time.Minute,
+				DisconnectAfter: 50,
+			},
+		},
+	}
+}
+
+// Load 依次应用默认值、配置文件（path 为空时跳过）与环境变量，并校验结果。
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	cfg.sources = make(map[string]string)
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config %s: %w", path, err)
+		}
+		var root yaml.Node
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return nil, fmt.Errorf("parse config %s: %w", path, err)
+		}
+		if len(root.Content) > 0 {
+			dec := root.Content[0]
+			if err := dec.Decode(cfg); err != nil {
+				return nil, fmt.Errorf("parse config %s: %w", path, err)
+			}
+			if err := markFileKeys(dec, "", cfg.sources, SourceFile+":"+path); err != nil {
+				return nil, fmt.Errorf("config %s: %w", path, err)
+			}
+		}
+	}
+
+	var errs []error
+	walk(cfg, func(key string, f reflect.StructField, v reflect.Value) {
+		name := f.Tag.Get("env")
+		raw, ok := os.LookupEnv(name)
+		if name == "" || !ok {
+			return
+		}
+		if err := setFromString(v, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s=%q: %w", name, raw, err))
+			return
+		}
+		cfg.sources[key] = SourceEnv + ":" + name
+	})
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate 检查配置取值是否合法。
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.DrainJitter >= 0, "server.drain_jitter must not be negative")
+	check(c.WebSocket.ReadDeadline > 0, "websocket.read_deadline must be positive")
+	check(c.WebSocket.WriteTimeout > 0, "websocket.write_timeout must be positive")
+	check(c.WebSocket.RequestTimeout > 0, "websocket.request_timeout must be positive")
+	check(c.Storage.Backend == "sql" || c.Storage.Backend == "memory", "storage.backend must be sql or memory, got %q", c.Storage.Backend)
+	if c.Storage.Backend == "sql" {
+		check(c.Storage.Driver == "mysql" || c.Storage.Driver == "sqlite", "storage.driver must be mysql or sqlite, got %q", c.Storage.Driver)
+		check(c.Storage.DSN() != "", "storage dsn for driver %q is required", c.Storage.Driver)
+	}
+	check(c.Storage.MaxOpenConns > 0, "storage.max_open_conns must be positive")
+	check(c.Storage.MaxIdleConns >= 0 && c.Storage.MaxIdleConns <= c.Storage.MaxOpenConns, "storage.max_idle_conns must be in [0, max_open_conns]")
+	check(c.Storage.ConnMaxLifetime >= 0, "storage.conn_max_lifetime must not be negative")
+	check(c.Storage.WriteBatchSize >= 1 && c.Storage.WriteBatchSize <= 1000, "storage.write_batch_size must be in [1, 1000]")
+	check(c.Storage.WriteBatchDelay >= 0, "storage.write_batch_delay must not be negative")
+	check(c.Storage.DedupWindow > 0, "storage.dedup_window must be positive")
+	check(c.Storage.MemoryMembers == "" || c.Storage.Backend == "memory", "storage.memory_members only applies to storage.backend=memory")
+	check(c.Pull.PageSize > 0 && c.Pull.PageSize <= 500, "pull.page_size must be in [1, 500]")
+	check(c.Pull.BatchMaxConversations > 0, "pull.batch_max_conversations must be positive")
+	check(c.Pull.BatchMaxMessages >= c.Pull.PageSize, "pull.batch_max_messages must not be less than pull.page_size")
+	check(c.Pull.AckFlushInterval >= 0, "pull.ack_flush_interval must not be negative")
+	check(c.Pull.CacheConversations >= 0, "pull.cache_conversations must not be negative")
+	check(c.Pull.CacheConversations == 0 || c.Pull.CacheMessages > c.Pull.PageSize, "pull.cache_messages must be greater than pull.page_size when the cache is enabled")
+	check(c.Delivery.AckTimeout > 0, "delivery.ack_timeout must be positive")
+	check(c.Delivery.MaxRetries >= 0, "delivery.max_retries must not be negative")
+	check(c.Notify.CollapseWindow > 0, "notify.collapse_window must be positive")
+	for name, keep := range c.Retention.Policies {
+		check(name != "", "retention.policies: conversation type must not be empty")
+		check(keep >= 0, "retention.policies.%s must not be negative", name)
+	}
+	check(c.Retention.Default >= 0, "retention.default must not be negative")
+	check(c.Retention.Interval > 0, "retention.interval must be positive")
+	check(c.Retention.BatchSize > 0 && c.Retention.BatchSize <= 10000, "retention.batch_size must be in [1, 10000]")
+	check(!c.Retention.Enabled() || c.Retention.ArchiveDir != "", "retention.archive_dir is required when retention is enabled")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json, got %q", c.Log.Format)
+	check(c.Tracing.Exporter == "none" || c.Tracing.Exporter == "otlp" || c.Tracing.Exporter == "file", "tracing.exporter must be none, otlp or file, got %q", c.Tracing.Exporter)
+	check(c.Tracing.Exporter != "file" || c.Tracing.File != "", "tracing.file is required for the file exporter")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be in [0, 1]")
+	check(c.Runtime.ReadLimit > 0, "runtime.read_limit must be positive")
+	check(c.Runtime.MaxConnections >= 0, "runtime.max_connections must not be negative")
+	_, err := ParseLogLevel(c.Runtime.LogLevel)
+	check(err == nil, "runtime.log_level: %v", err)
+	check(c.Runtime.MinProtocolVersion >= model.ProtocolV1 && c.Runtime.MinProtocolVersion <= model.ProtocolVersion, "runtime.min_protocol_version must be in [%d, %d]", model.ProtocolV1, model.ProtocolVersion)
+	rl := c.Runtime.RateLimit
+	check(rl.User.valid(), "runtime.rate_limit.user: rate must not be negative and burst must be positive when rate is set")
+	check(rl.Connection.valid(), "runtime.rate_limit.connection: rate must not be negative and burst must be positive when rate is set")
+	check(rl.Conversation.valid(), "runtime.rate_limit.conversation: rate must not be negative and burst must be positive when rate is set")
+	for name, limit := range rl.Commands {
+		_, ok := model.ParseCmdType(name)
+		check(ok, "runtime.rate_limit.commands: unknown command %q", name)
+		check(limit.valid(), "runtime.rate_limit.commands.%s: rate must not be negative and burst must be positive when rate is set", name)
+	}
+	check(rl.MuteAfter >= 0 && rl.DisconnectAfter >= 0, "runtime.rate_limit.mute_after and disconnect_after must not be negative")
+	check(rl.MuteAfter == 0 || rl.MuteDuration > 0, "runtime.rate_limit.mute_duration must be positive when mute_after is set")
+	check(rl.MuteAfter == 0 && rl.DisconnectAfter == 0 || rl.Window > 0, "runtime.rate_limit.window must be positive when escalation is enabled")
+	return errors.Join(errs...)
+}
+
+// Entry 是一项生效配置。
+type Entry struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// Entries 按字段声明顺序返回全部生效配置，secret 字段的值被遮蔽。
+func (c *Config) Entries() []Entry {
+	var entries []Entry
+	walk(c, func(key string, f reflect.StructField, v reflect.Value) {
+		value := fmt.Sprint(v.Interface())
+		if f.Tag.Get("secret") == "true" && value != "" {
+			value = "******"
+		}
+		source, ok := c.sources[key]
+		if !ok {
+			source = SourceDefault
+		}
+		entries = append(entries, Entry{Key: key, Value: value, Source: source})
+	})
+	return entries
+}
+
+// RestartRequired 返回 next 相对当前配置修改了哪些不可热更新的键。
+func (c *Config) RestartRequired(next *Config) []string {
+	values := func(cfg *Config) map[string]string {
+		m := make(map[string]string)
+		walk(cfg, func(key string, _ reflect.StructField, v reflect.Value) { m[key] = fmt.Sprint(v.Interface()) })
+		return m
+	}
+	cur, nxt := values(c), values(next)
+	var keys []string
+	walk(c, func(key string, _ reflect.StructField, _ reflect.Value) {
+		if !strings.HasPrefix(key, "runtime.") && cur[key] != nxt[key] {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+// walk 遍历配置的叶子字段，key 为以点分隔的 yaml 路径。
+func walk(c *Config, fn func(key string, f reflect.StructField, v reflect.Value)) {
+	var visit func(prefix string, v reflect.Value)
+	visit = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("yaml")
+			if !f.IsExported() || tag == "" {
+				continue
+			}
+			key := tag
+			if prefix != "" {
+				key = prefix + "." + tag
+			}
+			if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(RateLimit{}) {
+				visit(key, v.Field(i))
+				continue
+			}
+			fn(key, f, v.Field(i))
+		}
+	}
+	visit("", reflect.ValueOf(c).Elem())
+}
+
+// markFileKeys 记录配置文件中出现的叶子键，未知键视为错误以免拼写错误被静默忽略。
+func markFileKeys(node *yaml.Node, prefix string, sources map[string]string, source string) error {
+	known := make(map[string]bool)
+	walk(Default(), func(key string, _ reflect.StructField, _ reflect.Value) { known[key] = true })
+
+	var visit func(n *yaml.Node, prefix string) error
+	visit = func(n *yaml.Node, prefix string) error {
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if n.Content[i+1].Kind == yaml.MappingNode && !known[key] {
+				if err := visit(n.Content[i+1], key); err != nil {
+					return err
+				}
+				continue
+			}
+			if !known[key] {
+				return fmt.Errorf("unknown key %q", key)
+			}
+			sources[key] = source
+		}
+		return nil
+	}
+	return visit(node, prefix)
+}
+
+func setFromString(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	if v.Type() == reflect.TypeOf(RateLimit{}) {
+		r, err := parseRateLimit(raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(r))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		// 兼容 IM_NOTIFY_PRIVACY=1 的旧写法
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		if v.Type().Elem() == reflect.TypeOf(RateLimit{}) {
+			limits := make(map[string]RateLimit)
+			for _, item := range strings.Split(raw, ",") {
+				name, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+				if name == "" {
+					continue
+				}
+				r, err := parseRateLimit(value)
+				if err != nil {
+					return err
+				}
+				limits[name] = r
+			}
+			v.Set(reflect.ValueOf(limits))
+			return nil
+		}
+		if v.Type().Elem() == reflect.TypeOf(time.Duration(0)) {
+			durations := make(map[string]time.Duration)
+			for _, item := range strings.Split(raw, ",") {
+				name, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+				if name == "" {
+					continue
+				}
+				d, err := time.ParseDuration(strings.TrimSpace(value))
+				if err != nil {
+					return err
+				}
+				durations[name] = d
+			}
+			v.Set(reflect.ValueOf(durations))
+			return nil
+		}
+		flags := make(map[string]bool)
+		for _, item := range strings.Split(raw, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if name == "" {
+				continue
+			}
+			enabled := true
+			if ok {
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return err
+				}
+				enabled = b
+			}
+			flags[name] = enabled
+		}
+		v.Set(reflect.ValueOf(flags))
+	default:
+		return fmt.Errorf("unsupported config kind %s", v.Kind())
+	}
+	return nil
+}
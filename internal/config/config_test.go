@@ -0,0 +1,158 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-im/internal/config"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadAppliesFileThenEnv(t *testing.T) {
+	path := writeConfig(t, "server:\n  addr: \":9000\"\npull:\n  page_size: 20\nwebsocket:\n  request_timeout: 5s\n")
+	t.Setenv("IM_PULL_PAGE_SIZE", "30")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Server.Addr != ":9000" || cfg.Pull.PageSize != 30 || cfg.WebSocket.RequestTimeout != 5*time.Second {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	sources := make(map[string]string)
+	for _, e := range cfg.Entries() {
+		sources[e.Key] = e.Source
+	}
+	if sources["server.addr"] != "file:"+path || sources["pull.page_size"] != "env:IM_PULL_PAGE_SIZE" || sources["delivery.max_retries"] != config.SourceDefault {
+		t.Fatalf("unexpected sources: %v", sources)
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	if _, err := config.Load(writeConfig(t, "servr:\n  addr: x\n")); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+	if _, err := config.Load(writeConfig(t, "pull:\n  page_size: 0\n")); err == nil {
+		t.Fatalf("expected validation error for page_size=0")
+	}
+	t.Setenv("IM_WS_READ_DEADLINE", "soon")
+	if _, err := config.Load(""); err == nil {
+		t.Fatalf("expected env parse error")
+	}
+}
+
+func TestEntriesMaskSecrets(t *testing.T) {
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	for _, e := range cfg.Entries() {
+		if e.Key == "storage.mysql_dsn" && strings.Contains(e.Value, "im_pass123") {
+			t.Fatalf("dsn should be masked, got %q", e.Value)
+		}
+	}
+}
+
+func TestRuntimeSectionFromFileAndEnv(t *testing.T) {
+	path := writeConfig(t, "runtime:\n  feature_flags:\n    batch_pull: true\n  banned_users: [u9]\n  log_level: warn\n")
+	t.Setenv("IM_FEATURE_FLAGS", "batch_pull=false,typing")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	rt := cfg.Runtime
+	if rt.Enabled("batch_pull") || !rt.Enabled("typing") || !rt.Banned("u9") || rt.LogLevel != "warn" {
+		t.Fatalf("unexpected runtime config: %+v", rt)
+	}
+}
+
+func TestRestartRequiredIgnoresRuntimeKeys(t *testing.T) {
+	cur := config.Default()
+	next := config.Default()
+	next.Runtime.MaxConnections = 100
+	next.Runtime.BannedUsers = []string{"u1"}
+	if keys := cur.RestartRequired(next); len(keys) != 0 {
+		t.Fatalf("runtime changes should not require restart, got %v", keys)
+	}
+	next.Server.Addr = ":9090"
+	if keys := cur.RestartRequired(next); len(keys) != 1 || keys[0] != "server.addr" {
+		t.Fatalf("expected server.addr to require restart, got %v", keys)
+	}
+}
+
+func TestRateLimitFromFileAndEnv(t *testing.T) {
+	if _, err := config.Load(writeConfig(t, "runtime:\n  rate_limit:\n    commands:\n      chatt: {rate: 1, burst: 1}\n")); err == nil {
+		t.Fatalf("expected error for unknown command")
+	}
+	if _, err := config.Load(writeConfig(t, "runtime:\n  rate_limit:\n    user: {rate: 1, burst: 0}\n")); err == nil {
+		t.Fatalf("expected error for zero burst")
+	}
+
+	path := writeConfig(t, "runtime:\n  rate_limit:\n    user: {rate: 2, burst: 4}\n    commands:\n      chat: {rate: 1, burst: 1}\n")
+	t.Setenv("IM_RATE_CONNECTION", "3/6")
+	t.Setenv("IM_RATE_COMMANDS", "pull=0.5/2")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	rl := cfg.Runtime.RateLimit
+	if rl.User != (config.RateLimit{Rate: 2, Burst: 4}) || rl.Connection != (config.RateLimit{Rate: 3, Burst: 6}) {
+		t.Fatalf("unexpected rate limits: %+v", rl)
+	}
+	if len(rl.Commands) != 1 || rl.Commands["pull"] != (config.RateLimit{Rate: 0.5, Burst: 2}) {
+		t.Fatalf("env should replace command limits, got %v", rl.Commands)
+	}
+}
+
+func TestRetentionPoliciesFromFileAndEnv(t *testing.T) {
+	path := writeConfig(t, "retention:\n  policies:\n    private: 0s\n    group: 4320h\n")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := cfg.Retention.Policies; got["private"] != 0 || got["group"] != 4320*time.Hour || !cfg.Retention.Enabled() {
+		t.Fatalf("unexpected retention policies: %v", got)
+	}
+
+	t.Setenv("IM_RETENTION_POLICIES", "group=720h,channel=24h")
+	cfg, err = config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := cfg.Retention.Policies; len(got) != 2 || got["group"] != 720*time.Hour || got["channel"] != 24*time.Hour {
+		t.Fatalf("env should replace policies, got %v", got)
+	}
+
+	t.Setenv("IM_RETENTION_ARCHIVE_DIR", "")
+	if _, err := config.Load(path); err == nil || !strings.Contains(err.Error(), "archive_dir") {
+		t.Fatalf("expected archive_dir to be required, got %v", err)
+	}
+}
+
+func TestInMemorySQLite(t *testing.T) {
+	for path, want := range map[string]bool{
+		":memory:":                         true,
+		"file:im?mode=memory&cache=shared": true,
+		"data/im.db":                       false,
+	} {
+		s := config.StorageConfig{Driver: "sqlite", SQLitePath: path}
+		if got := s.InMemorySQLite(); got != want {
+			t.Fatalf("InMemorySQLite(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if (config.StorageConfig{Driver: "mysql", SQLitePath: ":memory:"}).InMemorySQLite() {
+		t.Fatalf("mysql driver should never be in-memory SQLite")
+	}
+}
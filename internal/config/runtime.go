@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// Runtime 持有当前生效的 RuntimeConfig。重新加载时整体替换，
+// 读取方每次使用时 Load 一次，拿到的快照在一次处理内保持一致。
+type Runtime struct {
+	p atomic.Pointer[RuntimeConfig]
+}
+
+func NewRuntime(cfg RuntimeConfig) *Runtime {
+	r := &Runtime{}
+	r.Store(cfg)
+	return r
+}
+
+// Load 返回当前快照，调用方不得修改。
+func (r *Runtime) Load() *RuntimeConfig {
+	return r.p.Load()
+}
+
+// Store 原子替换快照。
+func (r *Runtime) Store(cfg RuntimeConfig) {
+	r.p.Store(&cfg)
+}
+
+// ParseLogLevel 解析日志级别名称。
+func ParseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLog 替代 gin.Logger，以结构化字段记录每个 HTTP 请求。
+// 记录路由模板而非原始路径与查询串，避免 /api/devices/:token 之类的令牌进入日志。
+func AccessLog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(c.Request.Context(), level, "HTTP 请求",
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.Int("status", c.Writer.Status()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("remote", c.ClientIP()),
+			slog.String("user", c.Query("user_id")),
+		)
+	}
+}
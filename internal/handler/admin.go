@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-im/internal/config"
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+// ReloadFunc 重新加载配置，返回生效的运行时配置与需重启才能生效的键。
+type ReloadFunc func() (*config.RuntimeConfig, []string, error)
+
+// AdminHandler 提供运维管理接口，请求需携带 Authorization: Bearer <admin_token>。
+type AdminHandler struct {
+	token       string
+	reload      ReloadFunc
+	connManager *service.ConnectionManager
+	convSvc     *service.ConversationService
+	logger      *slog.Logger
+}
+
+func NewAdminHandler(token string, reload ReloadFunc, connManager *service.ConnectionManager, convSvc *service.ConversationService, logger *slog.Logger) *AdminHandler {
+	return &AdminHandler{token: token, reload: reload, connManager: connManager, convSvc: convSvc, logger: logger}
+}
+
+// Register 注册路由到 /admin 组，token 为空时不开放任何管理接口。
+func (h *AdminHandler) Register(admin *gin.RouterGroup) {
+	if h.token == "" {
+		return
+	}
+	admin.Use(h.authenticate)
+	admin.POST("/reload", h.Reload)
+	admin.GET("/users", h.ListOnlineUsers)
+	admin.GET("/users/:user_id/connections", h.ListUserConnections)
+	admin.DELETE("/connections/:conn_id", h.Disconnect)
+	admin.GET("/seq-heads", h.SeqHeads)
+}
+
+func (h *AdminHandler) authenticate(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		respondError(c, model.CodeUnauthorized, "未授权")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// Reload 处理 POST /admin/reload，与 SIGHUP 效果相同。
+func (h *AdminHandler) Reload(c *gin.Context) {
+	runtime, restart, err := h.reload()
+	if err != nil {
+		h.logger.Warn("重新加载配置失败", "err", err)
+		respondError(c, model.CodeBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runtime": runtime, "restart_required": restart})
+}
+
+// connectionView 为管理接口中的一条连接。
+type connectionView struct {
+	UserID       string    `json:"user_id"`
+	ConnID       string    `json:"conn_id"`
+	Device       string    `json:"device,omitempty"`
+	RemoteAddr   string    `json:"remote_addr"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	LastActive   time.Time `json:"last_active"`
+	Protocol     int       `json:"protocol_version"`
+	Capabilities []string  `json:"capabilities"`
+}
+
+func newConnectionView(c *service.Connection) connectionView {
+	return connectionView{
+		UserID:       c.UserID,
+		ConnID:       c.ID,
+		Device:       c.Device,
+		RemoteAddr:   c.RemoteAddr,
+		ConnectedAt:  c.ConnectedAt,
+		LastActive:   c.LastActive(),
+		Protocol:     c.Protocol.Version,
+		Capabilities: c.Protocol.Capabilities,
+	}
+}
+
+// onlineUser 为在线用户及其在线设备。
+type onlineUser struct {
+	UserID  string   `json:"user_id"`
+	Devices []string `json:"devices"`
+}
+
+// ListOnlineUsers 处理 GET /admin/users，按用户 ID 排序返回在线用户与设备。
+func (h *AdminHandler) ListOnlineUsers(c *gin.Context) {
+	byUser := make(map[string][]string)
+	for _, conn := range h.connManager.Snapshot() {
+		byUser[conn.UserID] = append(byUser[conn.UserID], conn.Device)
+	}
+	users := make([]onlineUser, 0, len(byUser))
+	for userID, devices := range byUser {
+		users = append(users, onlineUser{UserID: userID, Devices: devices})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserID < users[j].UserID })
+	c.JSON(http.StatusOK, gin.H{"count": len(users), "users": users})
+}
+
+// ListUserConnections 处理 GET /admin/users/:user_id/connections，返回建连时间与最近活动时间。
+func (h *AdminHandler) ListUserConnections(c *gin.Context) {
+	conns := h.connManager.UserConnections(c.Param("user_id"))
+	views := make([]connectionView, 0, len(conns))
+	for _, conn := range conns {
+		views = append(views, newConnectionView(conn))
+	}
+	c.JSON(http.StatusOK, gin.H{"connections": views})
+}
+
+// Disconnect 处理 DELETE /admin/connections/:conn_id，以 1008 关闭码断开连接。
+func (h *AdminHandler) Disconnect(c *gin.Context) {
+	connID := c.Param("conn_id")
+	if !h.connManager.Disconnect(connID, "disconnected by admin") {
+		respondError(c, model.CodeNotFound, "连接不存在")
+		return
+	}
+	h.logger.Info("管理员断开连接", "conn_id", connID, "remote", c.ClientIP())
+	c.Status(http.StatusNoContent)
+}
+
+// SeqHeads 处理 GET /admin/seq-heads，可重复传 conversation_id 指定会话，limit 限制条数。
+func (h *AdminHandler) SeqHeads(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	heads, err := h.convSvc.SeqHeads(c.Request.Context(), c.QueryArray("conversation_id"), limit)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "查询会话 seq 失败", "err", err)
+		respondError(c, service.ErrorCode(err), "查询会话 seq 失败")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"seq_heads": heads})
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+// ConversationHandler 提供会话列表、会话个人设置与隐藏消息的 REST 接口。
+// 与 WebSocket 一致，当前通过 user_id 查询参数识别用户。
+type ConversationHandler struct {
+	convSvc *service.ConversationService
+	hideSvc *service.HideService
+	logger  *slog.Logger
+}
+
+func NewConversationHandler(convSvc *service.ConversationService, hideSvc *service.HideService, logger *slog.Logger) *ConversationHandler {
+	return &ConversationHandler{convSvc: convSvc, hideSvc: hideSvc, logger: logger}
+}
+
+// Register 注册路由到 /api 组。
+func (h *ConversationHandler) Register(api *gin.RouterGroup) {
+	api.GET("/conversations", h.ListConversations)
+	api.GET("/conversations/:conversation_id/settings", h.GetSettings)
+	api.PATCH("/conversations/:conversation_id/settings", h.UpdateSettings)
+	api.POST("/conversations/:conversation_id/clear", h.ClearHistory)
+	api.DELETE("/conversations/:conversation_id", h.DeleteConversation)
+	api.GET("/conversations/:conversation_id/hidden", h.ListHidden)
+	api.POST("/messages/hide", h.HideMessages)
+}
+
+// ListConversations 处理 GET /api/conversations，archived=1 时包含已归档会话。
+func (h *ConversationHandler) ListConversations(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		respondError(c, model.CodeBadRequest, "user_id 不能为空")
+		return
+	}
+	list, err := h.convSvc.ListConversations(c.Request.Context(), userID, c.Query("archived") == "1")
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "查询会话列表失败", "user", userID, "err", err)
+		respondError(c, service.ErrorCode(err), "查询会话列表失败")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversations": list})
+}
+
+// GetSettings 处理 GET /api/conversations/:conversation_id/settings。
+func (h *ConversationHandler) GetSettings(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		respondError(c, model.CodeBadRequest, "user_id 不能为空")
+		return
+	}
+	settings, err := h.convSvc.GetSettings(c.Request.Context(), userID, c.Param("conversation_id"))
+	if errors.Is(err, service.ErrNotMember) {
+		respondError(c, model.CodeNotMember, "不是会话成员")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "查询会话设置失败", "user", userID, "conversation_id", c.Param("conversation_id"), "err", err)
+		respondError(c, service.ErrorCode(err), "查询会话设置失败")
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings 处理 PATCH /api/conversations/:conversation_id/settings，请求体为 SettingsPatch。
+func (h *ConversationHandler) UpdateSettings(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		respondError(c, model.CodeBadRequest, "user_id 不能为空")
+		return
+	}
+	var patch service.SettingsPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		respondError(c, model.CodeBadRequest, "请求体解析失败")
+		return
+	}
+	settings, err := h.convSvc.UpdateSettings(c.Request.Context(), userID, c.Param("conversation_id"), patch, nil)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidSettings) {
+			respondError(c, model.CodeBadRequest, "会话设置参数非法")
+			return
+		}
+		if errors.Is(err, service.ErrNotMember) {
+			respondError(c, model.CodeNotMember, "不是会话成员")
+			return
+		}
+		h.logger.ErrorContext(c.Request.Context(), "修改会话设置失败", "user", userID, "conversation_id", c.Param("conversation_id"), "err", err)
+		respondError(c, service.ErrorCode(err), "修改会话设置失败")
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+type clearHistoryRequest struct {
+	Seq int64 `json:"seq"` // 清空到该 seq（含），缺省为最新
+}
+
+// ClearHistory 处理 POST /api/conversations/:conversation_id/clear。
+func (h *ConversationHandler) ClearHistory(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		respondError(c, model.CodeBadRequest, "user_id 不能为空")
+		return
+	}
+	var req clearHistoryRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, model.CodeBadRequest, "请求体解析失败")
+			return
+		}
+	}
+	settings, err := h.convSvc.ClearHistory(c.Request.Context(), userID, c.Param("conversation_id"), req.Seq, nil)
+	if errors.Is(err, service.ErrNotMember) {
+		respondError(c, model.CodeNotMember, "不是会话成员")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "清空会话历史失败", "user", userID, "conversation_id", c.Param("conversation_id"), "err", err)
+		respondError(c, service.ErrorCode(err), "清空会话历史失败")
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// DeleteConversation 处理 DELETE /api/conversations/:conversation_id，clear_history=1 时同时清空历史。
+func (h *ConversationHandler) DeleteConversation(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		respondError(c, model.CodeBadRequest, "user_id 不能为空")
+		return
+	}
+	clearHistory, _ := strconv.ParseBool(c.DefaultQuery("clear_history", "0"))
+	settings, err := h.convSvc.DeleteConversation(c.Request.Context(), userID, c.Param("conversation_id"), clearHistory, nil)
+	if errors.Is(err, service.ErrNotMember) {
+		respondError(c, model.CodeNotMember, "不是会话成员")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "删除会话失败", "user", userID, "conversation_id", c.Param("conversation_id"), "err", err)
+		respondError(c, service.ErrorCode(err), "删除会话失败")
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// hideMessagesRequest 中的每条消息以 conversation_id + seq 或 sender_id + msg_id 指定。
+type hideMessagesRequest struct {
+	Messages []model.MessageRef `json:"messages" binding:"required"`
+}
+
+// HideMessages 处理 POST /api/messages/hide，仅对自己隐藏一条或多条消息。
+func (h *ConversationHandler) HideMessages(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		respondError(c, model.CodeBadRequest, "user_id 不能为空")
+		return
+	}
+	var req hideMessagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Messages) == 0 {
+		respondError(c, model.CodeBadRequest, "请求体解析失败")
+		return
+	}
+	refs, err := h.hideSvc.HideMessages(c.Request.Context(), userID, req.Messages, nil)
+	if err != nil {
+		if errors.Is(err, service.ErrTooManyMessages) {
+			respondError(c, model.CodeTooLarge, "单次隐藏消息过多")
+			return
+		}
+		h.logger.ErrorContext(c.Request.Context(), "隐藏消息失败", "user", userID, "err", err)
+		respondError(c, service.ErrorCode(err), "隐藏消息失败")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hidden": refs})
+}
+
+// ListHidden 处理 GET /api/conversations/:conversation_id/hidden。
+func (h *ConversationHandler) ListHidden(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		respondError(c, model.CodeBadRequest, "user_id 不能为空")
+		return
+	}
+	refs, err := h.hideSvc.ListHidden(c.Request.Context(), userID, c.Param("conversation_id"))
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "查询隐藏消息失败", "user", userID, "conversation_id", c.Param("conversation_id"), "err", err)
+		respondError(c, service.ErrorCode(err), "查询隐藏消息失败")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hidden": refs})
+}
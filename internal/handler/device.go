@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+// DeviceHandler 提供设备令牌登记的 REST 接口。
+// 与 WebSocket 一致，当前通过 user_id 查询参数识别用户。
+type DeviceHandler struct {
+	deviceSvc *service.DeviceService
+	logger    *slog.Logger
+}
+
+func NewDeviceHandler(deviceSvc *service.DeviceService, logger *slog.Logger) *DeviceHandler {
+	return &DeviceHandler{deviceSvc: deviceSvc, logger: logger}
+}
+
+type registerDeviceRequest struct {
+	Platform string `json:"platform" binding:"required"`
+	Token    string `json:"token" binding:"required"`
+}
+
+// Register 注册路由到 /api 组。
+func (h *DeviceHandler) Register(api *gin.RouterGroup) {
+	api.POST("/devices", h.RegisterDevice)
+	api.DELETE("/devices/:token", h.UnregisterDevice)
+}
+
+// RegisterDevice 处理 POST /api/devices。
+func (h *DeviceHandler) RegisterDevice(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		respondError(c, model.CodeBadRequest, "user_id 不能为空")
+		return
+	}
+	var req registerDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, model.CodeBadRequest, "请求体解析失败")
+		return
+	}
+	if err := h.deviceSvc.Register(c.Request.Context(), userID, req.Platform, req.Token); err != nil {
+		if errors.Is(err, service.ErrUnsupportedPlatform) {
+			respondError(c, model.CodeBadRequest, "不支持的平台")
+			return
+		}
+		h.logger.ErrorContext(c.Request.Context(), "登记设备失败", "user", userID, "platform", req.Platform, "err", err)
+		respondError(c, service.ErrorCode(err), "登记设备失败")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// UnregisterDevice 处理 DELETE /api/devices/:token。
+func (h *DeviceHandler) UnregisterDevice(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		respondError(c, model.CodeBadRequest, "user_id 不能为空")
+		return
+	}
+	if err := h.deviceSvc.Unregister(c.Request.Context(), userID, c.Param("token")); err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "注销设备失败", "user", userID, "err", err)
+		respondError(c, service.ErrorCode(err), "注销设备失败")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
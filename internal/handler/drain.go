@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"go-im/internal/model"
+)
+
+// drainPollInterval 为等待处理中指令完成时的轮询间隔。
+const drainPollInterval = 20 * time.Millisecond
+
+// Drain 排空所有 WebSocket 连接，用于停机前：
+// 拒绝新的握手与聊天消息，向每个客户端发送 CmdGoAway（重连延迟在 [0, jitter) 内随机），
+// 等待处理中的指令完成直到 ctx 到期，最后以 1001 Going Away 关闭连接。
+// 只有在未能等到指令全部完成时才返回 ctx 的错误，连接无论如何都会被关闭。
+func (h *WebSocketHandler) Drain(ctx context.Context, jitter time.Duration) error {
+	h.draining.Store(true)
+
+	conns := h.connManager.Snapshot()
+	h.logger.Info("开始排空连接", "count", len(conns))
+	for _, c := range conns {
+		var delay time.Duration
+		if jitter > 0 {
+			delay = time.Duration(rand.Int63n(int64(jitter)))
+		}
+		packet := model.OutputPacket{Cmd: model.CmdGoAway, Code: model.CodeOK, Payload: model.GoAwayPayload{
+			Reason:           "server shutting down",
+			ReconnectDelayMs: delay.Milliseconds(),
+		}}
+		if err := c.WriteJSON(packet); err != nil {
+			c.Log.Warn("发送下线通知失败", "err", err)
+		}
+	}
+
+	err := h.waitInflight(ctx)
+	if err != nil {
+		h.logger.Warn("等待处理中的指令超时", "inflight", h.inflight.Load())
+	}
+	for _, c := range h.connManager.Snapshot() {
+		_ = c.CloseWith(websocket.CloseGoingAway, "server shutting down")
+	}
+	return err
+}
+
+// Draining 返回是否处于下线排空中。
+func (h *WebSocketHandler) Draining() bool {
+	return h.draining.Load()
+}
+
+func (h *WebSocketHandler) waitInflight(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for h.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
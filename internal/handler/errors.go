@@ -0,0 +1,13 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"go-im/internal/model"
+)
+
+// respondError 按错误码目录写出 REST 错误响应：HTTP 状态码由错误码决定，
+// 响应体在原有 error 提示之外带上 code、reason 与 retryable，与 WebSocket 回包一致。
+func respondError(c *gin.Context, code model.ErrorCode, message string) {
+	c.JSON(code.HTTPStatus(), gin.H{"error": message, "code": code, "reason": code.Reason(), "retryable": code.Retryable()})
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout 为一次就绪检查的总时限，避免探针被慢依赖拖住。
+const readinessTimeout = 2 * time.Second
+
+// ReadinessCheck 检查一项外部依赖是否可用。
+type ReadinessCheck func(ctx context.Context) error
+
+// HealthHandler 提供存活与就绪探针，不需要鉴权。
+type HealthHandler struct {
+	ws     *WebSocketHandler
+	checks map[string]ReadinessCheck
+	logger *slog.Logger
+}
+
+// NewHealthHandler 创建探针 Handler，checks 为按名称登记的依赖检查，如数据库。
+func NewHealthHandler(ws *WebSocketHandler, checks map[string]ReadinessCheck, logger *slog.Logger) *HealthHandler {
+	return &HealthHandler{ws: ws, checks: checks, logger: logger}
+}
+
+// Register 注册 /healthz 与 /readyz。
+func (h *HealthHandler) Register(router gin.IRoutes) {
+	router.GET("/healthz", h.Healthz)
+	router.GET("/readyz", h.Readyz)
+}
+
+// Healthz 处理 GET /healthz，进程能响应即视为存活。
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz 处理 GET /readyz。下线排空中或任一依赖检查失败时返回 503，负载均衡据此摘除节点。
+// 失败原因只写日志，响应中不暴露依赖的地址等细节。
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	ready := true
+	results := make(map[string]string, len(h.checks)+1)
+	results["draining"] = "ok"
+	if h.ws.Draining() {
+		ready = false
+		results["draining"] = "draining"
+	}
+	for name, check := range h.checks {
+		if err := check(ctx); err != nil {
+			h.logger.Warn("就绪检查失败", "check", name, "err", err)
+			ready = false
+			results[name] = "fail"
+			continue
+		}
+		results[name] = "ok"
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": results})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": results})
+}
@@ -3,129 +3,541 @@ package handler
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"go-im/internal/config"
+	"go-im/internal/metrics"
 	"go-im/internal/model"
 	"go-im/internal/service"
+	"go-im/internal/tracing"
 )
 
-const (
-	readDeadline = 90 * time.Second // 允许心跳丢 2-3 次（30s/跳）
-	writeTimeout = 10 * time.Second // 写超时防止阻塞
-	readLimit    = int64(4 << 10)   // 单条消息最大 4KB
-)
+// flagEchoTraceID 为运行时功能开关：打开后回包携带 trace_id。
+const flagEchoTraceID = "echo_trace_id"
+
+var tracer = otel.Tracer("go-im/internal/handler")
+
+// requestIDKey 为 context 中本条指令 request_id 的键，回包时回显。
+type requestIDKey struct{}
 
 // WebSocketHandler 负责握手、注册连接以及消息读循环。
 type WebSocketHandler struct {
 	connManager *service.ConnectionManager
 	messageSvc  *service.MessageService
+	pullSvc     *service.PullService
+	convSvc     *service.ConversationService
+	hideSvc     *service.HideService
+	tracker     *service.DeliveryTracker
+	limiter     *service.RateLimiter
+	acks        *service.AckCoalescer
+	cfg         config.WebSocketConfig
+	runtime     *config.Runtime
+	logger      *slog.Logger
 	upgrader    websocket.Upgrader
+
+	draining atomic.Bool  // 下线排空中，拒绝新的握手与聊天消息
+	inflight atomic.Int64 // 正在处理的指令数
 }
 
-// NewWebSocketHandler 创建 Handler，允许注入连接管理器与消息服务。
-func NewWebSocketHandler(connManager *service.ConnectionManager, messageSvc *service.MessageService) *WebSocketHandler {
+// NewWebSocketHandler 创建 Handler，允许注入连接管理器、各业务服务、送达跟踪器、限流器、ACK 合并器与连接参数。
+// runtime 中的限制每次使用时读取，热更新后对存量连接立即生效。每条连接的日志派生自 logger。
+func NewWebSocketHandler(connManager *service.ConnectionManager, messageSvc *service.MessageService, pullSvc *service.PullService, convSvc *service.ConversationService, hideSvc *service.HideService, tracker *service.DeliveryTracker, limiter *service.RateLimiter, acks *service.AckCoalescer, cfg config.WebSocketConfig, runtime *config.Runtime, logger *slog.Logger) *WebSocketHandler {
 	return &WebSocketHandler{
 		connManager: connManager,
 		messageSvc:  messageSvc,
+		pullSvc:     pullSvc,
+		convSvc:     convSvc,
+		hideSvc:     hideSvc,
+		tracker:     tracker,
+		limiter:     limiter,
+		acks:        acks,
+		cfg:         cfg,
+		runtime:     runtime,
+		logger:      logger,
 		upgrader: websocket.Upgrader{
 			// 生产环境需校验 Origin
 			CheckOrigin: func(r *http.Request) bool { return true },
+			// 允许协商 permessage-deflate，是否压缩下行由握手能力决定
+			EnableCompression: true,
 		},
 	}
 }
 
-// HandleWebSocket 提供给 Gin 的路由函数。
+// HandleWebSocket 提供给 Gin 的路由函数，device_id 查询参数为可选的设备标识。
+// 客户端通过 protocol_version 与 capabilities（逗号分隔）查询参数握手，协商结果写入升级响应头，
+// 并在连接建立后以 CmdLogin 下发；不带 protocol_version 的旧客户端按 v1 处理。
 func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 	userID := c.Query("user_id")
 	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id 不能为空"})
+		respondError(c, model.CodeBadRequest, "user_id 不能为空")
+		return
+	}
+	if h.draining.Load() {
+		respondError(c, model.CodeUnavailable, "服务器正在下线")
+		return
+	}
+	if h.runtime.Load().Banned(userID) {
+		respondError(c, model.CodeForbidden, "用户已被封禁")
 		return
 	}
 
-	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	version := 0
+	if raw := c.Query("protocol_version"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < model.ProtocolV1 {
+			respondError(c, model.CodeBadRequest, "protocol_version 非法")
+			return
+		}
+		version = n
+	}
+	var capabilities []string
+	if raw := c.Query("capabilities"); raw != "" {
+		capabilities = strings.Split(raw, ",")
+	}
+	minVersion := h.runtime.Load().MinProtocolVersion
+	proto, err := service.Negotiate(version, capabilities, minVersion)
 	if err != nil {
-		log.Printf("用户 %s 升级 WebSocket 失败: %v", userID, err)
+		respondError(c, service.ErrorCode(err), fmt.Sprintf("客户端协议版本过低，请升级到 v%d 及以上", minVersion))
 		return
 	}
 
-	h.connManager.Add(userID, conn)
-	log.Printf("用户 %s 已连接，当前在线: %v", userID, h.connManager.ListIDs())
+	header := http.Header{}
+	header.Set("X-IM-Protocol-Version", strconv.Itoa(proto.Version))
+	header.Set("X-IM-Capabilities", strings.Join(proto.Capabilities, ","))
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, header)
+	if err != nil {
+		h.logger.Warn("升级 WebSocket 失败", "user", userID, "remote", c.ClientIP(), "err", err)
+		return
+	}
+	// 仅在客户端声明 compression 时压缩下行，未协商 permessage-deflate 时该设置不生效
+	conn.EnableWriteCompression(proto.Has(model.CapCompression))
+
+	client := service.NewConnection(userID, c.Query("device_id"), c.ClientIP(), conn, h.cfg.WriteTimeout, h.logger)
+	client.Protocol = proto
+	if err := h.connManager.Add(userID, client); err != nil {
+		client.Log.Warn("拒绝连接", "err", err)
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too many connections")
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(h.cfg.WriteTimeout))
+		_ = conn.Close()
+		return
+	}
+	client.Log.Info("已连接", "online", h.connManager.Count(), "protocol", proto.Version, "capabilities", proto.Capabilities)
+	if version != 0 {
+		_ = client.WriteJSON(model.OutputPacket{Cmd: model.CmdLogin, Code: model.CodeOK, Payload: model.HandshakePayload{
+			ProtocolVersion:    proto.Version,
+			MinProtocolVersion: minVersion,
+			Capabilities:       proto.Capabilities,
+		}})
+	}
 
 	// 独立 goroutine 读消息，避免阻塞握手返回
-	go h.readLoop(userID, conn)
+	go h.readLoop(userID, conn, client)
 }
 
 // readLoop 读取客户端消息，先支持心跳，后续扩展业务指令。
-func (h *WebSocketHandler) readLoop(userID string, conn *websocket.Conn) {
+// conn 只用于读，所有写入经由 client 串行化。
+func (h *WebSocketHandler) readLoop(userID string, conn *websocket.Conn, client *service.Connection) {
 	defer func() {
-		h.connManager.Remove(userID)
-		log.Printf("用户 %s 连接关闭", userID)
+		h.connManager.Remove(userID, client)
+		h.tracker.Forget(client)
+		// 断开时立即写入合并中的已读位点，其他设备拉取到的未读数不必等下一个刷新周期
+		ctx, cancel := context.WithTimeout(context.Background(), h.cfg.RequestTimeout)
+		if err := h.acks.FlushUser(ctx, userID); err != nil {
+			client.Log.Warn("写入已读位点失败，等待定期重试", "err", err)
+		}
+		cancel()
+		client.Log.Info("连接关闭")
 	}()
 
-	conn.SetReadLimit(readLimit)
-	_ = conn.SetReadDeadline(time.Now().Add(readDeadline))
+	_ = conn.SetReadDeadline(time.Now().Add(h.cfg.ReadDeadline))
 	conn.SetPongHandler(func(string) error {
 		// 客户端 Pong 刷新超时
-		return conn.SetReadDeadline(time.Now().Add(readDeadline))
+		return conn.SetReadDeadline(time.Now().Add(h.cfg.ReadDeadline))
 	})
 
+	limits := service.NewConnLimits()
 	for {
+		// 每条消息前读取一次，热更新的读上限对存量连接同样生效
+		conn.SetReadLimit(h.runtime.Load().ReadLimit)
+
+		_, r, err := conn.NextReader()
+		if err != nil {
+			client.Log.Info("读取消息失败", "err", err)
+			return
+		}
+
+		// 每条指令一条链路：根 span 覆盖解码与处理，解码单独计时
+		ctx, span := tracer.Start(context.Background(), "ws.packet", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("im.user", userID),
+			attribute.String("im.conn_id", client.ID),
+		))
 		var packet model.InputPacket
-		if err := conn.ReadJSON(&packet); err != nil {
-			log.Printf("读取用户 %s 消息失败: %v", userID, err)
+		_, decodeSpan := tracer.Start(ctx, "ws.decode")
+		err = json.NewDecoder(r).Decode(&packet)
+		tracing.RecordError(decodeSpan, err)
+		decodeSpan.End()
+		if err != nil {
+			tracing.RecordError(span, err)
+			span.End()
+			client.Log.Info("读取消息失败", "err", err)
 			return
 		}
+		span.SetName("ws.packet " + packet.Cmd.String())
+		span.SetAttributes(
+			attribute.String("im.cmd", packet.Cmd.String()),
+			attribute.String("im.msg_id", packet.MsgId),
+			attribute.String("im.conversation_id", packet.ConversationId),
+			attribute.String("im.request_id", packet.RequestId),
+		)
+		ctx = context.WithValue(ctx, requestIDKey{}, packet.RequestId)
+
+		client.Touch(time.Now())
+		metrics.PacketsIn.WithLabelValues(packet.Cmd.String()).Inc()
 
-		switch packet.Cmd {
-		case model.CmdHeartbeat:
-			if err := h.writeJSON(conn, model.OutputPacket{Cmd: model.CmdHeartbeat, Code: 0}); err != nil {
-				log.Printf("心跳回复失败 user=%s: %v", userID, err)
-				return
-			}
-		case model.CmdChat:
-			if err := h.handleChat(userID, packet, conn); err != nil {
-				log.Printf("处理聊天消息失败 user=%s: %v", userID, err)
-				return
-			}
-		default:
-			// 预留：登录、聊天、拉取等指令后续接入 service 层
-			log.Printf("收到用户 %s 的指令 cmd=%d msg_id=%s", userID, packet.Cmd, packet.MsgId)
+		// 计入处理中的指令，下线排空时等待其完成
+		h.inflight.Add(1)
+		err = h.handlePacket(ctx, userID, packet, client, limits)
+		h.inflight.Add(-1)
+		tracing.RecordError(span, err)
+		span.End()
+		if err != nil {
+			return
 		}
 	}
 }
 
-// writeJSON 统一设置写超时，防止写阻塞。
-func (h *WebSocketHandler) writeJSON(conn *websocket.Conn, payload interface{}) error {
-	_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-	return conn.WriteJSON(payload)
+// reply 写回包：标记为 response 并回显本条指令的 request_id，
+// 开启 echo_trace_id 时附带 trace_id，便于客户端与服务端链路对照。
+func (h *WebSocketHandler) reply(ctx context.Context, conn *service.Connection, packet model.OutputPacket) error {
+	packet.Kind = model.KindResponse
+	packet.RequestId, _ = ctx.Value(requestIDKey{}).(string)
+	if h.runtime.Load().Enabled(flagEchoTraceID) {
+		packet.TraceId = tracing.TraceID(ctx)
+	}
+	return conn.WriteJSON(packet)
+}
+
+// errRateLimitDisconnect 表示连接因屡次触发限流被断开。
+var errRateLimitDisconnect = errors.New("rate limit exceeded")
+
+// admit 对指令执行限流，被拒绝时回复 429（禁言期间为 423）并返回 false；
+// 屡次违规时关闭连接并返回 errRateLimitDisconnect。
+func (h *WebSocketHandler) admit(ctx context.Context, userID string, packet model.InputPacket, client *service.Connection, limits *service.ConnLimits) (bool, error) {
+	now := time.Now()
+	verdict, scope := h.limiter.Check(now, h.runtime.Load().RateLimit, userID, limits, packet)
+	switch verdict {
+	case service.RateAllowed:
+		return true, nil
+	case service.RateLimited:
+		packetLog(ctx, client, packet).Debug("指令被限流", "scope", scope)
+		return false, h.reply(ctx, client, model.OutputPacket{Cmd: packet.Cmd, MsgId: packet.MsgId, ConversationId: packet.ConversationId}.WithError(model.CodeRateLimited, "发送过于频繁!"))
+	case service.RateMuted:
+		until := h.limiter.MutedUntil(userID, now)
+		if scope == service.RateScopeMuted {
+			packetLog(ctx, client, packet).Debug("禁言期间的消息被拒绝", "until", until)
+		} else {
+			packetLog(ctx, client, packet).Info("用户被临时禁言", "scope", scope, "until", until)
+		}
+		return false, h.reply(ctx, client, model.OutputPacket{Cmd: packet.Cmd, MsgId: packet.MsgId, ConversationId: packet.ConversationId}.WithError(model.CodeMuted, "发送过于频繁，已被临时禁言至 "+until.Format(time.RFC3339)+"!"))
+	default:
+		packetLog(ctx, client, packet).Warn("屡次触发限流，断开连接", "scope", scope)
+		_ = client.CloseWith(websocket.ClosePolicyViolation, errRateLimitDisconnect.Error())
+		return false, errRateLimitDisconnect
+	}
+}
+
+// handlePacket 限流后按指令分发，返回错误时读循环退出并关闭连接。
+// 失败日志带上连接字段以及本条指令的 cmd、msg_id 与 conversation_id。
+func (h *WebSocketHandler) handlePacket(ctx context.Context, userID string, packet model.InputPacket, client *service.Connection, limits *service.ConnLimits) error {
+	if ok, err := h.admit(ctx, userID, packet, client, limits); !ok {
+		return err
+	}
+	var err error
+	switch packet.Cmd {
+	case model.CmdHeartbeat:
+		err = h.reply(ctx, client, model.OutputPacket{Cmd: model.CmdHeartbeat, Code: model.CodeOK})
+	case model.CmdChat:
+		// 排空期间不再接收新消息，客户端重连到其他节点后按 msg_id 幂等重发
+		if h.draining.Load() {
+			return h.reply(ctx, client, model.OutputPacket{Cmd: model.CmdChat, MsgId: packet.MsgId}.WithError(model.CodeUnavailable, "服务器正在下线，请重连后重发!"))
+		}
+		err = h.handleChat(ctx, userID, packet, client)
+	case model.CmdPull:
+		err = h.handlePull(ctx, userID, packet, client)
+	case model.CmdBatchPull:
+		err = h.handleBatchPull(ctx, userID, packet, client)
+	case model.CmdAck:
+		err = h.handleAck(ctx, userID, packet, client)
+	case model.CmdBatchAck:
+		err = h.handleBatchAck(ctx, userID, packet, client)
+	case model.CmdDeliverAck:
+		// 送达位点写库失败只记录日志，不断开连接
+		if ackErr := h.handleDeliverAck(ctx, userID, packet, client); ackErr != nil {
+			packetLog(ctx, client, packet).Warn("记录送达位点失败", "seq", packet.Seq, "err", ackErr)
+		}
+	case model.CmdConversationSettings:
+		err = h.handleSettings(ctx, userID, packet, client)
+	case model.CmdHideMessages:
+		err = h.handleHide(ctx, userID, packet, client)
+	case model.CmdClearHistory, model.CmdDeleteConversation:
+		err = h.handleConversationRemoval(ctx, userID, packet, client)
+	default:
+		// 预留：登录等指令后续接入 service 层
+		packetLog(ctx, client, packet).Debug("收到未处理的指令")
+	}
+	if err != nil {
+		packetLog(ctx, client, packet).Error("处理指令失败", "err", err)
+	}
+	return err
+}
+
+// packetLog 返回带本条指令字段与 trace_id 的连接级 logger。
+func packetLog(ctx context.Context, client *service.Connection, packet model.InputPacket) *slog.Logger {
+	return client.Log.With("cmd", packet.Cmd.String(), "msg_id", packet.MsgId, "request_id", packet.RequestId, "conversation_id", packet.ConversationId, "trace_id", tracing.TraceID(ctx))
 }
 
 // handleChat 处理聊天消息：解析、写库并返回 seq。
-func (h *WebSocketHandler) handleChat(userID string, packet model.InputPacket, conn *websocket.Conn) error {
-	// TODO: 校验 conversation_id，反序列化 payload 为 ChatPayload，调用 messageSvc.HandleChat，
-	// 将结果写回客户端（注意设置超时与错误处理）
-	// return errors.New("handleChat not implemented")
-	if packet.ConversationId == ""{
-		return h.writeJSON(conn, model.OutputPacket{Cmd : model.CmdChat, Code: 400, MsgId: packet.MsgId, Payload: "ConversationId 不能为空!"})
+func (h *WebSocketHandler) handleChat(ctx context.Context, userID string, packet model.InputPacket, conn *service.Connection) error {
+	if packet.ConversationId == "" {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdChat, MsgId: packet.MsgId}.WithError(model.CodeBadRequest, "ConversationId 不能为空!"))
 	}
 
 	var payload service.ChatPayload
-	if err := json.Unmarshal(packet.Payload, &payload); err != nil{
-		return h.writeJSON(conn, model.OutputPacket{Cmd : model.CmdChat, Code: 400, MsgId: packet.MsgId, Payload: "Payload 解析失败!"})
+	if err := json.Unmarshal(packet.Payload, &payload); err != nil {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdChat, MsgId: packet.MsgId}.WithError(model.CodeBadRequest, "Payload 解析失败!"))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.RequestTimeout)
+	defer cancel()
+
+	out, err := h.messageSvc.HandleChat(ctx, userID, conn, packet, payload)
+	if err != nil {
+		_ = h.reply(ctx, conn, out)
+		return err
+	}
+	return h.reply(ctx, conn, out)
+}
+
+// handlePull 处理拉取：从 cursor_seq 之后按页返回消息。
+func (h *WebSocketHandler) handlePull(ctx context.Context, userID string, packet model.InputPacket, conn *service.Connection) error {
+	if packet.ConversationId == "" {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdPull}.WithError(model.CodeBadRequest, "ConversationId 不能为空!"))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := h.pullSvc.PullMessages(ctx, userID, packet.ConversationId, packet.CursorSeq, 0)
+	if errors.Is(err, service.ErrNotMember) {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdPull, ConversationId: packet.ConversationId}.WithError(model.CodeNotMember, "不是会话成员!"))
+	}
+	if err != nil {
+		_ = h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdPull, ConversationId: packet.ConversationId}.WithError(service.ErrorCode(err), ""))
+		return err
 	}
+	return h.reply(ctx, conn, model.OutputPacket{
+		Cmd:            model.CmdPull,
+		Code:           model.CodeOK,
+		ConversationId: packet.ConversationId,
+		NextCursorSeq:  res.NextCursorSeq,
+		HasMore:        res.HasMore,
+		ArchivedSeq:    res.ArchivedSeq,
+		Payload:        model.NewChatMessages(res.Messages),
+	})
+}
+
+// handleBatchPull 处理批量拉取：payload 为 BatchPullPayload，回包 payload 为按请求顺序排列的 []PullPage，
+// 回包的 HasMore 表示是否有任一会话还有更多消息。连接需在握手时协商 batch_pull 能力。
+// 用户不是成员的会话不拉取，对应页的 code 为 not_member。
+func (h *WebSocketHandler) handleBatchPull(ctx context.Context, userID string, packet model.InputPacket, conn *service.Connection) error {
+	if !conn.Has(model.CapBatchPull) {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchPull}.WithError(model.CodeBadRequest, "握手未协商 batch_pull 能力，请逐个会话拉取!"))
+	}
+	var payload model.BatchPullPayload
+	if err := json.Unmarshal(packet.Payload, &payload); err != nil {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchPull}.WithError(model.CodeBadRequest, "Payload 解析失败!"))
+	}
+	for _, c := range payload.Conversations {
+		if c.ConversationId == "" {
+			return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchPull}.WithError(model.CodeBadRequest, "ConversationId 不能为空!"))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.RequestTimeout)
+	defer cancel()
 
-	ctx, cancer := context.WithTimeout(context.Background(), 3 * time.Second)
-	defer cancer()
-	
-	output_packet, err := h.messageSvc.HandleChat(ctx, userID, packet, payload)
-	if err != nil{
-		h.writeJSON(conn, output_packet)
+	pages, err := h.pullSvc.BatchPull(ctx, userID, payload.Conversations, payload.Limit, payload.TotalLimit)
+	if errors.Is(err, service.ErrTooManyConversations) {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchPull}.WithError(model.CodeTooLarge, "单次拉取会话过多!"))
+	}
+	if err != nil {
+		_ = h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchPull}.WithError(service.ErrorCode(err), ""))
+		return err
+	}
+	hasMore := false
+	for _, p := range pages {
+		hasMore = hasMore || p.HasMore
+	}
+	return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchPull, Code: model.CodeOK, HasMore: hasMore, Payload: pages})
+}
+
+// handleAck 处理已读 ACK：更新用户在会话的 last_ack_seq，写库经 ACK 合并器延迟合并。
+func (h *WebSocketHandler) handleAck(ctx context.Context, userID string, packet model.InputPacket, conn *service.Connection) error {
+	if packet.ConversationId == "" {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdAck}.WithError(model.CodeBadRequest, "ConversationId 不能为空!"))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.RequestTimeout)
+	defer cancel()
+
+	err := h.acks.Ack(ctx, userID, packet.ConversationId, packet.Seq)
+	if errors.Is(err, service.ErrNotMember) {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdAck, ConversationId: packet.ConversationId}.WithError(model.CodeNotMember, "不是会话成员!"))
+	}
+	if err != nil {
+		_ = h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdAck, ConversationId: packet.ConversationId}.WithError(service.ErrorCode(err), ""))
+		return err
+	}
+	return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdAck, Code: model.CodeOK, ConversationId: packet.ConversationId, Seq: packet.Seq})
+}
+
+// handleBatchAck 处理批量已读 ACK：payload 为 BatchAckPayload，一次更新多个会话的 last_ack_seq。
+func (h *WebSocketHandler) handleBatchAck(ctx context.Context, userID string, packet model.InputPacket, conn *service.Connection) error {
+	var payload model.BatchAckPayload
+	if err := json.Unmarshal(packet.Payload, &payload); err != nil {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchAck}.WithError(model.CodeBadRequest, "Payload 解析失败!"))
+	}
+	for _, a := range payload.Acks {
+		if a.ConversationId == "" {
+			return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchAck}.WithError(model.CodeBadRequest, "ConversationId 不能为空!"))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.RequestTimeout)
+	defer cancel()
+
+	err := h.acks.AckBatch(ctx, userID, payload.Acks)
+	if errors.Is(err, service.ErrTooManyAcks) {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchAck}.WithError(model.CodeTooLarge, "单次确认会话过多!"))
+	}
+	if errors.Is(err, service.ErrNotMember) {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchAck}.WithError(model.CodeNotMember, "包含不是成员的会话!"))
+	}
+	if err != nil {
+		_ = h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchAck}.WithError(service.ErrorCode(err), ""))
+		return err
+	}
+	return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdBatchAck, Code: model.CodeOK})
+}
+
+// handleDeliverAck 处理送达回执，不回包；回执按本连接实际推送过的消息截断，见 DeliveryTracker.Ack。
+func (h *WebSocketHandler) handleDeliverAck(ctx context.Context, userID string, packet model.InputPacket, conn *service.Connection) error {
+	if packet.ConversationId == "" || packet.Seq <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.RequestTimeout)
+	defer cancel()
+
+	return h.tracker.Ack(ctx, userID, conn, packet.ConversationId, packet.Seq)
+}
+
+// handleSettings 处理会话设置：payload 为空时查询，否则按 SettingsPatch 修改并同步到其他设备。
+func (h *WebSocketHandler) handleSettings(ctx context.Context, userID string, packet model.InputPacket, conn *service.Connection) error {
+	if packet.ConversationId == "" {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdConversationSettings}.WithError(model.CodeBadRequest, "ConversationId 不能为空!"))
+	}
+	var patch service.SettingsPatch
+	if len(packet.Payload) > 0 {
+		if err := json.Unmarshal(packet.Payload, &patch); err != nil {
+			return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdConversationSettings, ConversationId: packet.ConversationId}.WithError(model.CodeBadRequest, "Payload 解析失败!"))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.RequestTimeout)
+	defer cancel()
+
+	settings, err := h.convSvc.UpdateSettings(ctx, userID, packet.ConversationId, patch, conn)
+	if errors.Is(err, service.ErrInvalidSettings) {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdConversationSettings, ConversationId: packet.ConversationId}.WithError(model.CodeBadRequest, "会话设置参数非法!"))
+	}
+	if errors.Is(err, service.ErrNotMember) {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdConversationSettings, ConversationId: packet.ConversationId}.WithError(model.CodeNotMember, "不是会话成员!"))
+	}
+	if err != nil {
+		_ = h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdConversationSettings, ConversationId: packet.ConversationId}.WithError(service.ErrorCode(err), ""))
+		return err
+	}
+	return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdConversationSettings, Code: model.CodeOK, ConversationId: packet.ConversationId, Payload: settings})
+}
+
+// deleteConversationPayload 为 CmdDeleteConversation 的可选负载。
+type deleteConversationPayload struct {
+	ClearHistory bool `json:"clear_history"`
+}
+
+// handleConversationRemoval 处理清空历史与删除会话，成功后回包最新的会话状态。
+func (h *WebSocketHandler) handleConversationRemoval(ctx context.Context, userID string, packet model.InputPacket, conn *service.Connection) error {
+	if packet.ConversationId == "" {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: packet.Cmd}.WithError(model.CodeBadRequest, "ConversationId 不能为空!"))
+	}
+	var payload deleteConversationPayload
+	if packet.Cmd == model.CmdDeleteConversation && len(packet.Payload) > 0 {
+		if err := json.Unmarshal(packet.Payload, &payload); err != nil {
+			return h.reply(ctx, conn, model.OutputPacket{Cmd: packet.Cmd, ConversationId: packet.ConversationId}.WithError(model.CodeBadRequest, "Payload 解析失败!"))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.RequestTimeout)
+	defer cancel()
+
+	var settings model.ConversationSettings
+	var err error
+	if packet.Cmd == model.CmdClearHistory {
+		settings, err = h.convSvc.ClearHistory(ctx, userID, packet.ConversationId, packet.Seq, conn)
+	} else {
+		settings, err = h.convSvc.DeleteConversation(ctx, userID, packet.ConversationId, payload.ClearHistory, conn)
+	}
+	if errors.Is(err, service.ErrNotMember) {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: packet.Cmd, ConversationId: packet.ConversationId}.WithError(model.CodeNotMember, "不是会话成员!"))
+	}
+	if err != nil {
+		_ = h.reply(ctx, conn, model.OutputPacket{Cmd: packet.Cmd, ConversationId: packet.ConversationId}.WithError(service.ErrorCode(err), ""))
+		return err
+	}
+	return h.reply(ctx, conn, model.OutputPacket{Cmd: packet.Cmd, Code: model.CodeOK, ConversationId: packet.ConversationId, Payload: settings})
+}
+
+// handleHide 处理仅对自己删除，payload 为 MessageRef 数组，回包为实际隐藏的消息。
+func (h *WebSocketHandler) handleHide(ctx context.Context, userID string, packet model.InputPacket, conn *service.Connection) error {
+	var refs []model.MessageRef
+	if err := json.Unmarshal(packet.Payload, &refs); err != nil || len(refs) == 0 {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdHideMessages}.WithError(model.CodeBadRequest, "Payload 解析失败!"))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.RequestTimeout)
+	defer cancel()
+
+	hidden, err := h.hideSvc.HideMessages(ctx, userID, refs, conn)
+	if errors.Is(err, service.ErrTooManyMessages) {
+		return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdHideMessages}.WithError(model.CodeTooLarge, "单次隐藏消息过多!"))
+	}
+	if err != nil {
+		_ = h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdHideMessages}.WithError(service.ErrorCode(err), ""))
 		return err
 	}
-	return h.writeJSON(conn, output_packet)
+	return h.reply(ctx, conn, model.OutputPacket{Cmd: model.CmdHideMessages, Code: model.CodeOK, Payload: hidden})
 }
@@ -0,0 +1,167 @@
+// Package metrics 定义 Prometheus 指标。指标注册在独立的 Registry 上，由 /metrics 暴露。
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "im"
+
+// Registry 汇总本进程的全部指标，包含 Go 运行时与进程指标。
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// PacketsIn 按指令统计收到的客户端包。
+	PacketsIn = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "packets_in_total",
+		Help:      "Packets received from clients by command.",
+	}, []string{"cmd"})
+
+	// PacketsOut 按指令统计成功写出的服务端包。
+	PacketsOut = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "packets_out_total",
+		Help:      "Packets written to clients by command.",
+	}, []string{"cmd"})
+
+	// WriteWaiting 为等待连接写锁的写操作数，即各连接写队列深度之和。
+	WriteWaiting = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_write_waiting",
+		Help:      "Writes currently waiting for a connection write lock.",
+	})
+
+	// ChatDuration 为 HandleChat 的处理耗时。
+	ChatDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "chat_duration_seconds",
+		Help:      "Latency of MessageService.HandleChat.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+
+	// ChatResults 按回包 code 统计 HandleChat 的结果。
+	ChatResults = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chat_results_total",
+		Help:      "HandleChat results by response code.",
+	}, []string{"code"})
+
+	// ChatWriteBatchSize 为组提交每个事务写入的消息数。
+	ChatWriteBatchSize = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "chat_write_batch_size",
+		Help:      "Chat messages committed per group-commit transaction.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	// SeqAllocWait 为分配会话 seq 时加锁读取最大 seq 的耗时，反映同一会话并发写入的争用。
+	SeqAllocWait = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "seq_alloc_wait_seconds",
+		Help:      "Time spent acquiring the per-conversation max seq under lock.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 12),
+	})
+
+	// PushResults 按结果统计 PushService 的推送：success、failure、offline（转离线通知）、skipped。
+	PushResults = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "push_total",
+		Help:      "PushService deliveries by result.",
+	}, []string{"result"})
+
+	// PullPageSize 为每次拉取返回的消息条数。
+	PullPageSize = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pull_page_size",
+		Help:      "Messages returned per pull.",
+		Buckets:   []float64{0, 1, 5, 10, 20, 50, 100, 200, 500},
+	})
+
+	// PullCacheLookups 按结果统计拉取时的热点消息缓存查询。
+	PullCacheLookups = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pull_cache_lookups_total",
+		Help:      "Recent message cache lookups on pull by result (hit, miss).",
+	}, []string{"result"})
+
+	// AcksReceived 统计收到的已读 ACK 条目，与 AckWrites 对比可看出合并效果。
+	AcksReceived = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "acks_total",
+		Help:      "Read acks received from clients, including batch entries.",
+	})
+
+	// AckWrites 统计写入存储的已读位点次数。
+	AckWrites = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ack_writes_total",
+		Help:      "Read ack positions written to storage after coalescing.",
+	})
+
+	// MessagesArchived 统计按保留策略归档并从数据库删除的消息。
+	MessagesArchived = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_archived_total",
+		Help:      "Messages archived and deleted by the retention job.",
+	})
+
+	// RetentionFailures 统计归档失败的会话次数，失败的会话在下一轮重新归档。
+	RetentionFailures = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retention_failures_total",
+		Help:      "Conversations whose retention sweep failed and will be retried on the next run.",
+	})
+
+	// RateLimitHits 按维度统计被限流拒绝的指令：user、connection、conversation、command、muted。
+	RateLimitHits = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_hits_total",
+		Help:      "Client packets rejected by rate limiting, by scope.",
+	}, []string{"scope"})
+
+	// RateLimitActions 统计违规升级的处罚：mute、disconnect。
+	RateLimitActions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_actions_total",
+		Help:      "Escalations applied to repeat rate limit offenders.",
+	}, []string{"action"})
+)
+
+// 推送结果标签。
+const (
+	PushSuccess = "success"
+	PushFailure = "failure"
+	PushOffline = "offline"
+	PushSkipped = "skipped"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterGauge 注册一个取值时回调的 gauge，用于在线连接数、队列深度等由组件自身维护的状态。
+func RegisterGauge(name, help string, fn func() float64) {
+	factory.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Name: name, Help: help}, fn)
+}
+
+// RegisterDBStats 注册数据库连接池指标。
+func RegisterDBStats(db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, "im"))
+}
+
+// Handler 返回 /metrics 的 HTTP handler。
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// 会话通知级别。
+const (
+	NotifyAll       int8 = iota // 正常通知
+	NotifyNoPreview             // 通知但不显示消息内容
+	NotifyNone                  // 不通知
+)
+
+// ConversationType 返回会话 ID 中第一个 "_" 之前的部分作为会话类型，如 group_101 为 group、private_u1_u2 为 private；
+// 不含 "_" 的会话类型为空。
+func ConversationType(conversationID string) string {
+	t, _, ok := strings.Cut(conversationID, "_")
+	if !ok {
+		return ""
+	}
+	return t
+}
+
+// ConversationSettings 是用户对单个会话的个人设置与个人水位，随多端同步下发。
+type ConversationSettings struct {
+	ConversationID string `json:"conversation_id"`
+	Muted          bool   `json:"muted"`
+	MuteUntil      int64  `json:"mute_until,omitempty"` // 免打扰截止时间戳(ms)，0 表示永久
+	PinRank        int64  `json:"pin_rank,omitempty"`   // 0 表示未置顶，越大越靠前
+	Archived       bool   `json:"archived"`
+	NotifyLevel    int8   `json:"notify_level"`
+	ClearedSeq     int64  `json:"cleared_seq,omitempty"` // 只读：清空历史水位
+	DeletedSeq     int64  `json:"deleted_seq,omitempty"` // 只读：从列表删除时的 seq
+}
+
+// MutedAt 判断 now 时刻免打扰是否生效，已过期的免打扰视为关闭。
+func (s ConversationSettings) MutedAt(now time.Time) bool {
+	if !s.Muted {
+		return false
+	}
+	return s.MuteUntil == 0 || now.UnixMilli() < s.MuteUntil
+}
+
+// DeletedAt 判断会话在最新 seq 为 lastSeq 时是否仍处于删除状态。
+func (s ConversationSettings) DeletedAt(lastSeq int64) bool {
+	return s.DeletedSeq > 0 && lastSeq <= s.DeletedSeq
+}
+
+// Pinned 表示会话是否置顶。
+func (s ConversationSettings) Pinned() bool {
+	return s.PinRank > 0
+}
+
+// SeqHead 为会话当前最大的 seq，供运维排查推送与拉取进度。
+type SeqHead struct {
+	ConversationID string `json:"conversation_id"`
+	Seq            int64  `json:"seq"`
+}
+
+// ConversationSummary 是会话列表中的一项。
+type ConversationSummary struct {
+	ConversationSettings
+	LastSeq      int64 `json:"last_seq"`       // 会话最新消息的 seq
+	LastSendTime int64 `json:"last_send_time"` // 会话最新消息的发送时间(ms)
+	LastAckSeq   int64 `json:"last_ack_seq"`
+	Unread       int64 `json:"unread"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// DeviceToken 对应 device_token 表，记录用户设备的离线推送令牌。
+// 同一令牌只归属一个用户，设备换号登录时覆盖 user_id。
+type DeviceToken struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement"`
+	UserID    string    `gorm:"column:user_id;size:64;not null;index:idx_user"`
+	Platform  string    `gorm:"column:platform;size:16;not null"`
+	Token     string    `gorm:"column:token;size:255;not null;uniqueIndex:uk_token"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (DeviceToken) TableName() string {
+	return "device_token"
+}
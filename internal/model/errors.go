@@ -0,0 +1,74 @@
+package model
+
+import "net/http"
+
+// ErrorCode 是 OutputPacket.Code 与 REST 错误响应使用的错误码，0 表示成功。
+// 除历史遗留的 CodeInternal 外，取值与对应的 HTTP 状态码一致，已有客户端的判断不受影响；
+// 同一状态码下需要细分的错误在状态码后追加一位序号，如 CodeNotMember，客户端应以 reason 区分。
+type ErrorCode int
+
+const (
+	CodeOK              ErrorCode = 0
+	CodeInternal        ErrorCode = 1    // 服务端内部错误
+	CodeBadRequest      ErrorCode = 400  // 参数缺失或格式错误
+	CodeUnauthorized    ErrorCode = 401  // 未登录或凭证无效
+	CodeForbidden       ErrorCode = 403  // 无权执行，如用户被封禁
+	CodeNotMember       ErrorCode = 4031 // 不是会话成员，不区分会话是否存在
+	CodeNotFound        ErrorCode = 404  // 资源不存在，如连接已断开
+	CodeDuplicate       ErrorCode = 409  // 重复的请求，如 msg_id 已被发送方的另一条消息使用
+	CodeTooLarge        ErrorCode = 413  // 消息或批量请求超过上限
+	CodeMuted           ErrorCode = 423  // 因刷屏被临时禁言
+	CodeUpgradeRequired ErrorCode = 426  // 客户端协议版本过低，需要升级
+	CodeRateLimited     ErrorCode = 429  // 触发限流，稍后可重试
+	CodeUnavailable     ErrorCode = 503  // 暂时不可用（下线、超时等），可原样重试
+)
+
+type errorInfo struct {
+	reason     string
+	retryable  bool
+	httpStatus int
+}
+
+var errorCatalog = map[ErrorCode]errorInfo{
+	CodeOK:              {"", false, http.StatusOK},
+	CodeInternal:        {"internal", false, http.StatusInternalServerError},
+	CodeBadRequest:      {"bad_request", false, http.StatusBadRequest},
+	CodeUnauthorized:    {"unauthorized", false, http.StatusUnauthorized},
+	CodeForbidden:       {"forbidden", false, http.StatusForbidden},
+	CodeNotMember:       {"not_member", false, http.StatusForbidden},
+	CodeNotFound:        {"not_found", false, http.StatusNotFound},
+	CodeDuplicate:       {"duplicate", false, http.StatusConflict},
+	CodeTooLarge:        {"too_large", false, http.StatusRequestEntityTooLarge},
+	CodeMuted:           {"muted", false, http.StatusLocked},
+	CodeUpgradeRequired: {"upgrade_required", false, http.StatusUpgradeRequired},
+	CodeRateLimited:     {"rate_limited", true, http.StatusTooManyRequests},
+	CodeUnavailable:     {"unavailable", true, http.StatusServiceUnavailable},
+}
+
+// info 返回错误码的目录项，未登记的错误码按内部错误处理。
+func (c ErrorCode) info() errorInfo {
+	if info, ok := errorCatalog[c]; ok {
+		return info
+	}
+	return errorCatalog[CodeInternal]
+}
+
+// Reason 返回机器可读的错误原因，如 rate_limited，成功时为空。
+func (c ErrorCode) Reason() string { return c.info().reason }
+
+// Retryable 表示客户端是否可以原样重试该请求（聊天消息按 msg_id 幂等）。
+func (c ErrorCode) Retryable() bool { return c.info().retryable }
+
+// HTTPStatus 返回 REST 接口使用的 HTTP 状态码。
+func (c ErrorCode) HTTPStatus() int { return c.info().httpStatus }
+
+// WithError 将 p 标记为失败：填入错误码、原因与是否可重试，message 非空时作为 Payload 展示给用户。
+func (p OutputPacket) WithError(code ErrorCode, message string) OutputPacket {
+	p.Code = code
+	p.Reason = code.Reason()
+	p.Retryable = code.Retryable()
+	if message != "" {
+		p.Payload = message
+	}
+	return p
+}
@@ -1,18 +1,24 @@
 package model
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
 
 // TimelineMessage 对应 timeline_message 表。
+// MsgID 由客户端生成，只在同一发送方内唯一，幂等去重见 MessageDedup。
 type TimelineMessage struct {
 	ID             uint64    `gorm:"primaryKey;autoIncrement"`
-	MsgID          string    `gorm:"column:msg_id;size:64;not null;uniqueIndex:uk_msg_id"`
-	ConversationID string    `gorm:"column:conversation_id;size:64;not null;uniqueIndex:uk_conv_seq;index:idx_conv_seq"`
+	MsgID          string    `gorm:"column:msg_id;size:64;not null;index:idx_msg_id"`
+	ConversationID string    `gorm:"column:conversation_id;size:64;not null;uniqueIndex:uk_conv_seq;index:idx_conv_seq;index:idx_conv_send_time"`
 	Seq            uint64    `gorm:"column:seq;not null;uniqueIndex:uk_conv_seq;index:idx_conv_seq"`
 	SenderID       string    `gorm:"column:sender_id;size:64;not null"`
 	Content        string    `gorm:"column:content;size:4096"`
 	MsgType        int8      `gorm:"column:msg_type;default:1"`
-	Status         int8      `gorm:"column:status;default:0"`
-	SendTime       int64     `gorm:"column:send_time;not null"`
+	Status         int8      `gorm:"column:status;default:0"` // 见 MsgStatusNormal 等
+	SendTime       int64     `gorm:"column:send_time;not null;index:idx_conv_send_time"`
 	CreatedAt      time.Time `gorm:"column:created_at;autoCreateTime"`
 }
 
@@ -21,14 +27,144 @@ func (TimelineMessage) TableName() string {
 	return "timeline_message"
 }
 
-// UserConversationState 对应 user_conversation_state 表，用于存储 ACK 位点。
-type UserConversationState struct {
-	UserID         string    `gorm:"column:user_id;size:64;primaryKey"`
+// 消息状态，对应 TimelineMessage.Status。0 为早期写入的消息，按正常消息处理。
+const (
+	MsgStatusNormal   int8 = 1
+	MsgStatusEdited   int8 = 2 // 发送后被编辑过
+	MsgStatusRecalled int8 = 3 // 已撤回，内容不再下发
+)
+
+// ContentHash 返回消息类型与内容的摘要，用于判断同一 msg_id 的重发是否为同一条消息。
+func (m *TimelineMessage) ContentHash() string {
+	sum := sha256.Sum256([]byte(strconv.Itoa(int(m.MsgType)) + "\x00" + m.Content))
+	return hex.EncodeToString(sum[:])
+}
+
+// MessageDedup 对应 message_dedup 表，记录发送方在去重窗口内用过的 msg_id 及其写入结果。
+// 超过窗口的记录被定期清理，之后同一 msg_id 会被当作新消息。
+type MessageDedup struct {
+	SenderID       string    `gorm:"column:sender_id;size:64;primaryKey"`
+	MsgID          string    `gorm:"column:msg_id;size:64;primaryKey"`
+	ConversationID string    `gorm:"column:conversation_id;size:64;not null"`
+	Seq            uint64    `gorm:"column:seq;not null"`
+	ContentHash    string    `gorm:"column:content_hash;size:64;not null;default:''"` // 为空表示未知（迁移回填），不比较内容
+	CreatedAt      time.Time `gorm:"column:created_at;autoCreateTime;index:idx_dedup_created"`
+}
+
+func (MessageDedup) TableName() string {
+	return "message_dedup"
+}
+
+// NewMessageDedup 返回 msg 写入成功后对应的去重记录。
+func NewMessageDedup(msg *TimelineMessage) MessageDedup {
+	return MessageDedup{
+		SenderID:       msg.SenderID,
+		MsgID:          msg.MsgID,
+		ConversationID: msg.ConversationID,
+		Seq:            msg.Seq,
+		ContentHash:    msg.ContentHash(),
+	}
+}
+
+// Matches 判断 msg 是否为该记录对应消息的重发：会话相同且内容一致。
+func (d *MessageDedup) Matches(msg *TimelineMessage) bool {
+	if d.ConversationID != msg.ConversationID {
+		return false
+	}
+	return d.ContentHash == "" || d.ContentHash == msg.ContentHash()
+}
+
+// ConversationArchive 对应 conversation_archive 表，记录会话按保留策略归档到的位置：
+// <= ArchivedSeq 的消息已写入归档并从 timeline_message 删除。
+type ConversationArchive struct {
 	ConversationID string    `gorm:"column:conversation_id;size:64;primaryKey"`
-	LastAckSeq     int64     `gorm:"column:last_ack_seq;default:0"`
+	ArchivedSeq    int64     `gorm:"column:archived_seq;not null;default:0"`
 	UpdatedAt      time.Time `gorm:"column:updated_at;autoUpdateTime"`
 }
 
+func (ConversationArchive) TableName() string {
+	return "conversation_archive"
+}
+
+// UserConversationState 对应 user_conversation_state 表，用于存储 ACK 位点。
+// LastDeliveredSeq 记录客户端回执确认送达的最大 seq，LastAckSeq 记录已读位点。
+// ClearedSeq 为清空历史的水位，<= 该 seq 的消息对该用户不可见；
+// DeletedSeq 为从会话列表删除时的最新 seq，会话有更新的消息后重新出现。
+// 其余字段为用户对该会话的个人设置，见 ConversationSettings。
+type UserConversationState struct {
+	UserID           string    `gorm:"column:user_id;size:64;primaryKey"`
+	ConversationID   string    `gorm:"column:conversation_id;size:64;primaryKey"`
+	LastAckSeq       int64     `gorm:"column:last_ack_seq;default:0"`
+	LastDeliveredSeq int64     `gorm:"column:last_delivered_seq;default:0"`
+	Muted            bool      `gorm:"column:muted;default:false"`
+	MuteUntil        int64     `gorm:"column:mute_until;default:0"`
+	PinRank          int64     `gorm:"column:pin_rank;default:0"`
+	Archived         bool      `gorm:"column:archived;default:false"`
+	NotifyLevel      int8      `gorm:"column:notify_level;default:0"`
+	ClearedSeq       int64     `gorm:"column:cleared_seq;default:0"`
+	DeletedSeq       int64     `gorm:"column:deleted_seq;default:0"`
+	UpdatedAt        time.Time `gorm:"column:updated_at;autoUpdateTime"`
+}
+
 func (UserConversationState) TableName() string {
 	return "user_conversation_state"
 }
+
+// Settings 提取状态中的个人设置部分。
+func (s UserConversationState) Settings() ConversationSettings {
+	return ConversationSettings{
+		ConversationID: s.ConversationID,
+		Muted:          s.Muted,
+		MuteUntil:      s.MuteUntil,
+		PinRank:        s.PinRank,
+		Archived:       s.Archived,
+		NotifyLevel:    s.NotifyLevel,
+		ClearedSeq:     s.ClearedSeq,
+		DeletedSeq:     s.DeletedSeq,
+	}
+}
+
+// GroupMember 对应 group_member 表，会话成员用于推送扇出。
+type GroupMember struct {
+	GroupID  string `gorm:"column:group_id;size:64;primaryKey"`
+	UserID   string `gorm:"column:user_id;size:64;primaryKey"`
+	JoinTime int64  `gorm:"column:join_time;not null"`
+}
+
+func (GroupMember) TableName() string {
+	return "group_member"
+}
+
+// HiddenMessage 对应 user_hidden_message 表，记录用户“仅对自己删除”的消息。
+// 消息以 (conversation_id, seq) 标识；msg_id 只在发送方内唯一，仅随记录返回给客户端。
+type HiddenMessage struct {
+	UserID         string    `gorm:"column:user_id;size:64;primaryKey"`
+	ConversationID string    `gorm:"column:conversation_id;size:64;primaryKey"`
+	Seq            uint64    `gorm:"column:seq;primaryKey;autoIncrement:false"`
+	MsgID          string    `gorm:"column:msg_id;size:64;not null"`
+	CreatedAt      time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (HiddenMessage) TableName() string {
+	return "user_hidden_message"
+}
+
+// MessageRef 在请求中指定一条消息：ConversationID + Seq，或 SenderID + MsgID（msg_id 只在发送方内唯一）。
+type MessageRef struct {
+	ConversationID string `json:"conversation_id,omitempty"`
+	Seq            int64  `json:"seq,omitempty"`
+	SenderID       string `json:"sender_id,omitempty"`
+	MsgID          string `json:"msg_id,omitempty"`
+}
+
+// Valid 判断是否给出了两种定位方式之一。
+func (r MessageRef) Valid() bool {
+	return (r.ConversationID != "" && r.Seq > 0) || (r.SenderID != "" && r.MsgID != "")
+}
+
+// SeqRange 是会话内的 seq 区间 (FromSeq, ToSeq]。
+type SeqRange struct {
+	ConversationID string
+	FromSeq        int64
+	ToSeq          int64
+}
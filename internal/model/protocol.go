@@ -4,29 +4,216 @@ import "encoding/json"
 
 // 客户端发给服务器的包
 type CmdType int
+
+const (
+	CmdHeartbeat            CmdType = iota // 心跳
+	CmdLogin                               // 登录
+	CmdChat                                // 发送消息
+	CmdPull                                // 核心：主动拉取消息
+	CmdAck                                 // 消息确认
+	CmdDeliverAck                          // 送达回执：客户端确认已收到推送的 seq
+	CmdSyncRequired                        // 服务端提示：推送多次未确认，客户端需主动拉取
+	CmdConversationSettings                // 查询/修改会话个人设置，修改后同步到其他设备
+	CmdClearHistory                        // 为自己清空会话历史到 seq（缺省为最新）
+	CmdDeleteConversation                  // 从自己的会话列表删除会话，新消息到达后重新出现
+	CmdHideMessages                        // 仅对自己删除消息，payload 为 []MessageRef；同时用作多端同步包
+	CmdGoAway                              // 服务端提示：节点即将下线，客户端按 GoAwayPayload 延迟后重连
+	CmdBatchPull                           // 一次拉取多个会话（需协商 batch_pull 能力），payload 为 BatchPullPayload，回包 payload 为 []PullPage
+	CmdBatchAck                            // 一次确认多个会话的已读位点，payload 为 BatchAckPayload
+)
+
+var cmdNames = [...]string{
+	CmdHeartbeat:            "heartbeat",
+	CmdLogin:                "login",
+	CmdChat:                 "chat",
+	CmdPull:                 "pull",
+	CmdAck:                  "ack",
+	CmdDeliverAck:           "deliver_ack",
+	CmdSyncRequired:         "sync_required",
+	CmdConversationSettings: "conversation_settings",
+	CmdClearHistory:         "clear_history",
+	CmdDeleteConversation:   "delete_conversation",
+	CmdHideMessages:         "hide_messages",
+	CmdGoAway:               "go_away",
+	CmdBatchPull:            "batch_pull",
+	CmdBatchAck:             "batch_ack",
+}
+
+// String 返回指令名，未定义的指令统一为 unknown，可直接用作指标标签。
+func (c CmdType) String() string {
+	if c >= 0 && int(c) < len(cmdNames) {
+		return cmdNames[c]
+	}
+	return "unknown"
+}
+
+// ParseCmdType 按指令名查找指令，用于配置中按名称引用指令。
+func ParseCmdType(name string) (CmdType, bool) {
+	for c, n := range cmdNames {
+		if n == name {
+			return CmdType(c), true
+		}
+	}
+	return 0, false
+}
+
+// 协议版本。未握手的旧客户端视为 ProtocolV1；v2 起下行包带 kind、request_id 与错误原因（reason、retryable），
+// 写给 v1 连接时按 OutputPacket.ForVersion 去掉这些字段。
+const (
+	ProtocolV1      = 1
+	ProtocolVersion = 2 // 服务端实现的最新协议版本
+)
+
+// 客户端在握手时声明的能力。
 const (
-    CmdHeartbeat CmdType = iota // 心跳
-    CmdLogin      // 登录
-    CmdChat      // 发送消息
-    CmdPull      // 核心：主动拉取消息
-    CmdAck       // 消息确认
+	CapCompression = "compression"  // 下行启用 permessage-deflate 压缩
+	CapBinaryCodec = "binary_codec" // 二进制编解码
+	CapBatchPull   = "batch_pull"   // 一次拉取多个会话
+	CapReceipts    = "receipts"     // 客户端发送送达回执，服务端据此重投
+)
+
+// HandshakePayload 是握手结果，连接建立后以 CmdLogin 下发给声明了协议版本的客户端。
+type HandshakePayload struct {
+	ProtocolVersion    int      `json:"protocol_version"`     // 协商后的协议版本
+	MinProtocolVersion int      `json:"min_protocol_version"` // 服务端接受的最低版本
+	Capabilities       []string `json:"capabilities"`         // 双方都支持的能力
+}
+
+// PacketKind 区分服务端下行包是对请求的回包还是主动推送。
+type PacketKind string
+
+const (
+	KindResponse PacketKind = "response"
+	KindPush     PacketKind = "push"
 )
 
 type InputPacket struct {
-    Cmd            CmdType         `json:"cmd"`
-    MsgId          string          `json:"msg_id,omitempty"`          // 客户端生成的消息唯一ID (幂等)
-    ConversationId string          `json:"conversation_id,omitempty"` // 会话ID
-    CursorSeq      int64           `json:"cursor_seq,omitempty"`      // ⭐ 游标：从该seq之后开始拉取
-    Payload        json.RawMessage `json:"payload,omitempty"`         // 具体数据
+	Cmd            CmdType         `json:"cmd"`
+	MsgId          string          `json:"msg_id,omitempty"`          // 客户端生成的消息唯一ID (幂等)
+	RequestId      string          `json:"request_id,omitempty"`      // 客户端生成的请求ID，原样回显在对应回包中
+	ConversationId string          `json:"conversation_id,omitempty"` // 会话ID
+	CursorSeq      int64           `json:"cursor_seq,omitempty"`      // ⭐ 游标：从该seq之后开始拉取
+	Seq            int64           `json:"seq,omitempty"`             // ACK / 送达回执确认到的 seq
+	Payload        json.RawMessage `json:"payload,omitempty"`         // 具体数据
 }
 
 // 服务端发给客户端的包
 type OutputPacket struct {
-    Cmd           CmdType     `json:"cmd"`
-    Code          int         `json:"code"`                        // 0:成功, 非0:失败
-    MsgId         string      `json:"msg_id,omitempty"`            // 对应请求的消息ID
-    Seq           int64       `json:"seq,omitempty"`               // 服务端分配的序列号
-    NextCursorSeq int64       `json:"next_cursor_seq,omitempty"`   // ⭐ 下次拉取的游标
-    HasMore       bool        `json:"has_more,omitempty"`          // ⭐ 是否还有更多消息
-    Payload       interface{} `json:"payload,omitempty"`
-}
\ No newline at end of file
+	Cmd            CmdType     `json:"cmd"`
+	Kind           PacketKind  `json:"kind,omitempty"`            // response 为请求的回包，push 为服务端主动推送
+	RequestId      string      `json:"request_id,omitempty"`      // 回包回显请求的 request_id
+	Code           ErrorCode   `json:"code"`                      // 0:成功, 非0:失败，见 ErrorCode
+	Reason         string      `json:"reason,omitempty"`          // 失败时机器可读的错误原因
+	Retryable      bool        `json:"retryable,omitempty"`       // 失败时是否可以原样重试
+	MsgId          string      `json:"msg_id,omitempty"`          // 对应请求的消息ID
+	ConversationId string      `json:"conversation_id,omitempty"` // 推送/拉取对应的会话ID
+	Seq            int64       `json:"seq,omitempty"`             // 服务端分配的序列号
+	NextCursorSeq  int64       `json:"next_cursor_seq,omitempty"` // ⭐ 下次拉取的游标
+	HasMore        bool        `json:"has_more,omitempty"`        // ⭐ 是否还有更多消息
+	ArchivedSeq    int64       `json:"archived_seq,omitempty"`    // 拉取游标早于该 seq 时返回：<= 该 seq 的历史已按保留策略归档，不再可拉取
+	Payload        interface{} `json:"payload,omitempty"`
+	TraceId        string      `json:"trace_id,omitempty"` // 开启 echo_trace_id 时回包携带的链路 ID
+}
+
+// ForVersion 返回按协议版本 version 下发的包：v1 连接不认识 v2 新增的 kind、request_id 与错误原因，一律去掉。
+func (p OutputPacket) ForVersion(version int) OutputPacket {
+	if version < ProtocolVersion {
+		p.Kind, p.RequestId, p.Reason, p.Retryable = "", "", "", false
+	}
+	return p
+}
+
+// PullCursor 是批量拉取中单个会话的游标。
+type PullCursor struct {
+	ConversationId string `json:"conversation_id"`
+	CursorSeq      int64  `json:"cursor_seq"`
+}
+
+// BatchPullPayload 为 CmdBatchPull 的负载。Limit 为单个会话的条数上限，TotalLimit 为整批的条数上限，
+// 缺省或超过服务端配置时按服务端配置处理。
+type BatchPullPayload struct {
+	Conversations []PullCursor `json:"conversations"`
+	Limit         int          `json:"limit,omitempty"`
+	TotalLimit    int          `json:"total_limit,omitempty"`
+}
+
+// PullPage 是批量拉取中单个会话的一页，游标语义与 CmdPull 一致。
+type PullPage struct {
+	ConversationId string        `json:"conversation_id"`
+	Messages       []ChatMessage `json:"messages"`
+	NextCursorSeq  int64         `json:"next_cursor_seq"`
+	HasMore        bool          `json:"has_more"`
+	ArchivedSeq    int64         `json:"archived_seq,omitempty"` // 同 OutputPacket.ArchivedSeq
+	Code           ErrorCode     `json:"code,omitempty"`         // 非 0 时该会话未拉取，如 CodeNotMember，游标不变
+	Reason         string        `json:"reason,omitempty"`       // Code 对应的机器可读原因
+}
+
+// MessageState 是下发给客户端的消息状态。
+type MessageState string
+
+const (
+	StateNormal   MessageState = "normal"
+	StateEdited   MessageState = "edited"
+	StateRecalled MessageState = "recalled"
+)
+
+// ChatMessage 是消息的下行格式，用于发送回包、推送与拉取，与存储模型 TimelineMessage 解耦。
+// 客户端按 (conversation_id, seq) 排序，ServerTime 为服务端收到消息的时间（毫秒时间戳），用于展示。
+type ChatMessage struct {
+	MsgId          string       `json:"msg_id"`
+	ConversationId string       `json:"conversation_id"`
+	Seq            int64        `json:"seq"`
+	SenderId       string       `json:"sender_id"`
+	MsgType        int8         `json:"msg_type"`
+	Content        string       `json:"content"` // 已撤回的消息为空
+	ServerTime     int64        `json:"server_time"`
+	State          MessageState `json:"state"`
+}
+
+// NewChatMessage 将存储模型转换为下行格式。
+func NewChatMessage(m *TimelineMessage) ChatMessage {
+	out := ChatMessage{
+		MsgId:          m.MsgID,
+		ConversationId: m.ConversationID,
+		Seq:            int64(m.Seq),
+		SenderId:       m.SenderID,
+		MsgType:        m.MsgType,
+		Content:        m.Content,
+		ServerTime:     m.SendTime,
+		State:          StateNormal,
+	}
+	switch m.Status {
+	case MsgStatusEdited:
+		out.State = StateEdited
+	case MsgStatusRecalled:
+		out.State = StateRecalled
+		out.Content = ""
+	}
+	return out
+}
+
+// NewChatMessages 批量转换，msgs 为空时返回空切片而非 nil，序列化为 []。
+func NewChatMessages(msgs []TimelineMessage) []ChatMessage {
+	out := make([]ChatMessage, len(msgs))
+	for i := range msgs {
+		out[i] = NewChatMessage(&msgs[i])
+	}
+	return out
+}
+
+// AckEntry 是批量 ACK 中单个会话的已读位点。
+type AckEntry struct {
+	ConversationId string `json:"conversation_id"`
+	Seq            int64  `json:"seq"`
+}
+
+// BatchAckPayload 为 CmdBatchAck 的负载。
+type BatchAckPayload struct {
+	Acks []AckEntry `json:"acks"`
+}
+
+// GoAwayPayload 为 CmdGoAway 的负载。ReconnectDelayMs 为服务端随机分配的重连延迟，避免客户端同时重连。
+type GoAwayPayload struct {
+	Reason           string `json:"reason"`
+	ReconnectDelayMs int64  `json:"reconnect_delay_ms"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// User 对应 user 表。
+type User struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement"`
+	UserID    string    `gorm:"column:user_id;size:64;not null;unique"`
+	Nickname  string    `gorm:"column:nickname;size:64"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (User) TableName() string {
+	return "user"
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go-im/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ArchiveRepository 提供按保留策略归档消息所需的数据访问。
+type ArchiveRepository struct {
+	db *gorm.DB
+}
+
+func NewArchiveRepository(db *gorm.DB) *ArchiveRepository {
+	return &ArchiveRepository{db: db}
+}
+
+// ListConversationIDs 按 conversation_id 升序返回 after 之后有消息的会话，最多 limit 个。
+// include 非空时只返回这些会话类型的会话，exclude 中的会话类型总是被排除，会话类型见 model.ConversationType。
+func (r *ArchiveRepository) ListConversationIDs(ctx context.Context, include, exclude []string, after string, limit int) ([]string, error) {
+	q := r.db.WithContext(ctx).Model(&model.TimelineMessage{}).Where("conversation_id > ?", after)
+	if len(include) > 0 {
+		conds := make([]string, len(include))
+		args := make([]interface{}, len(include))
+		for i, t := range include {
+			conds[i] = "conversation_id LIKE ? ESCAPE '!'"
+			args[i] = typePattern(t)
+		}
+		q = q.Where(strings.Join(conds, " OR "), args...)
+	}
+	for _, t := range exclude {
+		q = q.Where("conversation_id NOT LIKE ? ESCAPE '!'", typePattern(t))
+	}
+	var ids []string
+	if err := q.Distinct("conversation_id").Order("conversation_id ASC").Limit(limit).Pluck("conversation_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// typePattern 返回匹配某一会话类型的 LIKE 模式，以 ! 转义类型名中的通配符。
+func typePattern(conversationType string) string {
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(conversationType)
+	return escaped + "!_%"
+}
+
+// ExpiredSeq 返回会话中 send_time 早于 before（毫秒时间戳）的最大 seq，不超过会话最新 seq - 1，
+// 最新一条消息始终保留，seq 分配不会回退。没有可归档的消息时返回 0。
+func (r *ArchiveRepository) ExpiredSeq(ctx context.Context, conversationID string, before int64) (int64, error) {
+	if conversationID == "" {
+		return 0, errors.New("conversationID cannot be empty")
+	}
+	var expired, last int64
+	db := r.db.WithContext(ctx)
+	if err := db.Raw("SELECT COALESCE(MAX(seq), 0) FROM timeline_message WHERE conversation_id = ? AND send_time < ?", conversationID, before).Scan(&expired).Error; err != nil {
+		return 0, err
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	if err := db.Raw("SELECT COALESCE(MAX(seq), 0) FROM timeline_message WHERE conversation_id = ?", conversationID).Scan(&last).Error; err != nil {
+		return 0, err
+	}
+	return max(min(expired, last-1), 0), nil
+}
+
+// ListArchivable 按 seq 升序返回会话中 seq <= upToSeq 的消息，最多 limit 条。
+func (r *ArchiveRepository) ListArchivable(ctx context.Context, conversationID string, upToSeq int64, limit int) ([]model.TimelineMessage, error) {
+	var messages []model.TimelineMessage
+	err := r.db.WithContext(ctx).
+		Where("conversation_id = ? AND seq <= ?", conversationID, upToSeq).
+		Order("seq ASC").Limit(limit).Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// DeleteArchived 在一个事务中删除会话内 seq <= upToSeq 的消息并推进归档位点，返回删除的条数。
+// 调用方需先确认这些消息已写入归档。
+func (r *ArchiveRepository) DeleteArchived(ctx context.Context, conversationID string, upToSeq int64) (deleted int64, err error) {
+	if conversationID == "" {
+		return 0, errors.New("conversationID cannot be empty")
+	}
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("conversation_id = ? AND seq <= ?", conversationID, upToSeq).Delete(&model.TimelineMessage{})
+		if res.Error != nil {
+			return res.Error
+		}
+		deleted = res.RowsAffected
+		now := time.Now()
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "conversation_id"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "archived_seq"}, Value: gorm.Expr("CASE WHEN archived_seq < ? THEN ? ELSE archived_seq END", upToSeq, upToSeq)},
+				{Column: clause.Column{Name: "updated_at"}, Value: now},
+			},
+		}).Create(&model.ConversationArchive{ConversationID: conversationID, ArchivedSeq: upToSeq, UpdatedAt: now}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"go-im/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ConversationRepository 负责用户维度的会话设置与会话列表查询。
+type ConversationRepository struct {
+	db *gorm.DB
+}
+
+func NewConversationRepository(db *gorm.DB) *ConversationRepository {
+	return &ConversationRepository{db: db}
+}
+
+// GetSettings 返回用户在会话的个人设置，无记录时返回默认值。
+func (r *ConversationRepository) GetSettings(ctx context.Context, userID, conversationID string) (model.ConversationSettings, error) {
+	var state model.UserConversationState
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+		Take(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.ConversationSettings{ConversationID: conversationID}, nil
+	}
+	if err != nil {
+		return model.ConversationSettings{}, err
+	}
+	return state.Settings(), nil
+}
+
+// ListSettings 一次查询多个用户在会话的个人设置，无记录的用户不在结果中。
+func (r *ConversationRepository) ListSettings(ctx context.Context, conversationID string, userIDs []string) (map[string]model.ConversationSettings, error) {
+	settings := make(map[string]model.ConversationSettings, len(userIDs))
+	if len(userIDs) == 0 {
+		return settings, nil
+	}
+	var states []model.UserConversationState
+	err := r.db.WithContext(ctx).
+		Where("conversation_id = ? AND user_id IN ?", conversationID, userIDs).
+		Find(&states).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range states {
+		settings[states[i].UserID] = states[i].Settings()
+	}
+	return settings, nil
+}
+
+// UpdateSettings 按列更新个人设置，记录不存在时先插入默认行。
+func (r *ConversationRepository) UpdateSettings(ctx context.Context, userID, conversationID string, updates map[string]interface{}) error {
+	if userID == "" || conversationID == "" {
+		return errors.New("userId and conversationId required")
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		row := model.UserConversationState{UserID: userID, ConversationID: conversationID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.UserConversationState{}).
+			Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+			Updates(updates).Error
+	})
+}
+
+// ListConversations 返回用户所在的全部会话及其最新消息位置，排序由调用方决定。
+func (r *ConversationRepository) ListConversations(ctx context.Context, userID string) ([]model.ConversationSummary, error) {
+	type row struct {
+		model.UserConversationState
+		LastSeq      int64
+		LastSendTime int64
+	}
+	var rows []row
+	err := r.db.WithContext(ctx).Raw(`
+	SELECT gm.group_id AS conversation_id,
+		COALESCE(s.last_ack_seq, 0) AS last_ack_seq,
+		COALESCE(s.muted, 0) AS muted,
+		COALESCE(s.mute_until, 0) AS mute_until,
+		COALESCE(s.pin_rank, 0) AS pin_rank,
+		COALESCE(s.archived, 0) AS archived,
+		COALESCE(s.notify_level, 0) AS notify_level,
+		COALESCE(s.cleared_seq, 0) AS cleared_seq,
+		COALESCE(s.deleted_seq, 0) AS deleted_seq,
+		COALESCE((SELECT MAX(t.seq) FROM timeline_message t WHERE t.conversation_id = gm.group_id), 0) AS last_seq,
+		COALESCE((SELECT t.send_time FROM timeline_message t WHERE t.conversation_id = gm.group_id ORDER BY t.seq DESC LIMIT 1), 0) AS last_send_time
+	FROM group_member gm
+	LEFT JOIN user_conversation_state s ON s.user_id = gm.user_id AND s.conversation_id = gm.group_id
+	WHERE gm.user_id = ?
+	`, userID).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	list := make([]model.ConversationSummary, 0, len(rows))
+	for _, rw := range rows {
+		read := rw.LastAckSeq
+		if rw.ClearedSeq > read {
+			read = rw.ClearedSeq
+		}
+		unread := rw.LastSeq - read
+		if unread < 0 {
+			unread = 0
+		}
+		list = append(list, model.ConversationSummary{
+			ConversationSettings: rw.Settings(),
+			LastSeq:              rw.LastSeq,
+			LastSendTime:         rw.LastSendTime,
+			LastAckSeq:           rw.LastAckSeq,
+			Unread:               unread,
+		})
+	}
+	return list, nil
+}
+
+// LastSeq 返回会话当前最大的 seq，无消息时为 0。
+func (r *ConversationRepository) LastSeq(ctx context.Context, conversationID string) (int64, error) {
+	var seq int64
+	err := r.db.WithContext(ctx).Raw(
+		"SELECT COALESCE(MAX(seq), 0) FROM timeline_message WHERE conversation_id = ?",
+		conversationID,
+	).Scan(&seq).Error
+	return seq, err
+}
+
+// SeqHeads 按 conversation_id 升序返回各会话当前最大的 seq。
+// conversationIDs 为空时返回全部会话，limit <= 0 表示不限条数。
+func (r *ConversationRepository) SeqHeads(ctx context.Context, conversationIDs []string, limit int) ([]model.SeqHead, error) {
+	q := r.db.WithContext(ctx).Model(&model.TimelineMessage{}).
+		Select("conversation_id, MAX(seq) AS seq").
+		Group("conversation_id").
+		Order("conversation_id ASC")
+	if len(conversationIDs) > 0 {
+		q = q.Where("conversation_id IN ?", conversationIDs)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	heads := []model.SeqHead{}
+	err := q.Scan(&heads).Error
+	return heads, err
+}
+
+// AdvanceCleared 推进清空历史水位，只前进不回退。
+func (r *ConversationRepository) AdvanceCleared(ctx context.Context, userID, conversationID string, seq int64) error {
+	if userID == "" || conversationID == "" {
+		return errors.New("userId and conversationId required")
+	}
+	return upsertMaxSeq(ctx, r.db, userID, conversationID, "cleared_seq", seq)
+}
+
+// AdvanceDeleted 推进列表删除水位，只前进不回退。
+func (r *ConversationRepository) AdvanceDeleted(ctx context.Context, userID, conversationID string, seq int64) error {
+	if userID == "" || conversationID == "" {
+		return errors.New("userId and conversationId required")
+	}
+	return upsertMaxSeq(ctx, r.db, userID, conversationID, "deleted_seq", seq)
+}
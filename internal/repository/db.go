@@ -1,25 +1,65 @@
 package repository
 
 import (
-	"log"
-	"os"
+	"errors"
+	"fmt"
+	"log/slog"
 	"time"
 
-	"gorm.io/driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	mysqldriver "gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+
+	"go-im/internal/config"
+	"go-im/internal/logging"
+)
+
+// 支持的数据库驱动。
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
 )
 
-// NewDB 使用环境变量 IM_MYSQL_DSN 初始化 GORM 数据库连接。
-// 默认值指向 docker-compose 中的本地 MySQL。
-func NewDB() (*gorm.DB, error) {
-	dsn := os.Getenv("IM_MYSQL_DSN")
-	if dsn == "" {
-		dsn = "im_user:im_pass123@tcp(localhost:8848)/go_im?parseTime=true&charset=utf8mb4&loc=Local"
+// PoolConfig 为连接池参数，SQLite 固定使用单连接，忽略该配置。
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// OpenConfig 按存储配置打开数据库。
+func OpenConfig(cfg config.StorageConfig, log *slog.Logger) (*gorm.DB, error) {
+	return Open(cfg.Driver, cfg.DSN(), PoolConfig{
+		MaxOpenConns:    cfg.MaxOpenConns,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+	}, log)
+}
+
+// Open 按驱动名打开数据库并配置连接池，gorm 的慢查询与错误日志也写入 log（为 nil 时使用 slog.Default()）。
+// SQLite 只保留单个连接：写操作天然串行，内存库也不会因连接回收而丢失。
+func Open(driver, dsn string, pool PoolConfig, log *slog.Logger) (*gorm.DB, error) {
+	if log == nil {
+		log = slog.Default()
+	}
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverMySQL:
+		dialector = mysqldriver.Open(dsn)
+	case DriverSQLite:
+		dialector = sqlite.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported db driver %q", driver)
 	}
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Warn),
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: logger.New(gormWriter{log}, logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      logger.Warn,
+		}),
+		TranslateError: true,
 	})
 	if err != nil {
 		return nil, err
@@ -29,11 +69,51 @@ func NewDB() (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	// 简单的连接池配置，可按需调整
-	sqlDB.SetMaxOpenConns(20)
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+	if driver == DriverSQLite {
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		log.Info("已打开数据库", "driver", driver, "dsn", logging.RedactDSN(dsn))
+		return db, nil
+	}
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
 
-	log.Printf("已连接 MySQL: %s", dsn)
+	log.Info("已打开数据库", "driver", driver, "dsn", logging.RedactDSN(dsn))
 	return db, nil
 }
+
+// gormWriter 把 gorm 的日志转给 slog。gorm 只在告警级别以上输出，统一记为 Warn。
+type gormWriter struct {
+	log *slog.Logger
+}
+
+func (w gormWriter) Printf(format string, args ...interface{}) {
+	w.log.Warn(fmt.Sprintf(format, args...), "component", "gorm")
+}
+
+// isDuplicateKey 判断是否为唯一索引冲突，兼容 MySQL 与 SQLite。
+func isDuplicateKey(err error) bool {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// insertedValue 返回多行 upsert 中引用某列待插入值的表达式：MySQL 为 VALUES(col)，SQLite 为 excluded.col。
+func insertedValue(db *gorm.DB, column string) string {
+	if db.Dialector.Name() == DriverMySQL {
+		return "VALUES(" + column + ")"
+	}
+	return "excluded." + column
+}
+
+// lockingRead 在支持的方言下为读加行锁；SQLite 以库级写锁串行事务，不需要 FOR UPDATE。
+func lockingRead(tx *gorm.DB, query string) string {
+	if tx.Dialector.Name() == DriverMySQL {
+		return query + " FOR UPDATE"
+	}
+	return query
+}
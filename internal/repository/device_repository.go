@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"go-im/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeviceRepository 负责设备推送令牌的注册与查询。
+type DeviceRepository struct {
+	db *gorm.DB
+}
+
+func NewDeviceRepository(db *gorm.DB) *DeviceRepository {
+	return &DeviceRepository{db: db}
+}
+
+// RegisterDevice 按 token 插入或覆盖设备记录。
+func (r *DeviceRepository) RegisterDevice(ctx context.Context, userID, platform, token string) error {
+	if userID == "" || platform == "" || token == "" {
+		return errors.New("userId, platform and token required")
+	}
+	device := model.DeviceToken{UserID: userID, Platform: platform, Token: token}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "token"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "platform", "updated_at"}),
+	}).Create(&device).Error
+}
+
+// UnregisterDevice 删除用户名下的指定令牌。
+func (r *DeviceRepository) UnregisterDevice(ctx context.Context, userID, token string) error {
+	return r.db.WithContext(ctx).
+		Where("user_id = ? AND token = ?", userID, token).
+		Delete(&model.DeviceToken{}).Error
+}
+
+// ListDevices 返回用户名下的全部设备。
+func (r *DeviceRepository) ListDevices(ctx context.Context, userID string) ([]model.DeviceToken, error) {
+	var devices []model.DeviceToken
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("id ASC").Find(&devices).Error
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"go-im/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HiddenMessageRepository 负责用户隐藏消息集合的读写。
+type HiddenMessageRepository struct {
+	db *gorm.DB
+}
+
+func NewHiddenMessageRepository(db *gorm.DB) *HiddenMessageRepository {
+	return &HiddenMessageRepository{db: db}
+}
+
+// HideMessages 把消息加入用户的隐藏集合，已隐藏的消息忽略。
+func (r *HiddenMessageRepository) HideMessages(ctx context.Context, userID string, msgs []model.TimelineMessage) error {
+	if userID == "" {
+		return errors.New("userId required")
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	rows := make([]model.HiddenMessage, 0, len(msgs))
+	for _, m := range msgs {
+		rows = append(rows, model.HiddenMessage{
+			UserID:         userID,
+			ConversationID: m.ConversationID,
+			Seq:            m.Seq,
+			MsgID:          m.MsgID,
+		})
+	}
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&rows).Error
+}
+
+// ListHiddenSeqs 返回用户在会话 (fromSeq, toSeq] 区间内隐藏的 seq。
+func (r *HiddenMessageRepository) ListHiddenSeqs(ctx context.Context, userID, conversationID string, fromSeq, toSeq int64) ([]int64, error) {
+	var seqs []int64
+	err := r.db.WithContext(ctx).
+		Model(&model.HiddenMessage{}).
+		Where("user_id = ? AND conversation_id = ? AND seq > ? AND seq <= ?", userID, conversationID, fromSeq, toSeq).
+		Pluck("seq", &seqs).Error
+	if err != nil {
+		return nil, err
+	}
+	return seqs, nil
+}
+
+// ListHiddenInRanges 批量返回用户在各会话区间内隐藏的 seq，结果按 conversation_id 分组。
+func (r *HiddenMessageRepository) ListHiddenInRanges(ctx context.Context, userID string, ranges []model.SeqRange) (map[string][]int64, error) {
+	result := make(map[string][]int64)
+	if len(ranges) == 0 {
+		return result, nil
+	}
+	// 按会话 OR 拼接区间条件，每个分支走 (user_id, conversation_id, seq) 主键
+	cond := r.db.Where("conversation_id = ? AND seq > ? AND seq <= ?", ranges[0].ConversationID, ranges[0].FromSeq, ranges[0].ToSeq)
+	for _, rg := range ranges[1:] {
+		cond = cond.Or("conversation_id = ? AND seq > ? AND seq <= ?", rg.ConversationID, rg.FromSeq, rg.ToSeq)
+	}
+	var rows []model.HiddenMessage
+	err := r.db.WithContext(ctx).
+		Select("conversation_id", "seq").
+		Where("user_id = ?", userID).Where(cond).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.ConversationID] = append(result[row.ConversationID], int64(row.Seq))
+	}
+	return result, nil
+}
+
+// ListHidden 返回用户在会话内的全部隐藏记录，按 seq 升序，供新设备同步。
+func (r *HiddenMessageRepository) ListHidden(ctx context.Context, userID, conversationID string) ([]model.HiddenMessage, error) {
+	var rows []model.HiddenMessage
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+		Order("seq ASC").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
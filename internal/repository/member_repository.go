@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"go-im/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// MemberRepository 读取会话成员，会话 ID 即 group_member.group_id。
+type MemberRepository struct {
+	db *gorm.DB
+}
+
+func NewMemberRepository(db *gorm.DB) *MemberRepository {
+	return &MemberRepository{db: db}
+}
+
+// ListMembers 返回会话内所有成员的用户 ID。
+func (r *MemberRepository) ListMembers(ctx context.Context, conversationID string) ([]string, error) {
+	if conversationID == "" {
+		return nil, errors.New("conversationID cannot be empty")
+	}
+	var userIDs []string
+	err := r.db.WithContext(ctx).
+		Model(&model.GroupMember{}).
+		Where("group_id = ?", conversationID).
+		Order("user_id ASC").
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// MemberConversations 返回 conversationIDs 中 userID 为成员的会话，按主键一次查询。
+func (r *MemberRepository) MemberConversations(ctx context.Context, userID string, conversationIDs []string) ([]string, error) {
+	if userID == "" {
+		return nil, errors.New("userID cannot be empty")
+	}
+	if len(conversationIDs) == 0 {
+		return nil, nil
+	}
+	var groupIDs []string
+	err := r.db.WithContext(ctx).
+		Model(&model.GroupMember{}).
+		Where("group_id IN ? AND user_id = ?", conversationIDs, userID).
+		Pluck("group_id", &groupIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return groupIDs, nil
+}
@@ -0,0 +1,618 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go-im/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type stateKey struct {
+	userID         string
+	conversationID string
+}
+
+type dedupKey struct {
+	senderID string
+	msgID    string
+}
+
+// MemoryStore 是纯内存的存储实现，覆盖各 Repository 的同名方法，
+// 用于无外部依赖地运行服务与测试。进程退出即丢失数据。
+type MemoryStore struct {
+	mu       sync.RWMutex
+	nextID   uint64
+	messages map[string][]model.TimelineMessage // conversation_id -> 按 seq 升序
+	byMsgID  map[string][]model.TimelineMessage // 不同发送方可能使用相同的 msg_id
+	dedup    map[dedupKey]model.MessageDedup
+	states   map[stateKey]*model.UserConversationState
+	members  map[string]map[string]int64 // group_id -> user_id -> join_time
+	devices  map[string]model.DeviceToken
+	hidden   map[string]map[seqKey]model.HiddenMessage // user_id -> (conversation_id, seq) -> 记录
+	archived map[string]int64                          // conversation_id -> 已归档到的 seq
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		messages: make(map[string][]model.TimelineMessage),
+		byMsgID:  make(map[string][]model.TimelineMessage),
+		dedup:    make(map[dedupKey]model.MessageDedup),
+		states:   make(map[stateKey]*model.UserConversationState),
+		members:  make(map[string]map[string]int64),
+		devices:  make(map[string]model.DeviceToken),
+		hidden:   make(map[string]map[seqKey]model.HiddenMessage),
+		archived: make(map[string]int64),
+	}
+}
+
+// SaveMessage 生成会话内 seq 并写入消息，发送方用过该 msg_id 时返回 ErrDuplicateMsgID。
+func (s *MemoryStore) SaveMessage(ctx context.Context, msg *model.TimelineMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dedup[dedupKey{msg.SenderID, msg.MsgID}]; ok {
+		return ErrDuplicateMsgID
+	}
+	s.insert(msg)
+	return nil
+}
+
+// SaveMessages 在一次加锁内写入一批消息，语义同 MessageRepository.SaveMessages。
+func (s *MemoryStore) SaveMessages(ctx context.Context, msgs []*model.TimelineMessage) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]error, len(msgs))
+	for i, msg := range msgs {
+		if _, ok := s.dedup[dedupKey{msg.SenderID, msg.MsgID}]; ok {
+			results[i] = ErrDuplicateMsgID
+			continue
+		}
+		s.insert(msg)
+	}
+	return results, nil
+}
+
+// insert 分配 seq 与主键并写入消息与去重记录，调用方需持有写锁。
+func (s *MemoryStore) insert(msg *model.TimelineMessage) {
+	list := s.messages[msg.ConversationID]
+	msg.Seq = 1
+	if len(list) > 0 {
+		msg.Seq = list[len(list)-1].Seq + 1
+	}
+	s.nextID++
+	msg.ID = s.nextID
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	s.messages[msg.ConversationID] = append(list, *msg)
+	s.byMsgID[msg.MsgID] = append(s.byMsgID[msg.MsgID], *msg)
+	dedup := model.NewMessageDedup(msg)
+	dedup.CreatedAt = msg.CreatedAt
+	s.dedup[dedupKey{msg.SenderID, msg.MsgID}] = dedup
+}
+
+// FindDedup 查询发送方对 msg_id 的去重记录，未找到时返回 gorm.ErrRecordNotFound。
+func (s *MemoryStore) FindDedup(ctx context.Context, senderID, msgID string) (*model.MessageDedup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dedup, ok := s.dedup[dedupKey{senderID, msgID}]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &dedup, nil
+}
+
+// PurgeDedup 删除 before 之前的去重记录，返回删除的条数。
+func (s *MemoryStore) PurgeDedup(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for key, dedup := range s.dedup {
+		if dedup.CreatedAt.Before(before) {
+			delete(s.dedup, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// seqKey 以 (conversation_id, seq) 标识一条消息。
+type seqKey struct {
+	conversationID string
+	seq            uint64
+}
+
+// FindMessages 批量查询 refs 指定的消息，不存在的消息被忽略，同一条消息只返回一次。
+func (s *MemoryStore) FindMessages(ctx context.Context, refs []model.MessageRef) ([]model.TimelineMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := make(map[seqKey]bool, len(refs))
+	msgs := make([]model.TimelineMessage, 0, len(refs))
+	add := func(m model.TimelineMessage) {
+		if key := (seqKey{m.ConversationID, m.Seq}); !seen[key] {
+			seen[key] = true
+			msgs = append(msgs, m)
+		}
+	}
+	for _, ref := range refs {
+		if ref.ConversationID != "" && ref.Seq > 0 {
+			list := s.messages[ref.ConversationID]
+			i := sort.Search(len(list), func(i int) bool { return int64(list[i].Seq) >= ref.Seq })
+			if i < len(list) && int64(list[i].Seq) == ref.Seq {
+				add(list[i])
+			}
+			continue
+		}
+		for _, m := range s.byMsgID[ref.MsgID] {
+			if m.SenderID == ref.SenderID {
+				add(m)
+			}
+		}
+	}
+	return msgs, nil
+}
+
+// ListMessages 按会话内 seq 拉取消息，返回升序列表。
+func (s *MemoryStore) ListMessages(ctx context.Context, conversationID string, afterSeq int64, limit int) ([]model.TimelineMessage, error) {
+	if conversationID == "" {
+		return nil, errors.New("conversationID cannot be empty")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := s.messages[conversationID]
+	start := sort.Search(len(list), func(i int) bool { return int64(list[i].Seq) > afterSeq })
+	end := len(list)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	return append([]model.TimelineMessage(nil), list[start:end]...), nil
+}
+
+// ListMessagesBatch 按各会话的游标拉取消息，每个会话最多 limit 条。
+func (s *MemoryStore) ListMessagesBatch(ctx context.Context, cursors []model.PullCursor, limit int) (map[string][]model.TimelineMessage, error) {
+	result := make(map[string][]model.TimelineMessage, len(cursors))
+	for _, c := range cursors {
+		msgs, err := s.ListMessages(ctx, c.ConversationId, c.CursorSeq, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) > 0 {
+			result[c.ConversationId] = msgs
+		}
+	}
+	return result, nil
+}
+
+// state 返回（必要时创建）用户会话状态，调用方需持有写锁。
+func (s *MemoryStore) state(userID, conversationID string) *model.UserConversationState {
+	key := stateKey{userID, conversationID}
+	st, ok := s.states[key]
+	if !ok {
+		st = &model.UserConversationState{UserID: userID, ConversationID: conversationID}
+		s.states[key] = st
+	}
+	return st
+}
+
+func (s *MemoryStore) advance(userID, conversationID string, field func(*model.UserConversationState) *int64, seq int64) error {
+	if userID == "" || conversationID == "" {
+		return errors.New("userId and conversationId required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.state(userID, conversationID)
+	if p := field(st); seq > *p {
+		*p = seq
+	}
+	st.UpdatedAt = time.Now()
+	return nil
+}
+
+// UpsertAck 更新 last_ack_seq，只前进不回退。
+func (s *MemoryStore) UpsertAck(ctx context.Context, userID, conversationID string, ackSeq int64) error {
+	return s.advance(userID, conversationID, func(st *model.UserConversationState) *int64 { return &st.LastAckSeq }, ackSeq)
+}
+
+// UpsertAcks 批量更新 last_ack_seq，只前进不回退。
+func (s *MemoryStore) UpsertAcks(ctx context.Context, states []model.UserConversationState) error {
+	for _, st := range states {
+		if err := s.UpsertAck(ctx, st.UserID, st.ConversationID, st.LastAckSeq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpsertDelivered 更新 last_delivered_seq，只前进不回退。
+func (s *MemoryStore) UpsertDelivered(ctx context.Context, userID, conversationID string, seq int64) error {
+	return s.advance(userID, conversationID, func(st *model.UserConversationState) *int64 { return &st.LastDeliveredSeq }, seq)
+}
+
+// AdvanceCleared 推进清空历史水位。
+func (s *MemoryStore) AdvanceCleared(ctx context.Context, userID, conversationID string, seq int64) error {
+	return s.advance(userID, conversationID, func(st *model.UserConversationState) *int64 { return &st.ClearedSeq }, seq)
+}
+
+// AdvanceDeleted 推进列表删除水位。
+func (s *MemoryStore) AdvanceDeleted(ctx context.Context, userID, conversationID string, seq int64) error {
+	return s.advance(userID, conversationID, func(st *model.UserConversationState) *int64 { return &st.DeletedSeq }, seq)
+}
+
+// GetClearedSeq 返回清空历史水位，无记录时为 0。
+func (s *MemoryStore) GetClearedSeq(ctx context.Context, userID, conversationID string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if st, ok := s.states[stateKey{userID, conversationID}]; ok {
+		return st.ClearedSeq, nil
+	}
+	return 0, nil
+}
+
+// GetClearedSeqs 批量返回清空历史水位，未清空的会话不出现在结果中。
+func (s *MemoryStore) GetClearedSeqs(ctx context.Context, userID string, conversationIDs []string) (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cleared := make(map[string]int64)
+	for _, id := range conversationIDs {
+		if st, ok := s.states[stateKey{userID, id}]; ok && st.ClearedSeq > 0 {
+			cleared[id] = st.ClearedSeq
+		}
+	}
+	return cleared, nil
+}
+
+// GetArchivedSeqs 返回各会话归档到的 seq，未归档过的会话不出现在结果中。
+func (s *MemoryStore) GetArchivedSeqs(ctx context.Context, conversationIDs []string) (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	archived := make(map[string]int64)
+	for _, id := range conversationIDs {
+		if seq := s.archived[id]; seq > 0 {
+			archived[id] = seq
+		}
+	}
+	return archived, nil
+}
+
+// ListConversationIDs 按升序返回 after 之后有消息的会话，语义同 ArchiveRepository.ListConversationIDs。
+func (s *MemoryStore) ListConversationIDs(ctx context.Context, include, exclude []string, after string, limit int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ids []string
+	for id, list := range s.messages {
+		t := model.ConversationType(id)
+		if id <= after || len(list) == 0 || (len(include) > 0 && !slices.Contains(include, t)) || slices.Contains(exclude, t) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// ExpiredSeq 返回会话中 send_time 早于 before 的最大 seq，不超过最新 seq - 1。
+func (s *MemoryStore) ExpiredSeq(ctx context.Context, conversationID string, before int64) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := s.messages[conversationID]
+	var expired int64
+	for _, m := range list {
+		if m.SendTime < before {
+			expired = max(expired, int64(m.Seq))
+		}
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	return max(min(expired, int64(list[len(list)-1].Seq)-1), 0), nil
+}
+
+// ListArchivable 按 seq 升序返回会话中 seq <= upToSeq 的消息，最多 limit 条。
+func (s *MemoryStore) ListArchivable(ctx context.Context, conversationID string, upToSeq int64, limit int) ([]model.TimelineMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := s.messages[conversationID]
+	end := sort.Search(len(list), func(i int) bool { return int64(list[i].Seq) > upToSeq })
+	return append([]model.TimelineMessage(nil), list[:min(end, limit)]...), nil
+}
+
+// DeleteArchived 删除会话内 seq <= upToSeq 的消息并推进归档位点。
+func (s *MemoryStore) DeleteArchived(ctx context.Context, conversationID string, upToSeq int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.messages[conversationID]
+	end := sort.Search(len(list), func(i int) bool { return int64(list[i].Seq) > upToSeq })
+	for _, m := range list[:end] {
+		s.byMsgID[m.MsgID] = slices.DeleteFunc(s.byMsgID[m.MsgID], func(o model.TimelineMessage) bool { return o.ID == m.ID })
+		if len(s.byMsgID[m.MsgID]) == 0 {
+			delete(s.byMsgID, m.MsgID)
+		}
+	}
+	s.messages[conversationID] = append([]model.TimelineMessage(nil), list[end:]...)
+	s.archived[conversationID] = max(s.archived[conversationID], upToSeq)
+	return int64(end), nil
+}
+
+// GetSettings 返回个人设置，无记录时返回默认值。
+func (s *MemoryStore) GetSettings(ctx context.Context, userID, conversationID string) (model.ConversationSettings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if st, ok := s.states[stateKey{userID, conversationID}]; ok {
+		return st.Settings(), nil
+	}
+	return model.ConversationSettings{ConversationID: conversationID}, nil
+}
+
+// ListSettings 返回多个用户在会话的个人设置，无记录的用户不在结果中。
+func (s *MemoryStore) ListSettings(ctx context.Context, conversationID string, userIDs []string) (map[string]model.ConversationSettings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	settings := make(map[string]model.ConversationSettings, len(userIDs))
+	for _, userID := range userIDs {
+		if st, ok := s.states[stateKey{userID, conversationID}]; ok {
+			settings[userID] = st.Settings()
+		}
+	}
+	return settings, nil
+}
+
+// UpdateSettings 按列更新个人设置，列名与 user_conversation_state 表一致。
+func (s *MemoryStore) UpdateSettings(ctx context.Context, userID, conversationID string, updates map[string]interface{}) error {
+	if userID == "" || conversationID == "" {
+		return errors.New("userId and conversationId required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.state(userID, conversationID)
+	for col, v := range updates {
+		var ok bool
+		switch col {
+		case "muted":
+			st.Muted, ok = v.(bool)
+		case "mute_until":
+			st.MuteUntil, ok = v.(int64)
+		case "pin_rank":
+			st.PinRank, ok = v.(int64)
+		case "archived":
+			st.Archived, ok = v.(bool)
+		case "notify_level":
+			st.NotifyLevel, ok = v.(int8)
+		}
+		if !ok {
+			return fmt.Errorf("unsupported settings column %q", col)
+		}
+	}
+	st.UpdatedAt = time.Now()
+	return nil
+}
+
+// LastSeq 返回会话当前最大的 seq。
+func (s *MemoryStore) LastSeq(ctx context.Context, conversationID string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastSeqLocked(conversationID), nil
+}
+
+func (s *MemoryStore) lastSeqLocked(conversationID string) int64 {
+	list := s.messages[conversationID]
+	if len(list) == 0 {
+		return 0
+	}
+	return int64(list[len(list)-1].Seq)
+}
+
+// SeqHeads 语义同 ConversationRepository.SeqHeads。
+func (s *MemoryStore) SeqHeads(ctx context.Context, conversationIDs []string, limit int) ([]model.SeqHead, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := conversationIDs
+	if len(ids) == 0 {
+		for id := range s.messages {
+			ids = append(ids, id)
+		}
+	}
+	heads := []model.SeqHead{}
+	for _, id := range ids {
+		if seq := s.lastSeqLocked(id); seq > 0 {
+			heads = append(heads, model.SeqHead{ConversationID: id, Seq: seq})
+		}
+	}
+	sort.Slice(heads, func(i, j int) bool { return heads[i].ConversationID < heads[j].ConversationID })
+	if limit > 0 && len(heads) > limit {
+		heads = heads[:limit]
+	}
+	return heads, nil
+}
+
+// ListConversations 返回用户所在的全部会话，字段含义同 ConversationRepository。
+func (s *MemoryStore) ListConversations(ctx context.Context, userID string) ([]model.ConversationSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var list []model.ConversationSummary
+	for groupID, users := range s.members {
+		if _, ok := users[userID]; !ok {
+			continue
+		}
+		st := model.UserConversationState{ConversationID: groupID}
+		if cur, ok := s.states[stateKey{userID, groupID}]; ok {
+			st = *cur
+		}
+		summary := model.ConversationSummary{
+			ConversationSettings: st.Settings(),
+			LastSeq:              s.lastSeqLocked(groupID),
+			LastAckSeq:           st.LastAckSeq,
+		}
+		if msgs := s.messages[groupID]; len(msgs) > 0 {
+			summary.LastSendTime = msgs[len(msgs)-1].SendTime
+		}
+		read := st.LastAckSeq
+		if st.ClearedSeq > read {
+			read = st.ClearedSeq
+		}
+		if summary.LastSeq > read {
+			summary.Unread = summary.LastSeq - read
+		}
+		list = append(list, summary)
+	}
+	return list, nil
+}
+
+// AddMember 把用户加入会话，供内存模式初始化数据。
+func (s *MemoryStore) AddMember(groupID, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users, ok := s.members[groupID]
+	if !ok {
+		users = make(map[string]int64)
+		s.members[groupID] = users
+	}
+	users[userID] = time.Now().UnixMilli()
+}
+
+// ListMembers 返回会话内所有成员的用户 ID，按 user_id 升序。
+func (s *MemoryStore) ListMembers(ctx context.Context, conversationID string) ([]string, error) {
+	if conversationID == "" {
+		return nil, errors.New("conversationID cannot be empty")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.members[conversationID]))
+	for id := range s.members[conversationID] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// MemberConversations 返回 conversationIDs 中 userID 为成员的会话。
+func (s *MemoryStore) MemberConversations(ctx context.Context, userID string, conversationIDs []string) ([]string, error) {
+	if userID == "" {
+		return nil, errors.New("userID cannot be empty")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ids []string
+	for _, id := range conversationIDs {
+		if _, ok := s.members[id][userID]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// RegisterDevice 按 token 插入或覆盖设备记录。
+func (s *MemoryStore) RegisterDevice(ctx context.Context, userID, platform, token string) error {
+	if userID == "" || platform == "" || token == "" {
+		return errors.New("userId, platform and token required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	d, ok := s.devices[token]
+	if !ok {
+		s.nextID++
+		d = model.DeviceToken{ID: s.nextID, Token: token, CreatedAt: now}
+	}
+	d.UserID, d.Platform, d.UpdatedAt = userID, platform, now
+	s.devices[token] = d
+	return nil
+}
+
+// UnregisterDevice 删除用户名下的指定令牌。
+func (s *MemoryStore) UnregisterDevice(ctx context.Context, userID, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[token]; ok && d.UserID == userID {
+		delete(s.devices, token)
+	}
+	return nil
+}
+
+// ListDevices 返回用户名下的全部设备。
+func (s *MemoryStore) ListDevices(ctx context.Context, userID string) ([]model.DeviceToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var devices []model.DeviceToken
+	for _, d := range s.devices {
+		if d.UserID == userID {
+			devices = append(devices, d)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices, nil
+}
+
+// HideMessages 把消息加入用户的隐藏集合。
+func (s *MemoryStore) HideMessages(ctx context.Context, userID string, msgs []model.TimelineMessage) error {
+	if userID == "" {
+		return errors.New("userId required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set, ok := s.hidden[userID]
+	if !ok {
+		set = make(map[seqKey]model.HiddenMessage)
+		s.hidden[userID] = set
+	}
+	for _, m := range msgs {
+		key := seqKey{m.ConversationID, m.Seq}
+		if _, exists := set[key]; exists {
+			continue
+		}
+		set[key] = model.HiddenMessage{UserID: userID, ConversationID: m.ConversationID, Seq: m.Seq, MsgID: m.MsgID, CreatedAt: time.Now()}
+	}
+	return nil
+}
+
+// ListHiddenSeqs 返回用户在会话 (fromSeq, toSeq] 区间内隐藏的 seq。
+func (s *MemoryStore) ListHiddenSeqs(ctx context.Context, userID, conversationID string, fromSeq, toSeq int64) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var seqs []int64
+	for key := range s.hidden[userID] {
+		if key.conversationID == conversationID && int64(key.seq) > fromSeq && int64(key.seq) <= toSeq {
+			seqs = append(seqs, int64(key.seq))
+		}
+	}
+	return seqs, nil
+}
+
+// ListHiddenInRanges 批量返回用户在各会话区间内隐藏的 seq。
+func (s *MemoryStore) ListHiddenInRanges(ctx context.Context, userID string, ranges []model.SeqRange) (map[string][]int64, error) {
+	result := make(map[string][]int64)
+	for _, rg := range ranges {
+		seqs, err := s.ListHiddenSeqs(ctx, userID, rg.ConversationID, rg.FromSeq, rg.ToSeq)
+		if err != nil {
+			return nil, err
+		}
+		if len(seqs) > 0 {
+			result[rg.ConversationID] = seqs
+		}
+	}
+	return result, nil
+}
+
+// ListHidden 返回用户在会话内的全部隐藏记录，按 seq 升序。
+func (s *MemoryStore) ListHidden(ctx context.Context, userID, conversationID string) ([]model.HiddenMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var rows []model.HiddenMessage
+	for _, h := range s.hidden[userID] {
+		if h.ConversationID == conversationID {
+			rows = append(rows, h)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Seq < rows[j].Seq })
+	return rows, nil
+}
@@ -0,0 +1,232 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go-im/internal/model"
+	"go-im/internal/repository"
+)
+
+func TestMemoryStoreSaveAndList(t *testing.T) {
+	store := repository.NewMemoryStore()
+	ctx := context.Background()
+
+	for i, id := range []string{"m1", "m2", "m3"} {
+		msg := &model.TimelineMessage{MsgID: id, ConversationID: "g1", SenderID: "u1", Content: id, SendTime: time.Now().UnixMilli()}
+		if err := store.SaveMessage(ctx, msg); err != nil {
+			t.Fatalf("SaveMessage %s failed: %v", id, err)
+		}
+		if msg.Seq != uint64(i+1) {
+			t.Fatalf("expected seq=%d, got %d", i+1, msg.Seq)
+		}
+	}
+	if err := store.SaveMessage(ctx, &model.TimelineMessage{MsgID: "m1", ConversationID: "g1", SenderID: "u1"}); !errors.Is(err, repository.ErrDuplicateMsgID) {
+		t.Fatalf("expected ErrDuplicateMsgID, got %v", err)
+	}
+	if found, err := store.FindMessages(ctx, []model.MessageRef{{ConversationID: "g1", Seq: 9}, {SenderID: "u1", MsgID: "missing"}}); err != nil || len(found) != 0 {
+		t.Fatalf("missing messages should be ignored, got %+v, %v", found, err)
+	}
+
+	msgs, err := store.ListMessages(ctx, "g1", 1, 1)
+	if err != nil {
+		t.Fatalf("ListMessages failed: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].MsgID != "m2" {
+		t.Fatalf("unexpected page: %+v", msgs)
+	}
+}
+
+func TestMemoryStoreAckOnlyMovesForward(t *testing.T) {
+	store := repository.NewMemoryStore()
+	ctx := context.Background()
+
+	store.AddMember("g1", "u1")
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err := store.SaveMessage(ctx, &model.TimelineMessage{MsgID: id, ConversationID: "g1", SenderID: "u2"}); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+	if err := store.UpsertAck(ctx, "u1", "g1", 2); err != nil {
+		t.Fatalf("UpsertAck failed: %v", err)
+	}
+	if err := store.UpsertAck(ctx, "u1", "g1", 1); err != nil {
+		t.Fatalf("UpsertAck failed: %v", err)
+	}
+
+	list, err := store.ListConversations(ctx, "u1")
+	if err != nil {
+		t.Fatalf("ListConversations failed: %v", err)
+	}
+	if len(list) != 1 || list[0].LastAckSeq != 2 || list[0].Unread != 1 {
+		t.Fatalf("unexpected summary: %+v", list)
+	}
+}
+
+// seqHeadStore 为 SeqHeads 的两种实现共用的测试视图。
+type seqHeadStore interface {
+	SaveMessage(ctx context.Context, msg *model.TimelineMessage) error
+	SeqHeads(ctx context.Context, conversationIDs []string, limit int) ([]model.SeqHead, error)
+}
+
+func TestSeqHeadsMatchAcrossBackends(t *testing.T) {
+	db := openTestDB(t)
+	sqlRepo := struct {
+		*repository.MessageRepository
+		*repository.ConversationRepository
+	}{repository.NewMessageRepository(db), repository.NewConversationRepository(db)}
+	backends := map[string]seqHeadStore{
+		"memory": repository.NewMemoryStore(),
+		"sql":    sqlRepo,
+	}
+	for name, store := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			convA, convB := uniqueID(t, "heads-a"), uniqueID(t, "heads-b")
+			for i, conv := range []string{convA, convA, convA, convB} {
+				msg := &model.TimelineMessage{MsgID: uniqueID(t, "m") + string(rune('a'+i)), ConversationID: conv, SenderID: "u1", SendTime: time.Now().UnixMilli()}
+				if err := store.SaveMessage(ctx, msg); err != nil {
+					t.Fatalf("SaveMessage failed: %v", err)
+				}
+			}
+
+			heads, err := store.SeqHeads(ctx, []string{convB, convA, "missing"}, 0)
+			if err != nil {
+				t.Fatalf("SeqHeads failed: %v", err)
+			}
+			want := []model.SeqHead{{ConversationID: convA, Seq: 3}, {ConversationID: convB, Seq: 1}}
+			if len(heads) != len(want) || heads[0] != want[0] || heads[1] != want[1] {
+				t.Fatalf("unexpected heads: %+v", heads)
+			}
+			if limited, err := store.SeqHeads(ctx, []string{convA, convB}, 1); err != nil || len(limited) != 1 {
+				t.Fatalf("limit not applied: %+v, %v", limited, err)
+			}
+		})
+	}
+}
+
+type batchPullStore interface {
+	SaveMessage(ctx context.Context, msg *model.TimelineMessage) error
+	ListMessagesBatch(ctx context.Context, cursors []model.PullCursor, limit int) (map[string][]model.TimelineMessage, error)
+	HideMessages(ctx context.Context, userID string, msgs []model.TimelineMessage) error
+	ListHiddenInRanges(ctx context.Context, userID string, ranges []model.SeqRange) (map[string][]int64, error)
+}
+
+func TestBatchPullReadsMatchAcrossBackends(t *testing.T) {
+	db := openTestDB(t)
+	sqlRepo := struct {
+		*repository.MessageRepository
+		*repository.PullRepository
+		*repository.HiddenMessageRepository
+	}{repository.NewMessageRepository(db), repository.NewPullRepository(db), repository.NewHiddenMessageRepository(db)}
+	backends := map[string]batchPullStore{
+		"memory": repository.NewMemoryStore(),
+		"sql":    sqlRepo,
+	}
+	for name, store := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			// 会话数超过单条语句的分批大小，覆盖多批查询
+			prefix := uniqueID(t, "batch")
+			var cursors []model.PullCursor
+			for i := 0; i < 150; i++ {
+				conv := fmt.Sprintf("%s-%d", prefix, i)
+				for j := 0; j < 3; j++ {
+					msg := &model.TimelineMessage{MsgID: fmt.Sprintf("%s-m%d", conv, j), ConversationID: conv, SenderID: "u1", SendTime: time.Now().UnixMilli()}
+					if err := store.SaveMessage(ctx, msg); err != nil {
+						t.Fatalf("SaveMessage failed: %v", err)
+					}
+				}
+				cursors = append(cursors, model.PullCursor{ConversationId: conv, CursorSeq: int64(i % 2)})
+			}
+
+			got, err := store.ListMessagesBatch(ctx, cursors, 2)
+			if err != nil {
+				t.Fatalf("ListMessagesBatch failed: %v", err)
+			}
+			if len(got) != 150 {
+				t.Fatalf("expected pages for 150 conversations, got %d", len(got))
+			}
+			for i, c := range cursors {
+				msgs := got[c.ConversationId]
+				if len(msgs) != 2 || int64(msgs[0].Seq) != c.CursorSeq+1 || msgs[1].Seq != msgs[0].Seq+1 {
+					t.Fatalf("conversation %d: unexpected page %+v", i, msgs)
+				}
+			}
+
+			first := cursors[0].ConversationId
+			if err := store.HideMessages(ctx, "u1", got[first][1:]); err != nil {
+				t.Fatalf("HideMessages failed: %v", err)
+			}
+			hidden, err := store.ListHiddenInRanges(ctx, "u1", []model.SeqRange{
+				{ConversationID: first, FromSeq: 0, ToSeq: 2},
+				{ConversationID: cursors[1].ConversationId, FromSeq: 0, ToSeq: 3},
+			})
+			if err != nil {
+				t.Fatalf("ListHiddenInRanges failed: %v", err)
+			}
+			if len(hidden) != 1 || len(hidden[first]) != 1 || hidden[first][0] != int64(got[first][1].Seq) {
+				t.Fatalf("unexpected hidden seqs: %v", hidden)
+			}
+		})
+	}
+}
+
+func TestMemberConversationsMatchAcrossBackends(t *testing.T) {
+	db := openTestDB(t)
+	convA, convB := uniqueID(t, "members-a"), uniqueID(t, "members-b")
+	if err := db.Create(&[]model.GroupMember{{GroupID: convA, UserID: "u1", JoinTime: 1}, {GroupID: convB, UserID: "u2", JoinTime: 1}}).Error; err != nil {
+		t.Fatalf("seed members: %v", err)
+	}
+	mem := repository.NewMemoryStore()
+	mem.AddMember(convA, "u1")
+	mem.AddMember(convB, "u2")
+
+	backends := map[string]interface {
+		MemberConversations(ctx context.Context, userID string, conversationIDs []string) ([]string, error)
+	}{
+		"memory": mem,
+		"sql":    repository.NewMemberRepository(db),
+	}
+	for name, store := range backends {
+		t.Run(name, func(t *testing.T) {
+			ids, err := store.MemberConversations(context.Background(), "u1", []string{convA, convB, "missing"})
+			if err != nil {
+				t.Fatalf("MemberConversations failed: %v", err)
+			}
+			if len(ids) != 1 || ids[0] != convA {
+				t.Fatalf("expected only %s, got %v", convA, ids)
+			}
+		})
+	}
+}
+
+func TestListSettingsMatchesAcrossBackends(t *testing.T) {
+	db := openTestDB(t)
+	conv := uniqueID(t, "settings")
+	backends := map[string]interface {
+		UpdateSettings(ctx context.Context, userID, conversationID string, updates map[string]interface{}) error
+		ListSettings(ctx context.Context, conversationID string, userIDs []string) (map[string]model.ConversationSettings, error)
+	}{
+		"memory": repository.NewMemoryStore(),
+		"sql":    repository.NewConversationRepository(db),
+	}
+	for name, store := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.UpdateSettings(ctx, "u1", conv, map[string]interface{}{"muted": true}); err != nil {
+				t.Fatalf("UpdateSettings failed: %v", err)
+			}
+			settings, err := store.ListSettings(ctx, conv, []string{"u1", "u2"})
+			if err != nil {
+				t.Fatalf("ListSettings failed: %v", err)
+			}
+			if len(settings) != 1 || !settings["u1"].Muted {
+				t.Fatalf("expected only u1 muted, got %+v", settings)
+			}
+		})
+	}
+}
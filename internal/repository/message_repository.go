@@ -3,14 +3,21 @@ package repository
 import (
 	"context"
 	"errors"
+	"sort"
+	"time"
 
+	"go-im/internal/metrics"
 	"go-im/internal/model"
+	"go-im/internal/tracing"
 
-	"github.com/go-sql-driver/mysql"
-
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
+var tracer = otel.Tracer("go-im/internal/repository")
+
 // MessageRepository 负责消息的持久化。
 type MessageRepository struct {
 	db *gorm.DB
@@ -25,24 +32,49 @@ func (r *MessageRepository) DB() *gorm.DB {
 	return r.db
 }
 
-// SaveMessage 在事务中生成会话内 seq 并写入消息记录。
-func (r *MessageRepository) SaveMessage(ctx context.Context, msg *model.TimelineMessage) error {
+// SaveMessage 在事务中生成会话内 seq 并写入消息记录与去重记录。
+// 发送方在去重窗口内用过该 msg_id 时返回 ErrDuplicateMsgID，由调用方通过 FindDedup 判断是重发还是冲突。
+// span 覆盖整个事务直至提交，msg_id 重复属于幂等重发，只标记属性不记为失败。
+func (r *MessageRepository) SaveMessage(ctx context.Context, msg *model.TimelineMessage) (err error) {
 	// TODO: 实现会话内 seq 生成与写库逻辑（幂等判重、事务内自增 seq）
 	// return errors.New("SaveMessage not implemented")
+	ctx, span := tracer.Start(ctx, "MessageRepository.SaveMessage", trace.WithAttributes(
+		attribute.String("db.system", r.db.Dialector.Name()),
+		attribute.String("im.conversation_id", msg.ConversationID),
+	))
+	defer func() {
+		switch {
+		case err == nil:
+			span.SetAttributes(attribute.Int64("im.seq", int64(msg.Seq)))
+		case errors.Is(err, ErrDuplicateMsgID):
+			span.SetAttributes(attribute.Bool("im.duplicate", true))
+		default:
+			tracing.RecordError(span, err)
+		}
+		span.End()
+	}()
+
 	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		var maxSeq uint64
-		// 查找目前表中最大的seq
+		// 查找目前表中最大的seq，加锁等待时间反映同一会话的写入争用
+		start := time.Now()
 		if err := tx.Raw(
-			"SELECT COALESCE(MAX(seq), 0) FROM timeline_message WHERE conversation_id = ? FOR UPDATE",
+			lockingRead(tx, "SELECT COALESCE(MAX(seq), 0) FROM timeline_message WHERE conversation_id = ?"),
 			msg.ConversationID,
 		).Scan(&maxSeq).Error; err != nil{
 			return err
 		}
+		wait := time.Since(start)
+		metrics.SeqAllocWait.Observe(wait.Seconds())
+		span.AddEvent("seq allocated", trace.WithAttributes(attribute.Int64("im.seq_wait_us", wait.Microseconds())))
 
 		msg.Seq = maxSeq + 1
 		if err := tx.Create(msg).Error; err != nil{
-			var mysqlErr *mysql.MySQLError
-			if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062{
+			return err
+		}
+		dedup := model.NewMessageDedup(msg)
+		if err := tx.Create(&dedup).Error; err != nil {
+			if isDuplicateKey(err) {
 				return ErrDuplicateMsgID
 			}
 			return err
@@ -52,18 +84,144 @@ func (r *MessageRepository) SaveMessage(ctx context.Context, msg *model.Timeline
 	})
 }
 
-// FindByMsgID 根据 msg_id 查询单条消息，用于幂等返回 seq。
-func (r *MessageRepository) FindByMsgID(ctx context.Context, msgID string) (*model.TimelineMessage, error) {
-	// TODO: 实现根据 msg_id 查询的逻辑，未找到时返回 gorm.ErrRecordNotFound
-	var msg model.TimelineMessage
+// SaveMessages 在一个事务中写入一批消息（组提交），按会话分别分配连续 seq，批内顺序即 seq 顺序。
+// results 与 msgs 一一对应：发送方已用过或在批内重复的 msg_id 记为 ErrDuplicateMsgID 且不写入。
+// err 非 nil 时事务已回滚，没有任何消息写入，调用方可逐条重试。
+func (r *MessageRepository) SaveMessages(ctx context.Context, msgs []*model.TimelineMessage) (results []error, err error) {
+	ctx, span := tracer.Start(ctx, "MessageRepository.SaveMessages", trace.WithAttributes(
+		attribute.String("db.system", r.db.Dialector.Name()),
+		attribute.Int("im.batch_size", len(msgs)),
+	))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		results = make([]error, len(msgs))
+		keys := make([][]interface{}, len(msgs))
+		for i, m := range msgs {
+			keys[i] = []interface{}{m.SenderID, m.MsgID}
+		}
+		var existing []model.MessageDedup
+		if err := tx.Select("sender_id", "msg_id").Where("(sender_id, msg_id) IN ?", keys).Find(&existing).Error; err != nil {
+			return err
+		}
+		type dedupKey struct{ sender, msgID string }
+		seen := make(map[dedupKey]bool, len(msgs))
+		for _, d := range existing {
+			seen[dedupKey{d.SenderID, d.MsgID}] = true
+		}
+
+		byConv := make(map[string][]*model.TimelineMessage)
+		var convs []string
+		rows := make([]*model.TimelineMessage, 0, len(msgs))
+		for i, m := range msgs {
+			key := dedupKey{m.SenderID, m.MsgID}
+			if seen[key] {
+				results[i] = ErrDuplicateMsgID
+				continue
+			}
+			seen[key] = true
+			if _, ok := byConv[m.ConversationID]; !ok {
+				convs = append(convs, m.ConversationID)
+			}
+			byConv[m.ConversationID] = append(byConv[m.ConversationID], m)
+			rows = append(rows, m)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		// 按会话 ID 顺序加锁，并发的批次不会互相死锁
+		sort.Strings(convs)
+		start := time.Now()
+		for _, conv := range convs {
+			var maxSeq uint64
+			if err := tx.Raw(
+				lockingRead(tx, "SELECT COALESCE(MAX(seq), 0) FROM timeline_message WHERE conversation_id = ?"),
+				conv,
+			).Scan(&maxSeq).Error; err != nil {
+				return err
+			}
+			for _, m := range byConv[conv] {
+				maxSeq++
+				m.Seq = maxSeq
+			}
+		}
+		metrics.SeqAllocWait.Observe(time.Since(start).Seconds())
+
+		if err := tx.Create(rows).Error; err != nil {
+			return err
+		}
+		dedups := make([]model.MessageDedup, len(rows))
+		for i, m := range rows {
+			dedups[i] = model.NewMessageDedup(m)
+		}
+		if err := tx.Create(&dedups).Error; err != nil {
+			// 判重之后被并发写入抢先，整批回滚由调用方逐条重试
+			if isDuplicateKey(err) {
+				return ErrDuplicateMsgID
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		// 回滚后清掉已分配的 seq 与主键，逐条重试时重新生成
+		for _, m := range msgs {
+			m.ID, m.Seq = 0, 0
+		}
+		return nil, err
+	}
+	return results, nil
+}
+
+// FindDedup 查询发送方对 msg_id 的去重记录，未找到时返回 gorm.ErrRecordNotFound。
+func (r *MessageRepository) FindDedup(ctx context.Context, senderID, msgID string) (*model.MessageDedup, error) {
+	var dedup model.MessageDedup
 	err := r.db.WithContext(ctx).
-		Where("msg_id = ?", msgID).
-		First(&msg).Error
-	if err != nil{
+		Where("sender_id = ? AND msg_id = ?", senderID, msgID).
+		Take(&dedup).Error
+	if err != nil {
+		return nil, err
+	}
+	return &dedup, nil
+}
+
+// PurgeDedup 删除 before 之前的去重记录，返回删除的条数。
+func (r *MessageRepository) PurgeDedup(ctx context.Context, before time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Where("created_at < ?", before).Delete(&model.MessageDedup{})
+	return res.RowsAffected, res.Error
+}
+
+// FindMessages 批量查询 refs 指定的消息，不存在的消息被忽略，同一条消息只返回一次。
+func (r *MessageRepository) FindMessages(ctx context.Context, refs []model.MessageRef) ([]model.TimelineMessage, error) {
+	var msgs []model.TimelineMessage
+	if len(refs) == 0 {
+		return msgs, nil
+	}
+	// 按定位方式 OR 拼接条件，分别走 (conversation_id, seq) 唯一索引与 msg_id 索引
+	var cond *gorm.DB
+	for _, ref := range refs {
+		var q string
+		var args []interface{}
+		if ref.ConversationID != "" && ref.Seq > 0 {
+			q, args = "conversation_id = ? AND seq = ?", []interface{}{ref.ConversationID, ref.Seq}
+		} else {
+			q, args = "sender_id = ? AND msg_id = ?", []interface{}{ref.SenderID, ref.MsgID}
+		}
+		if cond == nil {
+			cond = r.db.Where(q, args...)
+		} else {
+			cond = cond.Or(q, args...)
+		}
+	}
+	if err := r.db.WithContext(ctx).Where(cond).Find(&msgs).Error; err != nil {
 		return nil, err
 	}
-	return &msg, nil
+	return msgs, nil
 }
 
-// ErrDuplicateMsgID 用于幂等冲突识别。
+// ErrDuplicateMsgID 表示发送方在去重窗口内已用过该 msg_id。
 var ErrDuplicateMsgID = errors.New("duplicate msg_id")
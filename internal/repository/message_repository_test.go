@@ -4,24 +4,44 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"slices"
 	"testing"
 	"time"
 
+	"go-im/internal/config"
 	"go-im/internal/model"
 	"go-im/internal/repository"
+
+	"gorm.io/gorm"
 )
 
-func newTestRepo(t *testing.T) *repository.MessageRepository {
+// openTestDB 默认使用 SQLite 内存库，无需外部服务；
+// 设置 IM_DB_DRIVER 时复用正式的数据库配置解析逻辑（例如对 docker-compose 中的 MySQL 跑测试）。
+func openTestDB(t *testing.T) *gorm.DB {
 	t.Helper()
-	// 复用正式的数据库配置解析逻辑，默认走 internal/repository/db.go 内的 DSN。
-	db, err := repository.NewDB()
+	cfg := config.Default().Storage
+	cfg.Driver, cfg.SQLitePath = repository.DriverSQLite, ":memory:"
+	if os.Getenv("IM_DB_DRIVER") != "" {
+		loaded, err := config.Load(os.Getenv("IM_CONFIG"))
+		if err != nil {
+			t.Fatalf("failed to load config: %v", err)
+		}
+		cfg = loaded.Storage
+	}
+	db, err := repository.OpenConfig(cfg, nil)
 	if err != nil {
 		t.Fatalf("failed to init db: %v", err)
 	}
-	if err := db.AutoMigrate(&model.TimelineMessage{}); err != nil {
-		t.Fatalf("failed to migrate timeline_message: %v", err)
+	if err := repository.MigrateLatest(context.Background(), db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
 	}
-	return repository.NewMessageRepository(db)
+	return db
+}
+
+func newTestRepo(t *testing.T) *repository.MessageRepository {
+	t.Helper()
+	return repository.NewMessageRepository(openTestDB(t))
 }
 
 func uniqueID(t *testing.T, prefix string) string {
@@ -108,22 +128,232 @@ func TestSaveMessageDuplicateMsgID(t *testing.T) {
 	}
 }
 
-func TestFindByMsgID(t *testing.T) {
+type hideStore interface {
+	SaveMessage(ctx context.Context, msg *model.TimelineMessage) error
+	FindMessages(ctx context.Context, refs []model.MessageRef) ([]model.TimelineMessage, error)
+	HideMessages(ctx context.Context, userID string, msgs []model.TimelineMessage) error
+	ListHiddenSeqs(ctx context.Context, userID, conversationID string, fromSeq, toSeq int64) ([]int64, error)
+}
+
+// TestHideBySeqWhenMsgIDsCollide 覆盖两个发送方在同一会话使用相同 msg_id：按 seq 或 (sender_id, msg_id) 都只定位到其中一条。
+func TestHideBySeqWhenMsgIDsCollide(t *testing.T) {
+	db := openTestDB(t)
+	sqlRepo := struct {
+		*repository.MessageRepository
+		*repository.HiddenMessageRepository
+	}{repository.NewMessageRepository(db), repository.NewHiddenMessageRepository(db)}
+	backends := map[string]hideStore{
+		"memory": repository.NewMemoryStore(),
+		"sql":    sqlRepo,
+	}
+	for name, store := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			conv, msgID := uniqueID(t, "conv-hide"), uniqueID(t, "same")
+			var saved []*model.TimelineMessage
+			for _, sender := range []string{"u1", "u2"} {
+				msg := &model.TimelineMessage{MsgID: msgID, ConversationID: conv, SenderID: sender, Content: "hi", MsgType: 1, SendTime: time.Now().UnixMilli()}
+				if err := store.SaveMessage(ctx, msg); err != nil {
+					t.Fatalf("SaveMessage failed: %v", err)
+				}
+				saved = append(saved, msg)
+			}
+
+			found, err := store.FindMessages(ctx, []model.MessageRef{
+				{SenderID: "u2", MsgID: msgID},
+				{ConversationID: conv, Seq: int64(saved[1].Seq)}, // 与上一条是同一条消息
+				{ConversationID: conv, Seq: 99},
+			})
+			if err != nil {
+				t.Fatalf("FindMessages failed: %v", err)
+			}
+			if len(found) != 1 || found[0].SenderID != "u2" || found[0].Seq != saved[1].Seq {
+				t.Fatalf("expected only u2's message, got %+v", found)
+			}
+
+			if err := store.HideMessages(ctx, "u3", found); err != nil {
+				t.Fatalf("HideMessages failed: %v", err)
+			}
+			seqs, err := store.ListHiddenSeqs(ctx, "u3", conv, 0, 10)
+			if err != nil || len(seqs) != 1 || seqs[0] != int64(saved[1].Seq) {
+				t.Fatalf("only u2's message should be hidden, got %v, %v", seqs, err)
+			}
+		})
+	}
+}
+
+func TestSaveMessagesAllocatesSeqPerConversation(t *testing.T) {
 	repo := newTestRepo(t)
 	ctx := context.Background()
 
-	msgID := uniqueID(t, "lookup")
-	conv := uniqueID(t, "conv-lookup")
-	msg := &model.TimelineMessage{MsgID: msgID, ConversationID: conv, SenderID: "u1", Content: "hello", MsgType: 1, SendTime: time.Now().UnixMilli()}
-	if err := repo.SaveMessage(ctx, msg); err != nil {
+	convA, convB := uniqueID(t, "conv-batch-a"), uniqueID(t, "conv-batch-b")
+	existing := &model.TimelineMessage{MsgID: uniqueID(t, "batch-existing"), ConversationID: convA, SenderID: "u1", Content: "before", MsgType: 1, SendTime: time.Now().UnixMilli()}
+	if err := repo.SaveMessage(ctx, existing); err != nil {
 		t.Fatalf("SaveMessage failed: %v", err)
 	}
 
-	found, err := repo.FindByMsgID(ctx, msgID)
+	newMsg := func(id, conv string) *model.TimelineMessage {
+		return &model.TimelineMessage{MsgID: id, ConversationID: conv, SenderID: "u1", Content: id, MsgType: 1, SendTime: time.Now().UnixMilli()}
+	}
+	a1, b1, a2 := uniqueID(t, "batch-a1"), uniqueID(t, "batch-b1"), uniqueID(t, "batch-a2")
+	msgs := []*model.TimelineMessage{
+		newMsg(a1, convA),
+		newMsg(b1, convB),
+		newMsg(existing.MsgID, convA), // 已存在
+		newMsg(a2, convA),
+		newMsg(a1, convA), // 批内重复
+	}
+	results, err := repo.SaveMessages(ctx, msgs)
 	if err != nil {
-		t.Fatalf("FindByMsgID failed: %v", err)
+		t.Fatalf("SaveMessages failed: %v", err)
+	}
+
+	wantSeq := []uint64{2, 1, 0, 3, 0}
+	for i, m := range msgs {
+		wantDup := wantSeq[i] == 0
+		if gotDup := errors.Is(results[i], repository.ErrDuplicateMsgID); gotDup != wantDup {
+			t.Fatalf("msg %d: duplicate = %v, want %v (err %v)", i, gotDup, wantDup, results[i])
+		}
+		if !wantDup && m.Seq != wantSeq[i] {
+			t.Fatalf("msg %d: seq = %d, want %d", i, m.Seq, wantSeq[i])
+		}
+	}
+
+	var count int64
+	if err := repo.DB().WithContext(ctx).Model(&model.TimelineMessage{}).Where("conversation_id IN ?", []string{convA, convB}).Count(&count).Error; err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 rows, got %d", count)
+	}
+}
+
+func TestDedupScopedToSenderAndPurged(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	conv := uniqueID(t, "conv-dedup")
+	msgID := uniqueID(t, "shared")
+	for _, sender := range []string{"u1", "u2"} {
+		msg := &model.TimelineMessage{MsgID: msgID, ConversationID: conv, SenderID: sender, Content: sender, MsgType: 1, SendTime: time.Now().UnixMilli()}
+		if err := repo.SaveMessage(ctx, msg); err != nil {
+			t.Fatalf("SaveMessage for %s failed: %v", sender, err)
+		}
+	}
+
+	dedup, err := repo.FindDedup(ctx, "u2", msgID)
+	if err != nil {
+		t.Fatalf("FindDedup failed: %v", err)
+	}
+	if dedup.ConversationID != conv || dedup.Seq != 2 || dedup.ContentHash == "" {
+		t.Fatalf("unexpected dedup record: %+v", dedup)
+	}
+
+	n, err := repo.PurgeDedup(ctx, time.Now().Add(time.Minute))
+	if err != nil || n != 2 {
+		t.Fatalf("PurgeDedup: purged %d, err %v", n, err)
+	}
+	if _, err := repo.FindDedup(ctx, "u1", msgID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected purged record, got %v", err)
+	}
+	// 去重记录过期后同一 msg_id 作为新消息写入
+	again := &model.TimelineMessage{MsgID: msgID, ConversationID: conv, SenderID: "u1", Content: "u1", MsgType: 1, SendTime: time.Now().UnixMilli()}
+	if err := repo.SaveMessage(ctx, again); err != nil || again.Seq != 3 {
+		t.Fatalf("expected new message with seq=3, got seq=%d err=%v", again.Seq, err)
+	}
+}
+
+func TestArchiveRepositoryKeepsLatestAndAdvancesArchivedSeq(t *testing.T) {
+	db := openTestDB(t)
+	repo := repository.NewMessageRepository(db)
+	archive := repository.NewArchiveRepository(db)
+	ctx := context.Background()
+
+	suffix := uniqueID(t, "")
+	group, private, lookalike := "group_"+suffix, "private_"+suffix, "groupx_"+suffix
+	old := time.Now().Add(-48 * time.Hour).UnixMilli()
+	for _, conv := range []string{group, private, lookalike} {
+		for i := 0; i < 3; i++ {
+			msg := &model.TimelineMessage{MsgID: uniqueID(t, conv), ConversationID: conv, SenderID: "u1", Content: "c", MsgType: 1, SendTime: old}
+			if err := repo.SaveMessage(ctx, msg); err != nil {
+				t.Fatalf("SaveMessage failed: %v", err)
+			}
+		}
+	}
+
+	ids, err := archive.ListConversationIDs(ctx, []string{"group"}, nil, "", 1000)
+	if err != nil {
+		t.Fatalf("ListConversationIDs failed: %v", err)
+	}
+	if !slices.Contains(ids, group) || slices.Contains(ids, private) || slices.Contains(ids, lookalike) {
+		t.Fatalf("group type should match only group_ conversations, got %v", ids)
+	}
+	ids, err = archive.ListConversationIDs(ctx, nil, []string{"group", "private"}, "", 1000)
+	if err != nil {
+		t.Fatalf("ListConversationIDs failed: %v", err)
+	}
+	if !slices.Contains(ids, lookalike) || slices.Contains(ids, group) || slices.Contains(ids, private) {
+		t.Fatalf("excluded types should not be listed, got %v", ids)
+	}
+
+	// 三条都已过期，最新一条仍保留
+	upTo, err := archive.ExpiredSeq(ctx, group, time.Now().UnixMilli())
+	if err != nil || upTo != 2 {
+		t.Fatalf("ExpiredSeq = %d, %v; want 2", upTo, err)
+	}
+	msgs, err := archive.ListArchivable(ctx, group, upTo, 1)
+	if err != nil || len(msgs) != 1 || msgs[0].Seq != 1 {
+		t.Fatalf("ListArchivable should return the oldest message first, got %+v, %v", msgs, err)
+	}
+	n, err := archive.DeleteArchived(ctx, group, upTo)
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteArchived: deleted %d, err %v", n, err)
+	}
+
+	pull := repository.NewPullRepository(db)
+	archived, err := pull.GetArchivedSeqs(ctx, []string{group, private})
+	if err != nil || archived[group] != 2 || archived[private] != 0 {
+		t.Fatalf("unexpected archived seqs %v, %v", archived, err)
+	}
+	left, err := pull.ListMessages(ctx, group, 0, 10)
+	if err != nil || len(left) != 1 || left[0].Seq != 3 {
+		t.Fatalf("only the latest message should remain, got %+v, %v", left, err)
+	}
+	next := &model.TimelineMessage{MsgID: uniqueID(t, group), ConversationID: group, SenderID: "u1", Content: "c", MsgType: 1, SendTime: time.Now().UnixMilli()}
+	if err := repo.SaveMessage(ctx, next); err != nil || next.Seq != 4 {
+		t.Fatalf("seq should continue after archival, got %d, %v", next.Seq, err)
+	}
+}
+
+func TestUpsertAcksOnlyAdvances(t *testing.T) {
+	db := openTestDB(t)
+	pull := repository.NewPullRepository(db)
+	ctx := context.Background()
+	user, c1, c2 := uniqueID(t, "u"), uniqueID(t, "c1"), uniqueID(t, "c2")
+
+	if err := pull.UpsertAck(ctx, user, c1, 5); err != nil {
+		t.Fatalf("UpsertAck failed: %v", err)
+	}
+	err := pull.UpsertAcks(ctx, []model.UserConversationState{
+		{UserID: user, ConversationID: c1, LastAckSeq: 3},
+		{UserID: user, ConversationID: c2, LastAckSeq: 7},
+	})
+	if err != nil {
+		t.Fatalf("UpsertAcks failed: %v", err)
+	}
+	if err := pull.UpsertAcks(ctx, []model.UserConversationState{{UserID: user, ConversationID: c2, LastAckSeq: 9}}); err != nil {
+		t.Fatalf("UpsertAcks failed: %v", err)
+	}
+
+	var states []model.UserConversationState
+	if err := db.Where("user_id = ?", user).Order("conversation_id").Find(&states).Error; err != nil {
+		t.Fatalf("query states: %v", err)
+	}
+	got := map[string]int64{}
+	for _, st := range states {
+		got[st.ConversationID] = st.LastAckSeq
 	}
-	if found.Seq != msg.Seq || found.ConversationID != msg.ConversationID || found.MsgID != msg.MsgID {
-		t.Fatalf("unexpected message returned: %+v", found)
+	if len(got) != 2 || got[c1] != 5 || got[c2] != 9 {
+		t.Fatalf("ack positions should only advance, got %v", got)
 	}
 }
@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 迁移脚本按方言存放在 migrations/<driver>/ 下，文件名为 NNNN_name.up.sql 与 NNNN_name.down.sql。
+//
+//go:embed migrations
+var migrationFS embed.FS
+
+// ErrSchemaOutdated 表示数据库尚未执行全部迁移。
+var ErrSchemaOutdated = errors.New("database schema is out of date, run `server migrate up`")
+
+// Migration 是一个版本的升级与回滚脚本。
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus 是某个版本在当前数据库中的执行情况。
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// schemaMigration 对应迁移记录表。
+type schemaMigration struct {
+	Version   int       `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"column:name;size:128;not null"`
+	AppliedAt time.Time `gorm:"column:applied_at;not null"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator 执行内嵌的版本化迁移。
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// loadMigrations 读取指定方言的迁移脚本并按版本升序返回，每个版本必须同时有 up 与 down。
+func loadMigrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for driver %q: %w", driver, err)
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", name)
+		}
+		num, label, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", name)
+		}
+		body, err := fs.ReadFile(migrationFS, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous, missing %d", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Latest 返回内嵌迁移的最新版本。
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	return m.db.WithContext(ctx).Migrator().AutoMigrate(&schemaMigration{})
+}
+
+// Current 返回数据库当前已执行到的版本，迁移记录表不存在时为 0。
+func (m *Migrator) Current(ctx context.Context) (int, error) {
+	if !m.db.WithContext(ctx).Migrator().HasTable(&schemaMigration{}) {
+		return 0, nil
+	}
+	var version int
+	err := m.db.WithContext(ctx).Model(&schemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// Up 依次执行尚未执行的迁移，返回本次执行的版本。
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	current, err := m.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, mig := range m.migrations {
+		if mig.Version <= current {
+			continue
+		}
+		err := m.apply(ctx, mig.Up, func(tx *gorm.DB) error {
+			return tx.Create(&schemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s up: %w", mig.Version, mig.Name, err)
+		}
+		applied = append(applied, mig)
+	}
+	return applied, nil
+}
+
+// Down 回滚最近执行的 steps 个版本，返回本次回滚的版本。
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	current, err := m.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		mig := m.migrations[i]
+		if mig.Version > current {
+			continue
+		}
+		err := m.apply(ctx, mig.Down, func(tx *gorm.DB) error {
+			return tx.Delete(&schemaMigration{}, mig.Version).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("migration %04d_%s down: %w", mig.Version, mig.Name, err)
+		}
+		reverted = append(reverted, mig)
+	}
+	return reverted, nil
+}
+
+// apply 在事务中执行脚本并登记版本。MySQL 的 DDL 会隐式提交，失败时需按报错人工修复。
+func (m *Migrator) apply(ctx context.Context, script string, record func(tx *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range splitStatements(script) {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return record(tx)
+	})
+}
+
+// Status 返回每个内嵌版本的执行情况。
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var rows []schemaMigration
+	if m.db.WithContext(ctx).Migrator().HasTable(&schemaMigration{}) {
+		if err := m.db.WithContext(ctx).Order("version").Find(&rows).Error; err != nil {
+			return nil, err
+		}
+	}
+	appliedAt := make(map[int]time.Time, len(rows))
+	for _, r := range rows {
+		appliedAt[r.Version] = r.AppliedAt
+	}
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if t, ok := appliedAt[mig.Version]; ok {
+			s.AppliedAt = &t
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Check 在数据库版本落后于内嵌迁移时返回 ErrSchemaOutdated，用于启动检查。
+func (m *Migrator) Check(ctx context.Context) error {
+	current, err := m.Current(ctx)
+	if err != nil {
+		return err
+	}
+	if current < m.Latest() {
+		return fmt.Errorf("%w (current=%d, latest=%d)", ErrSchemaOutdated, current, m.Latest())
+	}
+	return nil
+}
+
+// splitStatements 去掉整行注释后按行尾分号拆分脚本，迁移脚本中不要在字符串里换行写分号。
+func splitStatements(script string) []string {
+	var stmts []string
+	var buf strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSpace(buf.String()))
+			buf.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(buf.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+	return stmts
+}
+
+// MigrateLatest 执行全部未执行的迁移，用于 SQLite 开发模式与测试。
+func MigrateLatest(ctx context.Context, db *gorm.DB) error {
+	m, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	_, err = m.Up(ctx)
+	return err
+}
@@ -0,0 +1,169 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"go-im/internal/model"
+	"go-im/internal/repository"
+)
+
+func newTestMigrator(t *testing.T) (*repository.Migrator, func(table string) bool) {
+	t.Helper()
+	db, err := repository.Open(repository.DriverSQLite, ":memory:", repository.PoolConfig{}, nil)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	m, err := repository.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("NewMigrator failed: %v", err)
+	}
+	return m, func(table string) bool { return db.Migrator().HasTable(table) }
+}
+
+func TestMigrateUpDownAndStatus(t *testing.T) {
+	m, hasTable := newTestMigrator(t)
+	ctx := context.Background()
+
+	if err := m.Check(ctx); !errors.Is(err, repository.ErrSchemaOutdated) {
+		t.Fatalf("empty db should be outdated, got %v", err)
+	}
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if len(applied) != m.Latest() {
+		t.Fatalf("expected %d migrations applied, got %d", m.Latest(), len(applied))
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("Check after Up failed: %v", err)
+	}
+	if again, err := m.Up(ctx); err != nil || len(again) != 0 {
+		t.Fatalf("second Up should be a no-op, got %d, %v", len(again), err)
+	}
+
+	if _, err := m.Down(ctx, m.Latest()); err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if hasTable("timeline_message") {
+		t.Fatalf("timeline_message should be dropped after full Down")
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	for _, s := range status {
+		if s.AppliedAt != nil {
+			t.Fatalf("migration %d should be pending after Down", s.Version)
+		}
+	}
+}
+
+// TestMigrationsMatchModels 防止迁移脚本与 Go 模型再次漂移：模型的每个字段都必须在迁移后的表里有对应列。
+func TestMigrationsMatchModels(t *testing.T) {
+	assertSchemaMatchesModels(t, openTestDB(t))
+}
+
+func assertSchemaMatchesModels(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	models := []interface{}{
+		&model.TimelineMessage{},
+		&model.MessageDedup{},
+		&model.ConversationArchive{},
+		&model.User{},
+		&model.UserConversationState{},
+		&model.GroupMember{},
+		&model.DeviceToken{},
+		&model.HiddenMessage{},
+	}
+	for _, mdl := range models {
+		stmt := db.Model(mdl).Statement
+		if err := stmt.Parse(mdl); err != nil {
+			t.Fatalf("parse model %T: %v", mdl, err)
+		}
+		if !db.Migrator().HasTable(mdl) {
+			t.Fatalf("table %s missing", stmt.Schema.Table)
+		}
+		for _, f := range stmt.Schema.Fields {
+			if f.DBName != "" && !db.Migrator().HasColumn(mdl, f.DBName) {
+				t.Errorf("column %s.%s missing", stmt.Schema.Table, f.DBName)
+			}
+		}
+		for name := range stmt.Schema.ParseIndexes() {
+			if !db.Migrator().HasIndex(mdl, name) {
+				t.Errorf("index %s on %s missing", name, stmt.Schema.Table)
+			}
+		}
+	}
+}
+
+// createTables 去掉注释并压缩空白后，返回脚本中的 CREATE TABLE 语句。
+func createTables(t *testing.T, file string) []string {
+	t.Helper()
+	body, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read %s: %v", file, err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(body), "\n") {
+		code, _, _ := strings.Cut(line, "--")
+		lines = append(lines, code)
+	}
+	var stmts []string
+	for _, stmt := range strings.Split(strings.Join(lines, " "), ";") {
+		stmt = strings.Join(strings.Fields(stmt), " ")
+		if strings.HasPrefix(stmt, "CREATE TABLE") {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// TestBaselineMatchesReleasedInitSQL 保证 MySQL 基线与发布版 init.sql 建出的表完全一致，
+// 已由 init.sql 初始化的库跳过基线后，后续迁移才能在其上正确执行。
+func TestBaselineMatchesReleasedInitSQL(t *testing.T) {
+	released := createTables(t, "testdata/released_init.sql")
+	baseline := createTables(t, "migrations/mysql/0001_baseline.up.sql")
+	if len(released) == 0 || !reflect.DeepEqual(released, baseline) {
+		t.Fatalf("baseline differs from released init.sql:\nreleased: %q\nbaseline: %q", released, baseline)
+	}
+}
+
+func TestMigrateUpgradesReleasedSchema(t *testing.T) {
+	db, err := repository.Open(repository.DriverSQLite, ":memory:", repository.PoolConfig{}, nil)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	fixture, err := os.ReadFile("testdata/released_schema_sqlite.sql")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	for _, stmt := range strings.Split(string(fixture), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			if err := db.Exec(stmt).Error; err != nil {
+				t.Fatalf("load released schema: %v", err)
+			}
+		}
+	}
+
+	ctx := context.Background()
+	if err := repository.MigrateLatest(ctx, db); err != nil {
+		t.Fatalf("migrate released schema: %v", err)
+	}
+	assertSchemaMatchesModels(t, db)
+
+	msgs, err := repository.NewPullRepository(db).ListMessages(ctx, "group_1", 0, 10)
+	if err != nil || len(msgs) != 1 || msgs[0].MsgID != "m1" {
+		t.Fatalf("existing messages should survive the upgrade, got %+v %v", msgs, err)
+	}
+	var state model.UserConversationState
+	if err := db.Where("user_id = ? AND conversation_id = ?", "user_2", "group_1").First(&state).Error; err != nil || state.LastAckSeq != 1 {
+		t.Fatalf("existing ack positions should survive the upgrade, got %+v %v", state, err)
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS `group_member`;
+DROP TABLE IF EXISTS `user_conversation_state`;
+DROP TABLE IF EXISTS `user`;
+DROP TABLE IF EXISTS `timeline_message`;
@@ -0,0 +1,40 @@
+-- 基线：与迁移引入前发布的 scripts/init.sql 建出的表结构完全一致（不含测试数据），此后的表结构变更一律放在后续版本。
+-- 使用 IF NOT EXISTS，已由 init.sql 初始化的库执行本步骤只会登记版本。
+
+CREATE TABLE IF NOT EXISTS `timeline_message` (
+    `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    `msg_id` VARCHAR(64) NOT NULL,          -- 客户端生成的唯一ID，用于幂等去重
+    `conversation_id` VARCHAR(64) NOT NULL, -- 会话ID，如 "group_101" 或 "private_u1_u2"
+    `seq` BIGINT UNSIGNED NOT NULL,         -- 会话内序列号（核心字段）
+    `sender_id` VARCHAR(64) NOT NULL,       -- 发送者ID
+    `content` VARCHAR(4096),                -- 消息内容（限制长度，防止超大消息）
+    `msg_type` TINYINT DEFAULT 1,           -- 1:文本, 2:图片
+    `status` TINYINT DEFAULT 0,             -- 0:发送中, 1:已送达, 2:已读
+    `send_time` BIGINT NOT NULL,            -- 发送时间戳
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX `uk_msg_id` (`msg_id`),                    -- 幂等去重索引
+    UNIQUE INDEX `uk_conv_seq` (`conversation_id`, `seq`),  -- 核心：保证会话内seq唯一
+    INDEX `idx_conv_seq` (`conversation_id`, `seq`)         -- 核心：用于范围拉取
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `user` (
+    `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    `user_id` VARCHAR(64) NOT NULL UNIQUE,
+    `nickname` VARCHAR(64),
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `user_conversation_state` (
+    `user_id` VARCHAR(64) NOT NULL,
+    `conversation_id` VARCHAR(64) NOT NULL,
+    `last_ack_seq` BIGINT UNSIGNED DEFAULT 0,  -- 用户在该会话的最后确认序号
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`user_id`, `conversation_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `group_member` (
+    `group_id` VARCHAR(64) NOT NULL,
+    `user_id` VARCHAR(64) NOT NULL,
+    `join_time` BIGINT NOT NULL,
+    PRIMARY KEY (`group_id`, `user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS `user_hidden_message`;
+DROP TABLE IF EXISTS `device_token`;
+ALTER TABLE `user_conversation_state`
+    DROP COLUMN `last_delivered_seq`,
+    DROP COLUMN `muted`,
+    DROP COLUMN `mute_until`,
+    DROP COLUMN `pin_rank`,
+    DROP COLUMN `archived`,
+    DROP COLUMN `notify_level`,
+    DROP COLUMN `cleared_seq`,
+    DROP COLUMN `deleted_seq`;
@@ -0,0 +1,31 @@
+-- 发布版之后新增的会话状态列（送达位点、个人设置、清空与删除位点）以及设备推送令牌、仅对自己隐藏的消息两张表。
+-- msg_id 只在发送方内唯一，隐藏记录以 (user_id, conversation_id, seq) 为主键。
+ALTER TABLE `user_conversation_state`
+    ADD COLUMN `last_delivered_seq` BIGINT UNSIGNED DEFAULT 0 AFTER `last_ack_seq`,
+    ADD COLUMN `muted` TINYINT(1) DEFAULT 0 AFTER `last_delivered_seq`,
+    ADD COLUMN `mute_until` BIGINT DEFAULT 0 AFTER `muted`,
+    ADD COLUMN `pin_rank` BIGINT DEFAULT 0 AFTER `mute_until`,
+    ADD COLUMN `archived` TINYINT(1) DEFAULT 0 AFTER `pin_rank`,
+    ADD COLUMN `notify_level` TINYINT DEFAULT 0 AFTER `archived`,
+    ADD COLUMN `cleared_seq` BIGINT UNSIGNED DEFAULT 0 AFTER `notify_level`,
+    ADD COLUMN `deleted_seq` BIGINT UNSIGNED DEFAULT 0 AFTER `cleared_seq`;
+
+CREATE TABLE IF NOT EXISTS `device_token` (
+    `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    `user_id` VARCHAR(64) NOT NULL,
+    `platform` VARCHAR(16) NOT NULL,
+    `token` VARCHAR(255) NOT NULL,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE INDEX `uk_token` (`token`),
+    INDEX `idx_user` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `user_hidden_message` (
+    `user_id` VARCHAR(64) NOT NULL,
+    `msg_id` VARCHAR(64) NOT NULL,
+    `conversation_id` VARCHAR(64) NOT NULL,
+    `seq` BIGINT UNSIGNED NOT NULL,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`user_id`, `conversation_id`, `seq`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
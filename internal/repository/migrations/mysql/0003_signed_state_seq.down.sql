@@ -0,0 +1,5 @@
+ALTER TABLE `user_conversation_state`
+    MODIFY `last_ack_seq` BIGINT UNSIGNED DEFAULT 0,
+    MODIFY `last_delivered_seq` BIGINT UNSIGNED DEFAULT 0,
+    MODIFY `cleared_seq` BIGINT UNSIGNED DEFAULT 0,
+    MODIFY `deleted_seq` BIGINT UNSIGNED DEFAULT 0;
@@ -0,0 +1,6 @@
+-- 位点列在 Go 模型中为 int64，与之对齐为有符号，避免比较与 CASE 表达式中的无符号溢出。
+ALTER TABLE `user_conversation_state`
+    MODIFY `last_ack_seq` BIGINT DEFAULT 0,
+    MODIFY `last_delivered_seq` BIGINT DEFAULT 0,
+    MODIFY `cleared_seq` BIGINT DEFAULT 0,
+    MODIFY `deleted_seq` BIGINT DEFAULT 0;
@@ -0,0 +1,3 @@
+-- 不同发送方使用了相同 msg_id 时无法恢复全局唯一索引，需先手工处理重复数据。
+ALTER TABLE `timeline_message` DROP INDEX `idx_msg_id`, ADD UNIQUE INDEX `uk_msg_id` (`msg_id`);
+DROP TABLE IF EXISTS `message_dedup`;
@@ -0,0 +1,19 @@
+-- msg_id 的幂等范围从全局收窄到发送方：去重改由 message_dedup 记录，只保留去重窗口内的数据，
+-- timeline_message 上的 msg_id 降为普通索引，不再随消息总量无限增长为唯一约束。
+CREATE TABLE IF NOT EXISTS `message_dedup` (
+    `sender_id` VARCHAR(64) NOT NULL,
+    `msg_id` VARCHAR(64) NOT NULL,
+    `conversation_id` VARCHAR(64) NOT NULL,
+    `seq` BIGINT UNSIGNED NOT NULL,
+    `content_hash` VARCHAR(64) NOT NULL DEFAULT '', -- 类型与内容的 SHA-256，为空时不比较内容
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`sender_id`, `msg_id`),
+    INDEX `idx_dedup_created` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 回填最近一天的消息，升级前发出、升级后重发的消息仍能识别为重复
+INSERT IGNORE INTO `message_dedup` (`sender_id`, `msg_id`, `conversation_id`, `seq`, `created_at`)
+SELECT `sender_id`, `msg_id`, `conversation_id`, `seq`, `created_at` FROM `timeline_message`
+WHERE `created_at` >= NOW() - INTERVAL 1 DAY;
+
+ALTER TABLE `timeline_message` DROP INDEX `uk_msg_id`, ADD INDEX `idx_msg_id` (`msg_id`);
@@ -0,0 +1,10 @@
+-- 消息保留策略：过期消息归档后从 timeline_message 删除，conversation_archive 记录各会话已归档到的 seq，
+-- 拉取越过该位点时提示客户端更早的历史已归档。
+CREATE TABLE IF NOT EXISTS `conversation_archive` (
+    `conversation_id` VARCHAR(64) NOT NULL PRIMARY KEY,
+    `archived_seq` BIGINT NOT NULL DEFAULT 0, -- <= 该 seq 的消息已归档
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 按会话查找过期位点
+ALTER TABLE `timeline_message` ADD INDEX `idx_conv_send_time` (`conversation_id`, `send_time`);
@@ -0,0 +1,39 @@
+-- 基线：与 MySQL 的 0001 对应的 SQLite 表结构。
+
+CREATE TABLE IF NOT EXISTS timeline_message (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    msg_id VARCHAR(64) NOT NULL,
+    conversation_id VARCHAR(64) NOT NULL,
+    seq INTEGER NOT NULL,
+    sender_id VARCHAR(64) NOT NULL,
+    content VARCHAR(4096),
+    msg_type INTEGER DEFAULT 1,
+    status INTEGER DEFAULT 0,
+    send_time INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uk_msg_id ON timeline_message (msg_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uk_conv_seq ON timeline_message (conversation_id, seq);
+CREATE INDEX IF NOT EXISTS idx_conv_seq ON timeline_message (conversation_id, seq);
+
+CREATE TABLE IF NOT EXISTS user (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id VARCHAR(64) NOT NULL UNIQUE,
+    nickname VARCHAR(64),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_conversation_state (
+    user_id VARCHAR(64) NOT NULL,
+    conversation_id VARCHAR(64) NOT NULL,
+    last_ack_seq INTEGER DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, conversation_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_member (
+    group_id VARCHAR(64) NOT NULL,
+    user_id VARCHAR(64) NOT NULL,
+    join_time INTEGER NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
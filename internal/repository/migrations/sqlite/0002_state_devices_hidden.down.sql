@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS user_hidden_message;
+DROP TABLE IF EXISTS device_token;
+ALTER TABLE user_conversation_state DROP COLUMN last_delivered_seq;
+ALTER TABLE user_conversation_state DROP COLUMN muted;
+ALTER TABLE user_conversation_state DROP COLUMN mute_until;
+ALTER TABLE user_conversation_state DROP COLUMN pin_rank;
+ALTER TABLE user_conversation_state DROP COLUMN archived;
+ALTER TABLE user_conversation_state DROP COLUMN notify_level;
+ALTER TABLE user_conversation_state DROP COLUMN cleared_seq;
+ALTER TABLE user_conversation_state DROP COLUMN deleted_seq;
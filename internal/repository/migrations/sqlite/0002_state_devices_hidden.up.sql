@@ -0,0 +1,29 @@
+-- 发布版之后新增的会话状态列与两张表，见 mysql 同名脚本。
+ALTER TABLE user_conversation_state ADD COLUMN last_delivered_seq INTEGER DEFAULT 0;
+ALTER TABLE user_conversation_state ADD COLUMN muted NUMERIC DEFAULT 0;
+ALTER TABLE user_conversation_state ADD COLUMN mute_until INTEGER DEFAULT 0;
+ALTER TABLE user_conversation_state ADD COLUMN pin_rank INTEGER DEFAULT 0;
+ALTER TABLE user_conversation_state ADD COLUMN archived NUMERIC DEFAULT 0;
+ALTER TABLE user_conversation_state ADD COLUMN notify_level INTEGER DEFAULT 0;
+ALTER TABLE user_conversation_state ADD COLUMN cleared_seq INTEGER DEFAULT 0;
+ALTER TABLE user_conversation_state ADD COLUMN deleted_seq INTEGER DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS device_token (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id VARCHAR(64) NOT NULL,
+    platform VARCHAR(16) NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uk_token ON device_token (token);
+CREATE INDEX IF NOT EXISTS idx_user ON device_token (user_id);
+
+CREATE TABLE IF NOT EXISTS user_hidden_message (
+    user_id VARCHAR(64) NOT NULL,
+    msg_id VARCHAR(64) NOT NULL,
+    conversation_id VARCHAR(64) NOT NULL,
+    seq INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, conversation_id, seq)
+);
@@ -0,0 +1 @@
+-- SQLite 的 INTEGER 本身有符号，无需变更；保留空步骤使两种方言版本号一致。
@@ -0,0 +1,4 @@
+-- 不同发送方使用了相同 msg_id 时无法恢复全局唯一索引，需先手工处理重复数据。
+DROP INDEX IF EXISTS idx_msg_id;
+CREATE UNIQUE INDEX IF NOT EXISTS uk_msg_id ON timeline_message (msg_id);
+DROP TABLE IF EXISTS message_dedup;
@@ -0,0 +1,18 @@
+-- msg_id 的幂等范围从全局收窄到发送方，见 mysql 同名脚本。
+CREATE TABLE IF NOT EXISTS message_dedup (
+    sender_id VARCHAR(64) NOT NULL,
+    msg_id VARCHAR(64) NOT NULL,
+    conversation_id VARCHAR(64) NOT NULL,
+    seq INTEGER NOT NULL,
+    content_hash VARCHAR(64) NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (sender_id, msg_id)
+);
+CREATE INDEX IF NOT EXISTS idx_dedup_created ON message_dedup (created_at);
+
+INSERT OR IGNORE INTO message_dedup (sender_id, msg_id, conversation_id, seq, created_at)
+SELECT sender_id, msg_id, conversation_id, seq, created_at FROM timeline_message
+WHERE created_at >= datetime('now', '-1 day');
+
+DROP INDEX IF EXISTS uk_msg_id;
+CREATE INDEX IF NOT EXISTS idx_msg_id ON timeline_message (msg_id);
@@ -0,0 +1,3 @@
+-- 已归档并删除的消息不会恢复，需从归档文件导回。
+DROP INDEX IF EXISTS idx_conv_send_time;
+DROP TABLE IF EXISTS conversation_archive;
@@ -0,0 +1,8 @@
+-- 消息保留策略，见 mysql 同名脚本。
+CREATE TABLE IF NOT EXISTS conversation_archive (
+    conversation_id VARCHAR(64) NOT NULL PRIMARY KEY,
+    archived_seq INTEGER NOT NULL DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_conv_send_time ON timeline_message (conversation_id, send_time);
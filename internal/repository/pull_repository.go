@@ -3,10 +3,15 @@ package repository
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"go-im/internal/model"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // PullRepository 提供离线拉取和 ACK 所需的数据访问。
@@ -39,15 +44,160 @@ func (r *PullRepository) ListMessages(ctx context.Context, conversationID string
 	return messages, nil
 }
 
+// batchPullChunk 为批量拉取时单条语句覆盖的会话数，限制语句长度与占位符个数。
+const batchPullChunk = 100
+
+// ListMessagesBatch 按各会话的游标拉取消息，每个会话最多 limit 条，返回 conversation_id -> 升序列表。
+// 每 batchPullChunk 个会话合成一条 UNION ALL 语句，各分支独立走 (conversation_id, seq) 索引，
+// 查询次数为 ceil(len(cursors)/batchPullChunk)。
+func (r *PullRepository) ListMessagesBatch(ctx context.Context, cursors []model.PullCursor, limit int) (map[string][]model.TimelineMessage, error) {
+	table := model.TimelineMessage{}.TableName()
+	result := make(map[string][]model.TimelineMessage, len(cursors))
+	for start := 0; start < len(cursors); start += batchPullChunk {
+		chunk := cursors[start:min(start+batchPullChunk, len(cursors))]
+		parts := make([]string, 0, len(chunk))
+		args := make([]interface{}, 0, 3*len(chunk))
+		for i, c := range chunk {
+			if c.ConversationId == "" {
+				return nil, errors.New("conversationID cannot be empty")
+			}
+			// 子查询包一层，MySQL 与 SQLite 才都允许在 UNION 分支内使用 ORDER BY / LIMIT
+			parts = append(parts, fmt.Sprintf("SELECT * FROM (SELECT * FROM %s WHERE conversation_id = ? AND seq > ? ORDER BY seq ASC LIMIT ?) AS p%d", table, i))
+			args = append(args, c.ConversationId, c.CursorSeq, limit)
+		}
+		var messages []model.TimelineMessage
+		if err := r.db.WithContext(ctx).Raw(strings.Join(parts, " UNION ALL "), args...).Scan(&messages).Error; err != nil {
+			return nil, err
+		}
+		for _, m := range messages {
+			result[m.ConversationID] = append(result[m.ConversationID], m)
+		}
+	}
+	// UNION ALL 不保证分支内的顺序
+	for _, list := range result {
+		sort.Slice(list, func(i, j int) bool { return list[i].Seq < list[j].Seq })
+	}
+	return result, nil
+}
+
 // UpsertAck 插入或更新用户在会话的 last_ack_seq，ackSeq 只有在更大时才更新。
 func (r *PullRepository) UpsertAck(ctx context.Context, userID, conversationID string, ackSeq int64) error {
 	// TODO: 实现 user_conversation_state 的插入/更新逻辑
 	if userID == "" || conversationID == "" {
 		return errors.New("userId and conversationId required")
 	}
-	return r.db.WithContext(ctx).Exec(`
-	INSERT INTO user_conversation_state (user_id, conversation_id, last_ack_seq)
-	VALUES (?, ?, ?)
-	ON DUPLICATE KEY UPDATE last_ack_seq = GREATEST(last_ack_seq, VALUES(last_ack_seq))
-	`, userID, conversationID, ackSeq).Error
+	return upsertMaxSeq(ctx, r.db, userID, conversationID, "last_ack_seq", ackSeq)
+}
+
+// ackUpsertChunk 为批量写入已读位点时每条语句的行数上限，避免超出占位符数量限制。
+const ackUpsertChunk = 500
+
+// UpsertAcks 以多行 upsert 推进多个 (用户, 会话) 的 last_ack_seq，只前进不回退，
+// 每 ackUpsertChunk 行一条语句，供 ACK 合并器批量刷新。
+func (r *PullRepository) UpsertAcks(ctx context.Context, states []model.UserConversationState) error {
+	now := time.Now()
+	rows := make([]map[string]interface{}, 0, len(states))
+	for _, st := range states {
+		if st.UserID == "" || st.ConversationID == "" {
+			return errors.New("userId and conversationId required")
+		}
+		rows = append(rows, map[string]interface{}{
+			"user_id":         st.UserID,
+			"conversation_id": st.ConversationID,
+			"last_ack_seq":    st.LastAckSeq,
+			"updated_at":      now,
+		})
+	}
+	inserted := insertedValue(r.db, "last_ack_seq")
+	for start := 0; start < len(rows); start += ackUpsertChunk {
+		chunk := rows[start:min(start+ackUpsertChunk, len(rows))]
+		err := r.db.WithContext(ctx).Model(&model.UserConversationState{}).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "conversation_id"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "last_ack_seq"}, Value: gorm.Expr("CASE WHEN last_ack_seq < " + inserted + " THEN " + inserted + " ELSE last_ack_seq END")},
+				{Column: clause.Column{Name: "updated_at"}, Value: now},
+			},
+		}).Create(chunk).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpsertDelivered 更新用户在会话的 last_delivered_seq，同样只前进不回退。
+func (r *PullRepository) UpsertDelivered(ctx context.Context, userID, conversationID string, seq int64) error {
+	if userID == "" || conversationID == "" {
+		return errors.New("userId and conversationId required")
+	}
+	return upsertMaxSeq(ctx, r.db, userID, conversationID, "last_delivered_seq", seq)
+}
+
+// GetClearedSeq 返回用户在会话的清空历史水位，无记录时为 0。
+func (r *PullRepository) GetClearedSeq(ctx context.Context, userID, conversationID string) (int64, error) {
+	var state model.UserConversationState
+	err := r.db.WithContext(ctx).
+		Select("cleared_seq").
+		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+		Take(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return state.ClearedSeq, nil
+}
+
+// GetClearedSeqs 批量返回用户在各会话的清空历史水位，无记录或未清空的会话不出现在结果中。
+func (r *PullRepository) GetClearedSeqs(ctx context.Context, userID string, conversationIDs []string) (map[string]int64, error) {
+	var states []model.UserConversationState
+	err := r.db.WithContext(ctx).
+		Select("conversation_id", "cleared_seq").
+		Where("user_id = ? AND conversation_id IN ? AND cleared_seq > 0", userID, conversationIDs).
+		Find(&states).Error
+	if err != nil {
+		return nil, err
+	}
+	cleared := make(map[string]int64, len(states))
+	for _, st := range states {
+		cleared[st.ConversationID] = st.ClearedSeq
+	}
+	return cleared, nil
+}
+
+// upsertMaxSeq 插入或更新 user_conversation_state 的某个 seq 水位列，只在新值更大时更新。
+// 用 CASE 表达式代替 MySQL 专有的 GREATEST/VALUES()，MySQL 与 SQLite 通用。
+func upsertMaxSeq(ctx context.Context, db *gorm.DB, userID, conversationID, column string, seq int64) error {
+	now := time.Now()
+	row := map[string]interface{}{
+		"user_id":         userID,
+		"conversation_id": conversationID,
+		column:            seq,
+		"updated_at":      now,
+	}
+	return db.WithContext(ctx).Model(&model.UserConversationState{}).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "conversation_id"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: column}, Value: gorm.Expr("CASE WHEN "+column+" < ? THEN ? ELSE "+column+" END", seq, seq)},
+			{Column: clause.Column{Name: "updated_at"}, Value: now},
+		},
+	}).Create(row).Error
+}
+
+// GetArchivedSeqs 返回各会话按保留策略归档到的 seq，未归档过的会话不出现在结果中。
+func (r *PullRepository) GetArchivedSeqs(ctx context.Context, conversationIDs []string) (map[string]int64, error) {
+	var rows []model.ConversationArchive
+	err := r.db.WithContext(ctx).
+		Select("conversation_id", "archived_seq").
+		Where("conversation_id IN ? AND archived_seq > 0", conversationIDs).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	archived := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		archived[row.ConversationID] = row.ArchivedSeq
+	}
+	return archived, nil
 }
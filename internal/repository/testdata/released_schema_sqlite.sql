@@ -0,0 +1,37 @@
+-- 发布版 scripts/init.sql 建出的表结构在 SQLite 下的等价写法，用于测试在已有库上执行迁移。
+CREATE TABLE timeline_message (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    msg_id VARCHAR(64) NOT NULL,
+    conversation_id VARCHAR(64) NOT NULL,
+    seq INTEGER NOT NULL,
+    sender_id VARCHAR(64) NOT NULL,
+    content VARCHAR(4096),
+    msg_type INTEGER DEFAULT 1,
+    status INTEGER DEFAULT 0,
+    send_time INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX uk_msg_id ON timeline_message (msg_id);
+CREATE UNIQUE INDEX uk_conv_seq ON timeline_message (conversation_id, seq);
+CREATE INDEX idx_conv_seq ON timeline_message (conversation_id, seq);
+CREATE TABLE user (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id VARCHAR(64) NOT NULL UNIQUE,
+    nickname VARCHAR(64),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE user_conversation_state (
+    user_id VARCHAR(64) NOT NULL,
+    conversation_id VARCHAR(64) NOT NULL,
+    last_ack_seq INTEGER DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, conversation_id)
+);
+CREATE TABLE group_member (
+    group_id VARCHAR(64) NOT NULL,
+    user_id VARCHAR(64) NOT NULL,
+    join_time INTEGER NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
+INSERT INTO timeline_message (msg_id, conversation_id, seq, sender_id, content, send_time) VALUES ('m1', 'group_1', 1, 'user_1', 'hello', 1700000000000);
+INSERT INTO user_conversation_state (user_id, conversation_id, last_ack_seq) VALUES ('user_2', 'group_1', 1);
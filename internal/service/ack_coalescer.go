@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go-im/internal/metrics"
+	"go-im/internal/model"
+)
+
+// ErrTooManyAcks 表示批量 ACK 的条目数超过上限。
+var ErrTooManyAcks = errors.New("too many acks in one request")
+
+// maxBatchAcks 为单次批量 ACK 的条目上限。
+const maxBatchAcks = 1000
+
+// AckStore 持久化已读位点，UpsertAck 与 UpsertAcks 都只前进不回退。
+type AckStore interface {
+	UpsertAck(ctx context.Context, userID, conversationID string, ackSeq int64) error
+	// UpsertAcks 按每行的 LastAckSeq 推进多个 (用户, 会话) 的已读位点，(用户, 会话) 在 states 中不重复。
+	UpsertAcks(ctx context.Context, states []model.UserConversationState) error
+}
+
+// AckCoalescer 合并已读 ACK：在内存中按 (用户, 会话) 只保留最大 seq，定期或在用户断开时写库。
+// 仓储的 UpsertAck 只前进不回退，因此合并、乱序写入以及失败后重试都不会让位点倒退；
+// 代价是进程崩溃时会丢失最近一个刷新周期内的 ACK，客户端下次 ACK 时即可补上。
+// interval 为 0 时不合并，每次 ACK 直接写库。
+type AckCoalescer struct {
+	store    AckStore
+	members  MembershipChecker // 可为 nil，此时不校验是否为会话成员
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	pending map[string]map[string]int64 // user_id -> conversation_id -> 待写入的最大 seq
+}
+
+// NewAckCoalescer 创建 ACK 合并器，logger 为 nil 时使用 slog.Default()。
+func NewAckCoalescer(store AckStore, members MembershipChecker, interval time.Duration, logger *slog.Logger) *AckCoalescer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &AckCoalescer{store: store, members: members, interval: interval, logger: logger, pending: make(map[string]map[string]int64)}
+}
+
+// Ack 记录用户在会话的已读位点，开启合并时只更新内存，由后续刷新写库。
+// userID 不是会话成员时返回 ErrNotMember。
+func (c *AckCoalescer) Ack(ctx context.Context, userID, conversationID string, seq int64) error {
+	if userID == "" || conversationID == "" {
+		return errors.New("userId and conversationId required")
+	}
+	if err := requireMember(ctx, c.members, userID, conversationID); err != nil {
+		return err
+	}
+	return c.record(ctx, userID, conversationID, seq)
+}
+
+// AckBatch 记录用户在多个会话的已读位点，条目先整体校验，任一条目非法时都不记录。
+// 成员关系以一次查询校验，任一会话不是 userID 所在的会话时返回 ErrNotMember。
+func (c *AckCoalescer) AckBatch(ctx context.Context, userID string, acks []model.AckEntry) error {
+	if len(acks) > maxBatchAcks {
+		return ErrTooManyAcks
+	}
+	ids := make([]string, len(acks))
+	for i, a := range acks {
+		if userID == "" || a.ConversationId == "" {
+			return errors.New("userId and conversationId required")
+		}
+		ids[i] = a.ConversationId
+	}
+	if len(acks) == 0 {
+		return nil
+	}
+	member, err := memberSet(ctx, c.members, userID, ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if !member[id] {
+			return ErrNotMember
+		}
+	}
+	for _, a := range acks {
+		if err := c.record(ctx, userID, a.ConversationId, a.Seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record 记录一条已校验的 ACK：合并时只更新内存，否则直接写库。
+func (c *AckCoalescer) record(ctx context.Context, userID, conversationID string, seq int64) error {
+	metrics.AcksReceived.Inc()
+	if c.interval <= 0 {
+		metrics.AckWrites.Inc()
+		return c.store.UpsertAck(ctx, userID, conversationID, seq)
+	}
+	c.mu.Lock()
+	c.merge(userID, conversationID, seq)
+	c.mu.Unlock()
+	return nil
+}
+
+// merge 保留较大的 seq，调用方需持有锁。
+func (c *AckCoalescer) merge(userID, conversationID string, seq int64) {
+	convs := c.pending[userID]
+	if convs == nil {
+		convs = make(map[string]int64)
+		c.pending[userID] = convs
+	}
+	if cur, ok := convs[conversationID]; !ok || seq > cur {
+		convs[conversationID] = seq
+	}
+}
+
+// FlushUser 立即写入用户的待刷新位点，在用户断开时调用。
+func (c *AckCoalescer) FlushUser(ctx context.Context, userID string) error {
+	c.mu.Lock()
+	convs := c.pending[userID]
+	delete(c.pending, userID)
+	c.mu.Unlock()
+	return c.write(ctx, map[string]map[string]int64{userID: convs})
+}
+
+// Flush 写入全部待刷新位点，由 Run 定期调用，退出前也应调用一次。
+func (c *AckCoalescer) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = make(map[string]map[string]int64)
+	c.mu.Unlock()
+	return c.write(ctx, batch)
+}
+
+// write 以一次批量 upsert 写入一批位点，失败时整批合并回内存等待下次刷新。
+func (c *AckCoalescer) write(ctx context.Context, batch map[string]map[string]int64) error {
+	var states []model.UserConversationState
+	for userID, convs := range batch {
+		for conversationID, seq := range convs {
+			states = append(states, model.UserConversationState{UserID: userID, ConversationID: conversationID, LastAckSeq: seq})
+		}
+	}
+	if len(states) == 0 {
+		return nil
+	}
+	metrics.AckWrites.Add(float64(len(states)))
+	err := c.store.UpsertAcks(ctx, states)
+	if err != nil {
+		c.mu.Lock()
+		for _, st := range states {
+			c.merge(st.UserID, st.ConversationID, st.LastAckSeq)
+		}
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Pending 返回待写入的位点数。
+func (c *AckCoalescer) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, convs := range c.pending {
+		n += len(convs)
+	}
+	return n
+}
+
+// Run 按 interval 定期刷新，直到 ctx 取消；未开启合并时直接返回。
+func (c *AckCoalescer) Run(ctx context.Context) {
+	if c.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Flush(ctx); err != nil {
+				c.logger.Warn("刷新已读位点失败，下次重试", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+// ackStore 记录每次写入，并像仓储一样只前进不回退。
+type ackStore struct {
+	mu      sync.Mutex
+	writes  int // 单条写入次数
+	batches int // 批量写入次数
+	seqs    map[string]int64
+	fail    bool
+}
+
+func newAckStore() *ackStore { return &ackStore{seqs: make(map[string]int64)} }
+
+func (s *ackStore) UpsertAck(ctx context.Context, userID, conversationID string, ackSeq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("db down")
+	}
+	s.writes++
+	s.advance(userID, conversationID, ackSeq)
+	return nil
+}
+
+func (s *ackStore) UpsertAcks(ctx context.Context, states []model.UserConversationState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("db down")
+	}
+	s.batches++
+	for _, st := range states {
+		s.advance(st.UserID, st.ConversationID, st.LastAckSeq)
+	}
+	return nil
+}
+
+func (s *ackStore) advance(userID, conversationID string, seq int64) {
+	key := userID + "/" + conversationID
+	if seq > s.seqs[key] {
+		s.seqs[key] = seq
+	}
+}
+
+func TestAckCoalescerKeepsMaxSeqPerConversation(t *testing.T) {
+	ctx := context.Background()
+	store := newAckStore()
+	c := service.NewAckCoalescer(store, nil, time.Minute, nil)
+
+	for _, seq := range []int64{3, 7, 5} {
+		if err := c.Ack(ctx, "u1", "c1", seq); err != nil {
+			t.Fatalf("ack: %v", err)
+		}
+	}
+	if err := c.AckBatch(ctx, "u1", []model.AckEntry{{ConversationId: "c1", Seq: 6}, {ConversationId: "c2", Seq: 2}}); err != nil {
+		t.Fatalf("batch ack: %v", err)
+	}
+	if store.writes != 0 || store.batches != 0 {
+		t.Fatalf("acks should be buffered until flush, got %d writes", store.writes+store.batches)
+	}
+	if c.Pending() != 2 {
+		t.Fatalf("pending = %d, want 2", c.Pending())
+	}
+
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if store.writes != 0 || store.batches != 1 || store.seqs["u1/c1"] != 7 || store.seqs["u1/c2"] != 2 {
+		t.Fatalf("want one batch write with max seq per conversation, got %d writes %d batches %v", store.writes, store.batches, store.seqs)
+	}
+	if c.Pending() != 0 {
+		t.Fatalf("pending after flush = %d", c.Pending())
+	}
+}
+
+func TestAckCoalescerFlushUser(t *testing.T) {
+	ctx := context.Background()
+	store := newAckStore()
+	c := service.NewAckCoalescer(store, nil, time.Minute, nil)
+	_ = c.Ack(ctx, "u1", "c1", 4)
+	_ = c.Ack(ctx, "u2", "c1", 9)
+
+	if err := c.FlushUser(ctx, "u1"); err != nil {
+		t.Fatalf("flush user: %v", err)
+	}
+	if store.seqs["u1/c1"] != 4 || store.seqs["u2/c1"] != 0 {
+		t.Fatalf("only u1 should be written, got %v", store.seqs)
+	}
+	if c.Pending() != 1 {
+		t.Fatalf("u2 should remain pending, got %d", c.Pending())
+	}
+}
+
+func TestAckCoalescerRetriesFailedFlush(t *testing.T) {
+	ctx := context.Background()
+	store := newAckStore()
+	c := service.NewAckCoalescer(store, nil, time.Minute, nil)
+	_ = c.Ack(ctx, "u1", "c1", 4)
+
+	store.fail = true
+	if err := c.Flush(ctx); err == nil {
+		t.Fatal("flush should report the store error")
+	}
+	// 失败期间收到的较小 seq 不会覆盖待重试的位点
+	_ = c.Ack(ctx, "u1", "c1", 2)
+	store.fail = false
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("retry flush: %v", err)
+	}
+	if store.seqs["u1/c1"] != 4 {
+		t.Fatalf("retried seq = %d, want 4", store.seqs["u1/c1"])
+	}
+}
+
+func TestAckCoalescerWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	store := newAckStore()
+	c := service.NewAckCoalescer(store, nil, 0, nil)
+	_ = c.Ack(ctx, "u1", "c1", 3)
+	_ = c.Ack(ctx, "u1", "c1", 5)
+	if store.writes != 2 || store.seqs["u1/c1"] != 5 || c.Pending() != 0 {
+		t.Fatalf("interval 0 should write every ack, got %d writes %v", store.writes, store.seqs)
+	}
+}
+
+func TestAckCoalescerRejectsInvalidBatch(t *testing.T) {
+	ctx := context.Background()
+	c := service.NewAckCoalescer(newAckStore(), nil, time.Minute, nil)
+	if err := c.AckBatch(ctx, "u1", []model.AckEntry{{ConversationId: "c1", Seq: 1}, {Seq: 2}}); err == nil {
+		t.Fatal("entry without conversation should be rejected")
+	}
+	if c.Pending() != 0 {
+		t.Fatalf("invalid batch should record nothing, got %d", c.Pending())
+	}
+	if err := c.AckBatch(ctx, "u1", make([]model.AckEntry, 1001)); !errors.Is(err, service.ErrTooManyAcks) {
+		t.Fatalf("oversized batch: got %v", err)
+	}
+}
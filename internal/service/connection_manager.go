@@ -1,51 +1,285 @@
 package service
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+
+	"go-im/internal/metrics"
+	"go-im/internal/model"
 )
 
+// Connection 包装一条 WebSocket 连接。gorilla/websocket 不允许并发写，
+// 读循环的回包与推送可能来自不同 goroutine，因此写操作在这里串行化并统一设置写超时。
+type Connection struct {
+	UserID      string
+	ID          string // 连接 ID，用于在日志中关联同一连接的记录
+	Device      string // 客户端声明的设备标识，可为空
+	RemoteAddr  string
+	ConnectedAt time.Time
+	Log         *slog.Logger // 带 user、device、conn_id、remote 字段的连接级 logger
+	Protocol    Protocol     // 握手协商的协议版本与能力，在连接加入管理器前设置
+
+	lastActive   atomic.Int64 // 最近一次收到客户端指令的 Unix 纳秒时间
+	conn         *websocket.Conn
+	writeMu      sync.Mutex
+	writeTimeout time.Duration
+}
+
+// NewConnection 创建连接包装并分配连接 ID，logger 为 nil 时使用 slog.Default()。
+func NewConnection(userID, device, remoteAddr string, conn *websocket.Conn, writeTimeout time.Duration, logger *slog.Logger) *Connection {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	id := newConnID()
+	c := &Connection{
+		UserID:       userID,
+		ID:           id,
+		Device:       device,
+		RemoteAddr:   remoteAddr,
+		ConnectedAt:  time.Now(),
+		Log:          logger.With("user", userID, "device", device, "conn_id", id, "remote", remoteAddr),
+		Protocol:     Protocol{Version: model.ProtocolV1, Capabilities: legacyCapabilities},
+		conn:         conn,
+		writeTimeout: writeTimeout,
+	}
+	c.Touch(c.ConnectedAt)
+	return c
+}
+
+// Touch 记录一次客户端活动。
+func (c *Connection) Touch(now time.Time) {
+	c.lastActive.Store(now.UnixNano())
+}
+
+// LastActive 返回最近一次客户端活动的时间，未收到任何指令时为建连时间。
+func (c *Connection) LastActive() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+func newConnID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Has 判断连接是否协商了能力 capability。
+func (c *Connection) Has(capability string) bool {
+	return c.Protocol.Has(capability)
+}
+
+// WriteJSON 串行写入 JSON，实现 ConnWriter。未标记 Kind 的 OutputPacket 按主动推送写出，
+// 并按连接协商的协议版本去掉旧版本客户端不认识的字段。
+func (c *Connection) WriteJSON(v interface{}) error {
+	metrics.WriteWaiting.Inc()
+	c.writeMu.Lock()
+	metrics.WriteWaiting.Dec()
+	defer c.writeMu.Unlock()
+	packet, isPacket := v.(model.OutputPacket)
+	if isPacket {
+		if packet.Kind == "" {
+			// 只有回包会由 handler 标记为 response，其余下行包都是主动推送
+			packet.Kind = model.KindPush
+		}
+		v = packet.ForVersion(c.Protocol.Version)
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	err := c.conn.WriteJSON(v)
+	if isPacket && err == nil {
+		metrics.PacketsOut.WithLabelValues(packet.Cmd.String()).Inc()
+	}
+	return err
+}
+
+// Close 关闭底层连接，重复调用是安全的。
+func (c *Connection) Close() error {
+	return c.conn.Close()
+}
+
+// CloseWith 先发送带关闭码的 Close 帧再关闭连接，让客户端区分正常下线与网络中断。
+func (c *Connection) CloseWith(code int, reason string) error {
+	c.writeMu.Lock()
+	msg := websocket.FormatCloseMessage(code, reason)
+	_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.writeTimeout))
+	c.writeMu.Unlock()
+	return c.conn.Close()
+}
+
+// ErrTooManyConnections 表示在线连接数已达上限。
+var ErrTooManyConnections = errors.New("too many connections")
+
+// maxConnsPerUser 为单个用户同时在线的连接数上限，超出时关闭该用户最早建立的连接，
+// 避免未声明设备标识的客户端反复重连时残留的旧连接无限累积。
+const maxConnsPerUser = 10
+
 // ConnectionManager 负责管理所有在线的 WebSocket 连接，使用读写锁保证并发安全。
+// 同一用户可以有多条连接（多端同时在线），推送与多端同步写给其全部连接；
+// 声明了相同设备标识的新连接替换该设备的旧连接。
 type ConnectionManager struct {
-	mu    sync.RWMutex
-	conns map[string]*websocket.Conn
+	mu             sync.RWMutex
+	conns          map[string]map[string]*Connection // user_id -> conn_id -> 连接
+	total          int
+	maxConnections atomic.Int64 // 0 表示不限，可在运行时调整
 }
 
 // NewConnectionManager 创建一个连接管理器实例。
 func NewConnectionManager() *ConnectionManager {
 	return &ConnectionManager{
-		conns: make(map[string]*websocket.Conn),
+		conns: make(map[string]map[string]*Connection),
 	}
 }
 
-// Add 注册一个新的连接；如果同一用户已存在旧连接，则先关闭旧连接再覆盖。
-func (m *ConnectionManager) Add(userID string, conn *websocket.Conn) {
+// SetMaxConnections 调整在线连接上限，只影响之后的新连接，已建立的连接不受影响。
+func (m *ConnectionManager) SetMaxConnections(n int) {
+	m.maxConnections.Store(int64(n))
+}
+
+// Add 注册一个新的连接。连接声明的设备已有旧连接时关闭旧连接并替换，设备重连不受上限约束；
+// 其余新连接在已达上限时返回 ErrTooManyConnections。用户连接数超过 maxConnsPerUser 时关闭其最早的连接。
+func (m *ConnectionManager) Add(userID string, conn *Connection) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if old, ok := m.conns[userID]; ok {
-		_ = old.Close()
+	byID := m.conns[userID]
+	var replaced *Connection
+	if conn.Device != "" {
+		for _, c := range byID {
+			if c.Device == conn.Device {
+				replaced = c
+				break
+			}
+		}
+	}
+	if max := m.maxConnections.Load(); replaced == nil && max > 0 && int64(m.total) >= max {
+		return ErrTooManyConnections
+	}
+	if byID == nil {
+		byID = make(map[string]*Connection)
+		m.conns[userID] = byID
+	}
+	if replaced == nil && len(byID) >= maxConnsPerUser {
+		for _, c := range byID {
+			if replaced == nil || c.ConnectedAt.Before(replaced.ConnectedAt) {
+				replaced = c
+			}
+		}
 	}
-	m.conns[userID] = conn
+	if replaced != nil {
+		_ = replaced.Close()
+		delete(byID, replaced.ID)
+		m.total--
+	}
+	byID[conn.ID] = conn
+	m.total++
+	return nil
+}
+
+// Kick 关闭用户的全部连接，读循环退出后会自行移除，返回用户是否在线。
+func (m *ConnectionManager) Kick(userID string) bool {
+	conns := m.UserConnections(userID)
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	return len(conns) > 0
+}
+
+// Disconnect 按连接 ID 强制断开连接并通知客户端原因，返回连接是否存在。
+func (m *ConnectionManager) Disconnect(connID, reason string) bool {
+	m.mu.RLock()
+	var target *Connection
+	for _, byID := range m.conns {
+		if c, ok := byID[connID]; ok {
+			target = c
+			break
+		}
+	}
+	m.mu.RUnlock()
+	if target == nil {
+		return false
+	}
+	_ = target.CloseWith(websocket.ClosePolicyViolation, reason)
+	return true
+}
+
+// UserConnections 按建连时间返回用户当前的全部连接，不在线时为空。
+func (m *ConnectionManager) UserConnections(userID string) []*Connection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	byID := m.conns[userID]
+	if len(byID) == 0 {
+		return nil
+	}
+	conns := make([]*Connection, 0, len(byID))
+	for _, c := range byID {
+		conns = append(conns, c)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ConnectedAt.Before(conns[j].ConnectedAt) })
+	return conns
 }
 
-// Remove 移除并关闭指定用户的连接。
-func (m *ConnectionManager) Remove(userID string) {
+// Count 返回当前在线连接数，即在线设备数。
+func (m *ConnectionManager) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.total
+}
+
+// Users 返回当前在线的用户数。
+func (m *ConnectionManager) Users() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.conns)
+}
+
+// Remove 关闭指定连接并从表中移除；连接已被替换或移除时只关闭，不影响该用户的其他连接。
+func (m *ConnectionManager) Remove(userID string, conn *Connection) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if conn, ok := m.conns[userID]; ok {
-		_ = conn.Close()
-		delete(m.conns, userID)
+	_ = conn.Close()
+	byID := m.conns[userID]
+	if cur, ok := byID[conn.ID]; ok && cur == conn {
+		delete(byID, conn.ID)
+		m.total--
+		if len(byID) == 0 {
+			delete(m.conns, userID)
+		}
 	}
 }
 
-// Get 返回指定用户的连接实例；若不存在则返回 nil。
-func (m *ConnectionManager) Get(userID string) *websocket.Conn {
+// Get 返回指定用户的全部连接，实现 ConnLookup；不在线时为空。
+func (m *ConnectionManager) Get(userID string) []ConnWriter {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.conns[userID]
+	byID := m.conns[userID]
+	if len(byID) == 0 {
+		return nil
+	}
+	writers := make([]ConnWriter, 0, len(byID))
+	for _, c := range byID {
+		writers = append(writers, c)
+	}
+	return writers
+}
+
+// Snapshot 返回当前全部连接的快照。
+func (m *ConnectionManager) Snapshot() []*Connection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	conns := make([]*Connection, 0, m.total)
+	for _, byID := range m.conns {
+		for _, c := range byID {
+			conns = append(conns, c)
+		}
+	}
+	return conns
 }
 
 // ListIDs 返回当前在线的用户 ID 列表。
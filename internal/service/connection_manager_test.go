@@ -0,0 +1,192 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+func TestConnectionWriteJSONMarksUnkindedPacketsAsPush(t *testing.T) {
+	packets := make(chan model.OutputPacket, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			var p model.OutputPacket
+			if err := ws.ReadJSON(&p); err != nil {
+				return
+			}
+			packets <- p
+		}
+	}))
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn := service.NewConnection("u1", "", "", ws, time.Second, nil)
+	conn.Protocol = service.Protocol{Version: model.ProtocolVersion}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(model.OutputPacket{Cmd: model.CmdChat}); err != nil {
+		t.Fatalf("write push: %v", err)
+	}
+	if err := conn.WriteJSON(model.OutputPacket{Cmd: model.CmdPull, Kind: model.KindResponse, RequestId: "r1"}); err != nil {
+		t.Fatalf("write response: %v", err)
+	}
+	if p := <-packets; p.Kind != model.KindPush {
+		t.Fatalf("expected push kind, got %q", p.Kind)
+	}
+	if p := <-packets; p.Kind != model.KindResponse || p.RequestId != "r1" {
+		t.Fatalf("response kind should be kept, got %+v", p)
+	}
+}
+
+func TestConnectionWriteJSONDependsOnProtocolVersion(t *testing.T) {
+	reply := model.OutputPacket{Cmd: model.CmdChat, Kind: model.KindResponse, RequestId: "r1", MsgId: "m1"}.WithError(model.CodeRateLimited, "slow down")
+
+	v1, v1In := liveConn(t, "u1", "")
+	v2, v2In := liveConn(t, "u1", "")
+	v2.Protocol = service.Protocol{Version: model.ProtocolVersion}
+	for _, c := range []*service.Connection{v1, v2} {
+		if err := c.WriteJSON(reply); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	old, cur := <-v1In, <-v2In
+	if old.Kind != "" || old.RequestId != "" || old.Reason != "" || old.Retryable {
+		t.Fatalf("v1 clients should not receive v2 fields, got %+v", old)
+	}
+	if old.Code != model.CodeRateLimited || old.MsgId != "m1" || old.Payload != "slow down" {
+		t.Fatalf("v1 reply should keep v1 fields, got %+v", old)
+	}
+	if cur.Kind != model.KindResponse || cur.RequestId != "r1" || cur.Reason != "rate_limited" || !cur.Retryable {
+		t.Fatalf("v2 reply should carry kind, request_id and reason, got %+v", cur)
+	}
+}
+
+func TestNegotiateProtocol(t *testing.T) {
+	legacy, err := service.Negotiate(0, nil, model.ProtocolV1)
+	if err != nil || legacy.Version != model.ProtocolV1 || !legacy.Has(model.CapReceipts) {
+		t.Fatalf("clients without handshake should keep v1 behavior, got %+v %v", legacy, err)
+	}
+
+	p, err := service.Negotiate(model.ProtocolVersion+1, []string{model.CapCompression, model.CapBinaryCodec, "unknown"}, model.ProtocolV1)
+	if err != nil || p.Version != model.ProtocolVersion {
+		t.Fatalf("newer clients should be downgraded to server version, got %+v %v", p, err)
+	}
+	if !p.Has(model.CapCompression) || p.Has(model.CapBinaryCodec) || p.Has(model.CapReceipts) {
+		t.Fatalf("capabilities should be the intersection, got %v", p.Capabilities)
+	}
+
+	if _, err := service.Negotiate(0, nil, model.ProtocolVersion); !errors.Is(err, service.ErrUpgradeRequired) {
+		t.Fatalf("expected ErrUpgradeRequired, got %v", err)
+	}
+	if code := service.ErrorCode(service.ErrUpgradeRequired); code != model.CodeUpgradeRequired || code.HTTPStatus() != http.StatusUpgradeRequired {
+		t.Fatalf("unexpected code %d", code)
+	}
+}
+
+// liveConn 建立一条真实的 WebSocket 连接，返回服务端收到的数据包。
+func liveConn(t *testing.T, userID, device string) (*service.Connection, <-chan model.OutputPacket) {
+	t.Helper()
+	packets := make(chan model.OutputPacket, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			var p model.OutputPacket
+			if err := ws.ReadJSON(&p); err != nil {
+				return
+			}
+			packets <- p
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn := service.NewConnection(userID, device, "", ws, time.Second, nil)
+	t.Cleanup(func() { conn.Close() })
+	return conn, packets
+}
+
+func TestConnectionManagerKeepsEveryDeviceOfUser(t *testing.T) {
+	m := service.NewConnectionManager()
+	phone, phoneIn := liveConn(t, "u1", "phone")
+	laptop, laptopIn := liveConn(t, "u1", "laptop")
+	for _, c := range []*service.Connection{phone, laptop} {
+		if err := m.Add("u1", c); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if m.Count() != 2 || m.Users() != 1 || len(m.UserConnections("u1")) != 2 {
+		t.Fatalf("expected 2 connections of 1 user, got %d of %d", m.Count(), m.Users())
+	}
+
+	push := service.NewPushService(m, nil, nil)
+	ctx := context.Background()
+	if err := push.Broadcast(ctx, model.OutputPacket{Cmd: model.CmdChat}, []string{"u1"}); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	for name, in := range map[string]<-chan model.OutputPacket{"phone": phoneIn, "laptop": laptopIn} {
+		select {
+		case p := <-in:
+			if p.Cmd != model.CmdChat {
+				t.Fatalf("%s got unexpected packet %+v", name, p)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s did not receive the push", name)
+		}
+	}
+
+	if err := push.SyncUser(ctx, "u1", model.OutputPacket{Cmd: model.CmdConversationSettings}, phone); err != nil {
+		t.Fatalf("SyncUser: %v", err)
+	}
+	select {
+	case p := <-laptopIn:
+		if p.Cmd != model.CmdConversationSettings {
+			t.Fatalf("laptop got unexpected packet %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("laptop did not receive the sync")
+	}
+	select {
+	case p := <-phoneIn:
+		t.Fatalf("origin connection should be skipped, got %+v", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 同一设备重连替换旧连接，其余设备不受影响
+	phone2, _ := liveConn(t, "u1", "phone")
+	if err := m.Add("u1", phone2); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if m.Count() != 2 {
+		t.Fatalf("reconnect of a device should replace it, got %d connections", m.Count())
+	}
+	m.Remove("u1", phone)
+	m.Remove("u1", laptop)
+	if conns := m.UserConnections("u1"); len(conns) != 1 || conns[0] != phone2 {
+		t.Fatalf("expected only the new phone connection to remain, got %v", conns)
+	}
+}
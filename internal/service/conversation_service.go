@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"go-im/internal/model"
+)
+
+// ErrInvalidSettings 表示会话设置参数非法。
+var ErrInvalidSettings = errors.New("invalid conversation settings")
+
+// ConversationStore 描述会话设置与会话列表需要的仓储能力。
+type ConversationStore interface {
+	GetSettings(ctx context.Context, userID, conversationID string) (model.ConversationSettings, error)
+	UpdateSettings(ctx context.Context, userID, conversationID string, updates map[string]interface{}) error
+	ListConversations(ctx context.Context, userID string) ([]model.ConversationSummary, error)
+	LastSeq(ctx context.Context, conversationID string) (int64, error)
+	SeqHeads(ctx context.Context, conversationIDs []string, limit int) ([]model.SeqHead, error)
+	AdvanceCleared(ctx context.Context, userID, conversationID string, seq int64) error
+	AdvanceDeleted(ctx context.Context, userID, conversationID string, seq int64) error
+}
+
+// SettingsPatch 是一次设置修改，nil 字段表示不修改。
+type SettingsPatch struct {
+	Muted       *bool  `json:"muted,omitempty"`
+	MuteUntil   *int64 `json:"mute_until,omitempty"` // 免打扰截止时间戳(ms)，0 表示永久；单独设置即开启免打扰，与 muted=false 同时设置非法
+	Pinned      *bool  `json:"pinned,omitempty"`
+	PinRank     *int64 `json:"pin_rank,omitempty"` // 置顶时可指定排序值用于手动调整顺序
+	Archived    *bool  `json:"archived,omitempty"`
+	NotifyLevel *int8  `json:"notify_level,omitempty"`
+}
+
+// Empty 表示补丁不修改任何字段。
+func (p SettingsPatch) Empty() bool {
+	return p.Muted == nil && p.MuteUntil == nil && p.Pinned == nil && p.PinRank == nil &&
+		p.Archived == nil && p.NotifyLevel == nil
+}
+
+// columns 将补丁转换为待更新的列。
+func (p SettingsPatch) columns(now time.Time) (map[string]interface{}, error) {
+	updates := make(map[string]interface{})
+	if p.Muted != nil {
+		updates["muted"] = *p.Muted
+		if !*p.Muted {
+			updates["mute_until"] = int64(0)
+		}
+	}
+	if p.MuteUntil != nil {
+		if *p.MuteUntil < 0 || (p.Muted != nil && !*p.Muted) {
+			return nil, ErrInvalidSettings
+		}
+		updates["muted"] = true
+		updates["mute_until"] = *p.MuteUntil
+	}
+	if p.Pinned != nil {
+		rank := int64(0)
+		if *p.Pinned {
+			// 默认按置顶时间排序，最近置顶的在最前
+			rank = now.UnixMilli()
+			if p.PinRank != nil {
+				rank = *p.PinRank
+			}
+			if rank <= 0 {
+				return nil, ErrInvalidSettings
+			}
+		}
+		updates["pin_rank"] = rank
+	} else if p.PinRank != nil {
+		if *p.PinRank < 0 {
+			return nil, ErrInvalidSettings
+		}
+		updates["pin_rank"] = *p.PinRank
+	}
+	if p.Archived != nil {
+		updates["archived"] = *p.Archived
+	}
+	if p.NotifyLevel != nil {
+		if *p.NotifyLevel < model.NotifyAll || *p.NotifyLevel > model.NotifyNone {
+			return nil, ErrInvalidSettings
+		}
+		updates["notify_level"] = *p.NotifyLevel
+	}
+	return updates, nil
+}
+
+// ConversationService 管理用户维度的会话设置与会话列表，设置变更会同步到用户的其他在线设备。
+// 查询与修改单个会话时 userID 须为会话成员，否则返回 ErrNotMember。
+type ConversationService struct {
+	store   ConversationStore
+	members MembershipChecker // 可为 nil，此时不校验是否为会话成员
+	push    *PushService
+}
+
+func NewConversationService(store ConversationStore, members MembershipChecker, push *PushService) *ConversationService {
+	return &ConversationService{store: store, members: members, push: push}
+}
+
+// GetSettings 查询会话设置。
+func (s *ConversationService) GetSettings(ctx context.Context, userID, conversationID string) (model.ConversationSettings, error) {
+	if err := requireMember(ctx, s.members, userID, conversationID); err != nil {
+		return model.ConversationSettings{}, err
+	}
+	return s.store.GetSettings(ctx, userID, conversationID)
+}
+
+// UpdateSettings 应用补丁并返回最新设置；origin 为发起修改的连接（REST 调用传 nil），不会收到同步包。
+func (s *ConversationService) UpdateSettings(ctx context.Context, userID, conversationID string, patch SettingsPatch, origin ConnWriter) (model.ConversationSettings, error) {
+	updates, err := patch.columns(time.Now())
+	if err != nil {
+		return model.ConversationSettings{}, err
+	}
+	if err := requireMember(ctx, s.members, userID, conversationID); err != nil {
+		return model.ConversationSettings{}, err
+	}
+	if len(updates) > 0 {
+		if err := s.store.UpdateSettings(ctx, userID, conversationID, updates); err != nil {
+			return model.ConversationSettings{}, err
+		}
+	}
+	settings, err := s.store.GetSettings(ctx, userID, conversationID)
+	if err != nil {
+		return model.ConversationSettings{}, err
+	}
+	if len(updates) > 0 {
+		s.sync(ctx, userID, settings, origin)
+	}
+	return settings, nil
+}
+
+// ClearHistory 为用户清空会话历史到 seq（含），seq <= 0 或超过最新 seq 时按最新 seq 处理。
+// 只影响该用户自己的视图，返回更新后的状态并同步到其他设备。
+func (s *ConversationService) ClearHistory(ctx context.Context, userID, conversationID string, seq int64, origin ConnWriter) (model.ConversationSettings, error) {
+	if err := requireMember(ctx, s.members, userID, conversationID); err != nil {
+		return model.ConversationSettings{}, err
+	}
+	last, err := s.store.LastSeq(ctx, conversationID)
+	if err != nil {
+		return model.ConversationSettings{}, err
+	}
+	if seq <= 0 || seq > last {
+		seq = last
+	}
+	if err := s.store.AdvanceCleared(ctx, userID, conversationID, seq); err != nil {
+		return model.ConversationSettings{}, err
+	}
+	return s.reload(ctx, userID, conversationID, origin)
+}
+
+// DeleteConversation 把会话从用户的会话列表移除，会话收到新消息后会重新出现。
+// clearHistory 为 true 时同时清空历史。
+func (s *ConversationService) DeleteConversation(ctx context.Context, userID, conversationID string, clearHistory bool, origin ConnWriter) (model.ConversationSettings, error) {
+	if err := requireMember(ctx, s.members, userID, conversationID); err != nil {
+		return model.ConversationSettings{}, err
+	}
+	last, err := s.store.LastSeq(ctx, conversationID)
+	if err != nil {
+		return model.ConversationSettings{}, err
+	}
+	if err := s.store.AdvanceDeleted(ctx, userID, conversationID, last); err != nil {
+		return model.ConversationSettings{}, err
+	}
+	if clearHistory {
+		if err := s.store.AdvanceCleared(ctx, userID, conversationID, last); err != nil {
+			return model.ConversationSettings{}, err
+		}
+	}
+	return s.reload(ctx, userID, conversationID, origin)
+}
+
+// reload 读取最新状态并同步到用户的其他设备。
+func (s *ConversationService) reload(ctx context.Context, userID, conversationID string, origin ConnWriter) (model.ConversationSettings, error) {
+	settings, err := s.store.GetSettings(ctx, userID, conversationID)
+	if err != nil {
+		return model.ConversationSettings{}, err
+	}
+	s.sync(ctx, userID, settings, origin)
+	return settings, nil
+}
+
+func (s *ConversationService) sync(ctx context.Context, userID string, settings model.ConversationSettings, origin ConnWriter) {
+	if s.push == nil {
+		return
+	}
+	packet := model.OutputPacket{Cmd: model.CmdConversationSettings, Code: model.CodeOK, ConversationId: settings.ConversationID, Payload: settings}
+	_ = s.push.SyncUser(ctx, userID, packet, origin)
+}
+
+// ListConversations 返回会话列表：置顶在前（按置顶值降序），其余按最新消息时间降序。
+// 已删除且没有新消息的会话不返回；includeArchived 为 false 时不返回已归档会话。
+func (s *ConversationService) ListConversations(ctx context.Context, userID string, includeArchived bool) ([]model.ConversationSummary, error) {
+	all, err := s.store.ListConversations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	list := all[:0]
+	for _, c := range all {
+		if c.DeletedAt(c.LastSeq) || (c.Archived && !includeArchived) {
+			continue
+		}
+		list = append(list, c)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.PinRank != b.PinRank {
+			return a.PinRank > b.PinRank
+		}
+		if a.LastSendTime != b.LastSendTime {
+			return a.LastSendTime > b.LastSendTime
+		}
+		return a.ConversationID < b.ConversationID
+	})
+	return list, nil
+}
+
+// maxSeqHeads 为单次查询会话 seq 头的条数上限。
+const maxSeqHeads = 1000
+
+// SeqHeads 返回各会话当前最大的 seq，conversationIDs 为空时按会话 ID 升序返回前 limit 个会话。
+func (s *ConversationService) SeqHeads(ctx context.Context, conversationIDs []string, limit int) ([]model.SeqHead, error) {
+	if limit <= 0 || limit > maxSeqHeads {
+		limit = maxSeqHeads
+	}
+	return s.store.SeqHeads(ctx, conversationIDs, limit)
+}
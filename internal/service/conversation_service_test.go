@@ -0,0 +1,218 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+type memConversationStore struct {
+	states  map[string]model.UserConversationState
+	list    []model.ConversationSummary
+	lastSeq map[string]int64
+}
+
+func (m *memConversationStore) GetSettings(ctx context.Context, userID, conversationID string) (model.ConversationSettings, error) {
+	state, ok := m.states[userID+"/"+conversationID]
+	if !ok {
+		return model.ConversationSettings{ConversationID: conversationID}, nil
+	}
+	return state.Settings(), nil
+}
+
+func (m *memConversationStore) UpdateSettings(ctx context.Context, userID, conversationID string, updates map[string]interface{}) error {
+	key := userID + "/" + conversationID
+	state := m.states[key]
+	state.UserID, state.ConversationID = userID, conversationID
+	for col, v := range updates {
+		switch col {
+		case "muted":
+			state.Muted = v.(bool)
+		case "mute_until":
+			state.MuteUntil = v.(int64)
+		case "pin_rank":
+			state.PinRank = v.(int64)
+		case "archived":
+			state.Archived = v.(bool)
+		case "notify_level":
+			state.NotifyLevel = v.(int8)
+		}
+	}
+	m.states[key] = state
+	return nil
+}
+
+func (m *memConversationStore) ListConversations(ctx context.Context, userID string) ([]model.ConversationSummary, error) {
+	return append([]model.ConversationSummary(nil), m.list...), nil
+}
+
+func (m *memConversationStore) LastSeq(ctx context.Context, conversationID string) (int64, error) {
+	return m.lastSeq[conversationID], nil
+}
+
+func (m *memConversationStore) SeqHeads(ctx context.Context, conversationIDs []string, limit int) ([]model.SeqHead, error) {
+	var heads []model.SeqHead
+	for _, id := range conversationIDs {
+		heads = append(heads, model.SeqHead{ConversationID: id, Seq: m.lastSeq[id]})
+	}
+	return heads, nil
+}
+
+func (m *memConversationStore) AdvanceCleared(ctx context.Context, userID, conversationID string, seq int64) error {
+	key := userID + "/" + conversationID
+	state := m.states[key]
+	state.UserID, state.ConversationID = userID, conversationID
+	if seq > state.ClearedSeq {
+		state.ClearedSeq = seq
+	}
+	m.states[key] = state
+	return nil
+}
+
+func (m *memConversationStore) AdvanceDeleted(ctx context.Context, userID, conversationID string, seq int64) error {
+	key := userID + "/" + conversationID
+	state := m.states[key]
+	state.UserID, state.ConversationID = userID, conversationID
+	if seq > state.DeletedSeq {
+		state.DeletedSeq = seq
+	}
+	m.states[key] = state
+	return nil
+}
+
+func boolPtr(v bool) *bool { return &v }
+
+func TestUpdateSettingsSyncsOtherDevice(t *testing.T) {
+	store := &memConversationStore{states: map[string]model.UserConversationState{}}
+	lookup := &stubConnLookup{conns: map[string]*stubConn{"u1": {}}}
+	svc := service.NewConversationService(store, nil, service.NewPushService(lookup, nil, nil))
+
+	settings, err := svc.UpdateSettings(context.Background(), "u1", "g1", service.SettingsPatch{Pinned: boolPtr(true), Muted: boolPtr(true)}, nil)
+	if err != nil {
+		t.Fatalf("UpdateSettings error: %v", err)
+	}
+	if !settings.Pinned() || !settings.Muted {
+		t.Fatalf("expected pinned and muted, got %+v", settings)
+	}
+	writes := lookup.conns["u1"].writes
+	if len(writes) != 1 {
+		t.Fatalf("expected one sync packet, got %d", len(writes))
+	}
+	if pkt := writes[0].(model.OutputPacket); pkt.Cmd != model.CmdConversationSettings || pkt.ConversationId != "g1" {
+		t.Fatalf("unexpected sync packet: %#v", pkt)
+	}
+
+	// 发起修改的连接不重复收到同步包
+	if _, err := svc.UpdateSettings(context.Background(), "u1", "g1", service.SettingsPatch{Archived: boolPtr(true)}, lookup.conns["u1"]); err != nil {
+		t.Fatalf("UpdateSettings error: %v", err)
+	}
+	if len(lookup.conns["u1"].writes) != 1 {
+		t.Fatalf("origin connection should not receive sync packet")
+	}
+}
+
+func TestUpdateSettingsRejectsInvalidLevel(t *testing.T) {
+	store := &memConversationStore{states: map[string]model.UserConversationState{}}
+	svc := service.NewConversationService(store, nil, nil)
+	level := int8(9)
+
+	_, err := svc.UpdateSettings(context.Background(), "u1", "g1", service.SettingsPatch{NotifyLevel: &level}, nil)
+	if !errors.Is(err, service.ErrInvalidSettings) {
+		t.Fatalf("expected ErrInvalidSettings, got %v", err)
+	}
+}
+
+func TestMuteUntilAloneTurnsMuteOn(t *testing.T) {
+	store := &memConversationStore{states: map[string]model.UserConversationState{}}
+	svc := service.NewConversationService(store, nil, nil)
+	until := time.Now().Add(time.Hour).UnixMilli()
+
+	settings, err := svc.UpdateSettings(context.Background(), "u1", "g1", service.SettingsPatch{MuteUntil: &until}, nil)
+	if err != nil {
+		t.Fatalf("UpdateSettings error: %v", err)
+	}
+	if !settings.Muted || settings.MuteUntil != until || !settings.MutedAt(time.Now()) {
+		t.Fatalf("mute_until alone should mute the conversation, got %+v", settings)
+	}
+
+	_, err = svc.UpdateSettings(context.Background(), "u1", "g1", service.SettingsPatch{Muted: boolPtr(false), MuteUntil: &until}, nil)
+	if !errors.Is(err, service.ErrInvalidSettings) {
+		t.Fatalf("muted=false with mute_until should be rejected, got %v", err)
+	}
+}
+
+func TestListConversationsOrdersPinnedAndHidesArchived(t *testing.T) {
+	store := &memConversationStore{list: []model.ConversationSummary{
+		{ConversationSettings: model.ConversationSettings{ConversationID: "old"}, LastSendTime: 100},
+		{ConversationSettings: model.ConversationSettings{ConversationID: "new"}, LastSendTime: 300},
+		{ConversationSettings: model.ConversationSettings{ConversationID: "pinned", PinRank: 5}, LastSendTime: 50},
+		{ConversationSettings: model.ConversationSettings{ConversationID: "archived", Archived: true}, LastSendTime: 400},
+	}}
+	svc := service.NewConversationService(store, nil, nil)
+
+	list, err := svc.ListConversations(context.Background(), "u1", false)
+	if err != nil {
+		t.Fatalf("ListConversations error: %v", err)
+	}
+	var ids []string
+	for _, c := range list {
+		ids = append(ids, c.ConversationID)
+	}
+	want := []string{"pinned", "new", "old"}
+	if len(ids) != len(want) {
+		t.Fatalf("expected %v, got %v", want, ids)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, ids)
+		}
+	}
+
+	withArchived, _ := svc.ListConversations(context.Background(), "u1", true)
+	if len(withArchived) != 4 {
+		t.Fatalf("expected archived conversation when requested, got %d", len(withArchived))
+	}
+}
+
+func TestClearHistoryClampsToLastSeqAndSyncs(t *testing.T) {
+	store := &memConversationStore{states: map[string]model.UserConversationState{}, lastSeq: map[string]int64{"g1": 8}}
+	lookup := &stubConnLookup{conns: map[string]*stubConn{"u1": {}}}
+	svc := service.NewConversationService(store, nil, service.NewPushService(lookup, nil, nil))
+
+	settings, err := svc.ClearHistory(context.Background(), "u1", "g1", 100, nil)
+	if err != nil {
+		t.Fatalf("ClearHistory error: %v", err)
+	}
+	if settings.ClearedSeq != 8 {
+		t.Fatalf("expected cleared_seq clamped to 8, got %d", settings.ClearedSeq)
+	}
+	if len(lookup.conns["u1"].writes) != 1 {
+		t.Fatalf("expected clear to sync to other device")
+	}
+}
+
+func TestDeletedConversationReappearsOnNewMessage(t *testing.T) {
+	store := &memConversationStore{states: map[string]model.UserConversationState{}, lastSeq: map[string]int64{"g1": 3}}
+	svc := service.NewConversationService(store, nil, nil)
+
+	settings, err := svc.DeleteConversation(context.Background(), "u1", "g1", false, nil)
+	if err != nil {
+		t.Fatalf("DeleteConversation error: %v", err)
+	}
+	if settings.DeletedSeq != 3 || settings.ClearedSeq != 0 {
+		t.Fatalf("unexpected state after delete: %+v", settings)
+	}
+
+	store.list = []model.ConversationSummary{{ConversationSettings: settings, LastSeq: 3}}
+	if list, _ := svc.ListConversations(context.Background(), "u1", true); len(list) != 0 {
+		t.Fatalf("deleted conversation should be hidden, got %d", len(list))
+	}
+	store.list[0].LastSeq = 4
+	if list, _ := svc.ListConversations(context.Background(), "u1", true); len(list) != 1 {
+		t.Fatalf("deleted conversation should reappear after new message")
+	}
+}
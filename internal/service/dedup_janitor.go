@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// dedupPurgeInterval 为清理过期去重记录的周期。
+const dedupPurgeInterval = 10 * time.Minute
+
+// DedupPurger 删除过期的 msg_id 去重记录。
+type DedupPurger interface {
+	PurgeDedup(ctx context.Context, before time.Time) (int64, error)
+}
+
+// DedupJanitor 定期删除超过去重窗口的记录，使去重索引的大小只与窗口内的消息量有关。
+// 记录在窗口到期后最多再保留一个清理周期，窗口内的重发一定能识别。
+type DedupJanitor struct {
+	store  DedupPurger
+	window time.Duration
+	logger *slog.Logger
+}
+
+// NewDedupJanitor 创建清理任务，logger 为 nil 时使用 slog.Default()。
+func NewDedupJanitor(store DedupPurger, window time.Duration, logger *slog.Logger) *DedupJanitor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &DedupJanitor{store: store, window: window, logger: logger}
+}
+
+// Purge 删除 now 之前超过窗口的记录。
+func (j *DedupJanitor) Purge(ctx context.Context, now time.Time) (int64, error) {
+	return j.store.PurgeDedup(ctx, now.Add(-j.window))
+}
+
+// Run 启动时清理一次，之后定期清理，直到 ctx 取消。
+func (j *DedupJanitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(dedupPurgeInterval)
+	defer ticker.Stop()
+	for {
+		n, err := j.Purge(ctx, time.Now())
+		switch {
+		case err != nil && ctx.Err() == nil:
+			j.logger.Warn("清理过期去重记录失败", "err", err)
+		case n > 0:
+			j.logger.Info("已清理过期去重记录", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
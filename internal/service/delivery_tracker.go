@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-im/internal/model"
+	"go-im/internal/tracing"
+)
+
+// DeliveryStore 持久化用户在会话的送达位点。
+type DeliveryStore interface {
+	UpsertDelivered(ctx context.Context, userID, conversationID string, seq int64) error
+}
+
+type pushKey struct {
+	conversationID string
+	seq            int64
+}
+
+type pendingPush struct {
+	userID   string
+	packet   model.OutputPacket
+	deadline time.Time
+	attempts int               // 已重投次数，不含首次推送
+	span     trace.SpanContext // 首次推送所在链路，重投的 span 挂在它下面
+}
+
+// DeliveryTracker 按连接跟踪已推送但尚未收到送达回执的消息。
+// 回执超时后重投，超过 maxRetries 次仍未确认则放弃重投，
+// 改为发送 CmdSyncRequired 提示客户端主动拉取。
+type DeliveryTracker struct {
+	mu         sync.Mutex
+	pending    map[ConnWriter]map[pushKey]*pendingPush
+	pushed     map[ConnWriter]map[string]int64 // 每个连接上各会话推送过的最大 seq，回执不能超过它
+	store      DeliveryStore
+	ackTimeout time.Duration
+	maxRetries int
+}
+
+func NewDeliveryTracker(store DeliveryStore, ackTimeout time.Duration, maxRetries int) *DeliveryTracker {
+	return &DeliveryTracker{
+		pending:    make(map[ConnWriter]map[pushKey]*pendingPush),
+		pushed:     make(map[ConnWriter]map[string]int64),
+		store:      store,
+		ackTimeout: ackTimeout,
+		maxRetries: maxRetries,
+	}
+}
+
+// Track 记录一次即将写入连接的推送，等待客户端回执。调用方需在写入前登记，
+// 否则回执可能先于登记到达而被忽略；写入失败时调用 Untrack。ctx 中的链路随推送保存，重投时沿用。
+func (t *DeliveryTracker) Track(ctx context.Context, userID string, conn ConnWriter, packet model.OutputPacket) {
+	if packet.ConversationId == "" || packet.Seq <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	byKey, ok := t.pending[conn]
+	if !ok {
+		byKey = make(map[pushKey]*pendingPush)
+		t.pending[conn] = byKey
+	}
+	tops, ok := t.pushed[conn]
+	if !ok {
+		tops = make(map[string]int64)
+		t.pushed[conn] = tops
+	}
+	tops[packet.ConversationId] = max(tops[packet.ConversationId], packet.Seq)
+	byKey[pushKey{packet.ConversationId, packet.Seq}] = &pendingPush{
+		userID:   userID,
+		packet:   packet,
+		deadline: time.Now().Add(t.ackTimeout),
+		span:     trace.SpanContextFromContext(ctx),
+	}
+}
+
+// Untrack 撤销 Track 登记的一条推送，用于写入失败的情况。
+func (t *DeliveryTracker) Untrack(conn ConnWriter, packet model.OutputPacket) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if byKey, ok := t.pending[conn]; ok {
+		delete(byKey, pushKey{packet.ConversationId, packet.Seq})
+		if len(byKey) == 0 {
+			delete(t.pending, conn)
+		}
+	}
+}
+
+// Ack 处理客户端送达回执。回执是累积的：确认 seq 表示该会话内 <= seq 的推送均已收到，
+// 客户端应回执连续收到的最大 seq。送达位点写入存储，只前进不回退。
+// 回执只对本连接推送过的会话有效，seq 截断到推送过的最大 seq：推送只发给会话成员，
+// 因此非成员的回执被忽略，客户端也无法把位点推进到尚未产生的消息。
+func (t *DeliveryTracker) Ack(ctx context.Context, userID string, conn ConnWriter, conversationID string, seq int64) error {
+	t.mu.Lock()
+	top, ok := t.pushed[conn][conversationID]
+	if !ok {
+		t.mu.Unlock()
+		return nil
+	}
+	seq = min(seq, top)
+	if byKey, ok := t.pending[conn]; ok {
+		for key := range byKey {
+			if key.conversationID == conversationID && key.seq <= seq {
+				delete(byKey, key)
+			}
+		}
+		if len(byKey) == 0 {
+			delete(t.pending, conn)
+		}
+	}
+	t.mu.Unlock()
+
+	if t.store == nil {
+		return nil
+	}
+	return t.store.UpsertDelivered(ctx, userID, conversationID, seq)
+}
+
+// Forget 丢弃连接上所有待确认推送与推送记录，连接关闭时调用。
+func (t *DeliveryTracker) Forget(conn ConnWriter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pending, conn)
+	delete(t.pushed, conn)
+}
+
+// Pending 返回连接上待确认的推送数量。
+func (t *DeliveryTracker) Pending(conn ConnWriter) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending[conn])
+}
+
+// PendingTotal 返回所有连接上待确认的推送总数。
+func (t *DeliveryTracker) PendingTotal() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, byKey := range t.pending {
+		n += len(byKey)
+	}
+	return n
+}
+
+type redelivery struct {
+	conn    ConnWriter
+	packet  model.OutputPacket
+	attempt int
+	parent  trace.SpanContext
+}
+
+// Sweep 处理 now 时刻已超时的推送：未达上限的重投，达到上限的合并为每个会话一条同步提示。
+// 写操作在锁外执行，写失败的连接视为已断开并被丢弃。
+func (t *DeliveryTracker) Sweep(now time.Time) {
+	var resend []redelivery
+	syncHints := make(map[ConnWriter]map[string]int64)
+
+	t.mu.Lock()
+	for conn, byKey := range t.pending {
+		for key, p := range byKey {
+			if now.Before(p.deadline) {
+				continue
+			}
+			if p.attempts >= t.maxRetries {
+				delete(byKey, key)
+				hints, ok := syncHints[conn]
+				if !ok {
+					hints = make(map[string]int64)
+					syncHints[conn] = hints
+				}
+				if first, ok := hints[key.conversationID]; !ok || key.seq < first {
+					hints[key.conversationID] = key.seq
+				}
+				continue
+			}
+			p.attempts++
+			p.deadline = now.Add(t.ackTimeout)
+			resend = append(resend, redelivery{conn: conn, packet: p.packet, attempt: p.attempts, parent: p.span})
+		}
+		if len(byKey) == 0 {
+			delete(t.pending, conn)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, r := range resend {
+		if err := t.redeliver(r); err != nil {
+			t.Forget(r.conn)
+		}
+	}
+	for conn, hints := range syncHints {
+		for conversationID, firstSeq := range hints {
+			hint := model.OutputPacket{Cmd: model.CmdSyncRequired, Code: model.CodeOK, ConversationId: conversationID, Seq: firstSeq}
+			if err := conn.WriteJSON(hint); err != nil {
+				t.Forget(conn)
+				break
+			}
+		}
+	}
+}
+
+// redeliver 重投一条推送，span 挂在首次推送的链路下，便于从发送端追到重投。
+func (t *DeliveryTracker) redeliver(r redelivery) error {
+	ctx := trace.ContextWithSpanContext(context.Background(), r.parent)
+	_, span := tracer.Start(ctx, "DeliveryTracker.redeliver", trace.WithAttributes(
+		attribute.String("im.conversation_id", r.packet.ConversationId),
+		attribute.Int64("im.seq", r.packet.Seq),
+		attribute.Int("im.attempt", r.attempt),
+	))
+	defer span.End()
+	err := r.conn.WriteJSON(r.packet)
+	tracing.RecordError(span, err)
+	return err
+}
+
+// Run 周期性执行 Sweep，直到 ctx 结束。
+func (t *DeliveryTracker) Run(ctx context.Context) {
+	interval := t.ackTimeout / 2
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.Sweep(now)
+		}
+	}
+}
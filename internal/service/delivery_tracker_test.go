@@ -0,0 +1,169 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+type deliveredCall struct {
+	userID, conversationID string
+	seq                    int64
+}
+
+type stubDeliveryStore struct {
+	calls []deliveredCall
+}
+
+func (s *stubDeliveryStore) UpsertDelivered(ctx context.Context, userID, conversationID string, seq int64) error {
+	s.calls = append(s.calls, deliveredCall{userID, conversationID, seq})
+	return nil
+}
+
+func pushPacket(conv string, seq int64) model.OutputPacket {
+	return model.OutputPacket{Cmd: model.CmdChat, ConversationId: conv, Seq: seq, MsgId: "m"}
+}
+
+func TestBroadcastTracksSuccessfulWrites(t *testing.T) {
+	lookup := &stubConnLookup{conns: map[string]*stubConn{"u1": {}}}
+	tracker := service.NewDeliveryTracker(nil, time.Second, 1)
+	push := service.NewPushService(lookup, tracker, nil)
+
+	if err := push.Broadcast(context.Background(), pushPacket("c1", 1), []string{"u1", "absent"}); err != nil {
+		t.Fatalf("Broadcast returned error: %v", err)
+	}
+	if got := tracker.Pending(lookup.conns["u1"]); got != 1 {
+		t.Fatalf("expected 1 pending push, got %d", got)
+	}
+}
+
+// ackingConn 在写入推送的同时回执，模拟回执先于 WriteJSON 返回到达的客户端。
+type ackingConn struct {
+	tracker *service.DeliveryTracker
+	err     error
+}
+
+func (c *ackingConn) WriteJSON(v interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	p := v.(model.OutputPacket)
+	return c.tracker.Ack(context.Background(), "u1", c, p.ConversationId, p.Seq)
+}
+
+type ackingLookup struct{ conn *ackingConn }
+
+func (l ackingLookup) Get(userID string) []service.ConnWriter {
+	return []service.ConnWriter{l.conn}
+}
+
+func TestBroadcastTracksBeforeWrite(t *testing.T) {
+	tracker := service.NewDeliveryTracker(nil, time.Second, 1)
+	conn := &ackingConn{tracker: tracker}
+	push := service.NewPushService(ackingLookup{conn}, tracker, nil)
+
+	if err := push.Broadcast(context.Background(), pushPacket("c1", 1), []string{"u1"}); err != nil {
+		t.Fatalf("Broadcast returned error: %v", err)
+	}
+	if got := tracker.Pending(conn); got != 0 {
+		t.Fatalf("ack racing the write should clear the push, got %d pending", got)
+	}
+
+	conn.err = errors.New("broken pipe")
+	if err := push.Broadcast(context.Background(), pushPacket("c1", 2), []string{"u1"}); err == nil {
+		t.Fatalf("expected write error")
+	}
+	if got := tracker.Pending(conn); got != 0 {
+		t.Fatalf("failed write should not stay pending, got %d", got)
+	}
+}
+
+func TestDeliveryAckIsCumulativeAndPersisted(t *testing.T) {
+	store := &stubDeliveryStore{}
+	tracker := service.NewDeliveryTracker(store, time.Second, 1)
+	conn := &stubConn{}
+	tracker.Track(context.Background(), "u1", conn, pushPacket("c1", 1))
+	tracker.Track(context.Background(), "u1", conn, pushPacket("c1", 2))
+	tracker.Track(context.Background(), "u1", conn, pushPacket("c1", 3))
+	tracker.Track(context.Background(), "u1", conn, pushPacket("c2", 1))
+
+	if err := tracker.Ack(context.Background(), "u1", conn, "c1", 2); err != nil {
+		t.Fatalf("Ack error: %v", err)
+	}
+	if got := tracker.Pending(conn); got != 2 {
+		t.Fatalf("expected c1#3 and c2#1 pending, got %d", got)
+	}
+	if len(store.calls) != 1 || store.calls[0] != (deliveredCall{"u1", "c1", 2}) {
+		t.Fatalf("unexpected delivered calls: %+v", store.calls)
+	}
+}
+
+func TestDeliveryAckIsBoundedByPushes(t *testing.T) {
+	store := &stubDeliveryStore{}
+	tracker := service.NewDeliveryTracker(store, time.Second, 1)
+	conn, other := &stubConn{}, &stubConn{}
+	tracker.Track(context.Background(), "u1", conn, pushPacket("c1", 3))
+
+	if err := tracker.Ack(context.Background(), "u1", conn, "c1", 1<<62); err != nil {
+		t.Fatalf("Ack error: %v", err)
+	}
+	if err := tracker.Ack(context.Background(), "u1", conn, "never-pushed", 5); err != nil {
+		t.Fatalf("Ack error: %v", err)
+	}
+	if err := tracker.Ack(context.Background(), "u2", other, "c1", 5); err != nil {
+		t.Fatalf("Ack error: %v", err)
+	}
+	if len(store.calls) != 1 || store.calls[0] != (deliveredCall{"u1", "c1", 3}) {
+		t.Fatalf("ack should be clamped to the pushed seq and ignored elsewhere, got %+v", store.calls)
+	}
+}
+
+func TestSweepRedeliversThenSendsSyncHint(t *testing.T) {
+	tracker := service.NewDeliveryTracker(nil, time.Second, 2)
+	conn := &stubConn{}
+	tracker.Track(context.Background(), "u1", conn, pushPacket("c1", 4))
+	tracker.Track(context.Background(), "u1", conn, pushPacket("c1", 5))
+
+	now := time.Now()
+	// 未超时不重投
+	tracker.Sweep(now)
+	if len(conn.writes) != 0 {
+		t.Fatalf("expected no redelivery before timeout, got %d writes", len(conn.writes))
+	}
+
+	// 两次重投
+	tracker.Sweep(now.Add(2 * time.Second))
+	tracker.Sweep(now.Add(4 * time.Second))
+	if len(conn.writes) != 4 {
+		t.Fatalf("expected 4 redeliveries, got %d", len(conn.writes))
+	}
+
+	// 超过上限：放弃跟踪，只发一条同步提示
+	tracker.Sweep(now.Add(6 * time.Second))
+	if len(conn.writes) != 5 {
+		t.Fatalf("expected one sync hint after retries exhausted, got %d writes", len(conn.writes))
+	}
+	hint, ok := conn.writes[4].(model.OutputPacket)
+	if !ok || hint.Cmd != model.CmdSyncRequired || hint.ConversationId != "c1" || hint.Seq != 4 {
+		t.Fatalf("unexpected sync hint: %#v", conn.writes[4])
+	}
+	if got := tracker.Pending(conn); got != 0 {
+		t.Fatalf("expected nothing pending after giving up, got %d", got)
+	}
+}
+
+func TestForgetDropsPendingPushes(t *testing.T) {
+	tracker := service.NewDeliveryTracker(nil, time.Second, 3)
+	conn := &stubConn{}
+	tracker.Track(context.Background(), "u1", conn, pushPacket("c1", 1))
+	tracker.Forget(conn)
+
+	tracker.Sweep(time.Now().Add(time.Minute))
+	if len(conn.writes) != 0 {
+		t.Fatalf("forgotten connection should not be written, got %d writes", len(conn.writes))
+	}
+}
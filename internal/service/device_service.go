@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"errors"
+)
+
+// 支持的设备平台；APNs/FCM 适配器接入前，令牌只做登记。
+const (
+	PlatformAPNs    = "apns"
+	PlatformFCM     = "fcm"
+	PlatformWebhook = "webhook"
+)
+
+// ErrUnsupportedPlatform 表示设备平台不在支持列表中。
+var ErrUnsupportedPlatform = errors.New("unsupported platform")
+
+// DeviceRegistry 描述设备令牌登记需要的仓储能力。
+type DeviceRegistry interface {
+	RegisterDevice(ctx context.Context, userID, platform, token string) error
+	UnregisterDevice(ctx context.Context, userID, token string) error
+}
+
+// DeviceService 处理设备令牌登记。
+type DeviceService struct {
+	devices DeviceRegistry
+}
+
+func NewDeviceService(devices DeviceRegistry) *DeviceService {
+	return &DeviceService{devices: devices}
+}
+
+// Register 登记设备令牌，平台需为 apns / fcm / webhook 之一。
+func (s *DeviceService) Register(ctx context.Context, userID, platform, token string) error {
+	switch platform {
+	case PlatformAPNs, PlatformFCM, PlatformWebhook:
+	default:
+		return ErrUnsupportedPlatform
+	}
+	return s.devices.RegisterDevice(ctx, userID, platform, token)
+}
+
+// Unregister 注销设备令牌，例如用户退出登录时。
+func (s *DeviceService) Unregister(ctx context.Context, userID, token string) error {
+	return s.devices.UnregisterDevice(ctx, userID, token)
+}
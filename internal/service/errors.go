@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"go-im/internal/model"
+	"go-im/internal/repository"
+)
+
+// ErrorCode 将业务错误映射为错误码目录中的错误码，未识别的错误视为内部错误。
+func ErrorCode(err error) model.ErrorCode {
+	switch {
+	case err == nil:
+		return model.CodeOK
+	case errors.Is(err, ErrInvalidSettings), errors.Is(err, ErrUnsupportedPlatform), errors.Is(err, ErrInvalidMessageRef):
+		return model.CodeBadRequest
+	case errors.Is(err, ErrNotMember):
+		return model.CodeNotMember
+	case errors.Is(err, ErrTooManyMessages), errors.Is(err, ErrTooManyConversations), errors.Is(err, ErrTooManyAcks):
+		return model.CodeTooLarge
+	case errors.Is(err, ErrUpgradeRequired):
+		return model.CodeUpgradeRequired
+	case errors.Is(err, repository.ErrDuplicateMsgID):
+		return model.CodeDuplicate
+	case errors.Is(err, ErrTooManyConnections), errors.Is(err, ErrWriterClosed), errors.Is(err, context.DeadlineExceeded):
+		return model.CodeUnavailable
+	default:
+		return model.CodeInternal
+	}
+}
@@ -0,0 +1,26 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+
+	"go-im/internal/model"
+)
+
+func TestErrorCodesMatchHTTPStatus(t *testing.T) {
+	codes := []model.ErrorCode{
+		model.CodeBadRequest, model.CodeUnauthorized, model.CodeForbidden, model.CodeNotFound, model.CodeDuplicate,
+		model.CodeTooLarge, model.CodeMuted, model.CodeUpgradeRequired, model.CodeRateLimited, model.CodeUnavailable,
+	}
+	for _, code := range codes {
+		if code.HTTPStatus() != int(code) {
+			t.Errorf("code %d (%s) maps to HTTP %d", code, code.Reason(), code.HTTPStatus())
+		}
+	}
+}
+
+func TestNotMemberHasItsOwnReasonAndStatus(t *testing.T) {
+	if model.CodeNotMember.Reason() != "not_member" || model.CodeNotMember.HTTPStatus() != http.StatusForbidden {
+		t.Fatalf("unexpected not_member entry: %q, HTTP %d", model.CodeNotMember.Reason(), model.CodeNotMember.HTTPStatus())
+	}
+}
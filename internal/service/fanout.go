@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-im/internal/model"
+	"go-im/internal/tracing"
+)
+
+// MemberLookup 提供会话成员列表，用于推送扇出。
+type MemberLookup interface {
+	ListMembers(ctx context.Context, conversationID string) ([]string, error)
+}
+
+// Fanout 将新写入的消息推送给会话内的在线成员与发送者的其他设备，实现 MessagePublisher。
+type Fanout struct {
+	members MemberLookup
+	push    *PushService
+}
+
+func NewFanout(members MemberLookup, push *PushService) *Fanout {
+	return &Fanout{members: members, push: push}
+}
+
+// Publish 查询会话成员并广播推送包，发送者只跳过发出消息的 origin 连接。
+func (f *Fanout) Publish(ctx context.Context, msg *model.TimelineMessage, origin ConnWriter) (err error) {
+	ctx, span := tracer.Start(ctx, "Fanout.Publish", trace.WithAttributes(attribute.String("im.conversation_id", msg.ConversationID)))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	members, err := f.members.ListMembers(ctx, msg.ConversationID)
+	if err != nil {
+		return err
+	}
+	targets := make([]string, 0, len(members))
+	senderIsMember := false
+	for _, m := range members {
+		if m == msg.SenderID {
+			senderIsMember = true
+			continue
+		}
+		targets = append(targets, m)
+	}
+	span.SetAttributes(attribute.Int("im.targets", len(targets)))
+	packet := model.OutputPacket{
+		Cmd:            model.CmdChat,
+		Code:           model.CodeOK,
+		MsgId:          msg.MsgID,
+		ConversationId: msg.ConversationID,
+		Seq:            int64(msg.Seq),
+		Payload:        model.NewChatMessage(msg),
+	}
+	if len(targets) > 0 {
+		err = f.push.Broadcast(ctx, packet, targets)
+	}
+	// 发送者的其他设备同样需要这条消息，发出消息的连接已经收到回包
+	if senderIsMember {
+		if ownErr := f.push.PushOtherDevices(ctx, msg.SenderID, packet, origin); ownErr != nil && err == nil {
+			err = ownErr
+		}
+	}
+	return err
+}
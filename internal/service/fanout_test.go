@@ -0,0 +1,75 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+type stubMembers map[string][]string
+
+func (m stubMembers) ListMembers(ctx context.Context, conversationID string) ([]string, error) {
+	return m[conversationID], nil
+}
+
+// recordingOffline 记录转为离线通知的用户。
+type recordingOffline struct {
+	users []string
+}
+
+func (r *recordingOffline) Notify(ctx context.Context, userID string, packet model.OutputPacket) {
+	r.users = append(r.users, userID)
+}
+
+// deviceLookup 为每个用户返回多条连接，模拟多端在线。
+type deviceLookup map[string][]*stubConn
+
+func (l deviceLookup) Get(userID string) []service.ConnWriter {
+	var conns []service.ConnWriter
+	for _, c := range l[userID] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func TestFanoutSkipsOnlyTheOriginConnection(t *testing.T) {
+	origin, otherDevice, peer := &stubConn{}, &stubConn{}, &stubConn{}
+	lookup := deviceLookup{"u1": {origin, otherDevice}, "u2": {peer}}
+	fanout := service.NewFanout(stubMembers{"g1": {"u1", "u2", "u3"}}, service.NewPushService(lookup, nil, nil))
+	msg := &model.TimelineMessage{MsgID: "m1", ConversationID: "g1", Seq: 7, SenderID: "u1"}
+
+	if err := fanout.Publish(context.Background(), msg, origin); err != nil {
+		t.Fatalf("Publish returned error: %v", err)
+	}
+	if len(origin.writes) != 0 {
+		t.Fatalf("the sending connection should not receive its own push")
+	}
+	if len(otherDevice.writes) != 1 {
+		t.Fatalf("the sender's other device should receive the push, got %d", len(otherDevice.writes))
+	}
+	if len(peer.writes) != 1 {
+		t.Fatalf("expected u2 to receive push")
+	}
+	got := peer.writes[0].(model.OutputPacket)
+	if got.ConversationId != "g1" || got.Seq != 7 || got.MsgId != "m1" {
+		t.Fatalf("unexpected push packet: %#v", got)
+	}
+	if payload, ok := got.Payload.(model.ChatMessage); !ok || payload.Seq != 7 || payload.SenderId != "u1" {
+		t.Fatalf("push payload should be a ChatMessage, got %#v", got.Payload)
+	}
+}
+
+func TestFanoutDoesNotNotifySenderOffline(t *testing.T) {
+	offline := &recordingOffline{}
+	fanout := service.NewFanout(stubMembers{"g1": {"u1", "u2"}}, service.NewPushService(deviceLookup{}, nil, offline))
+	msg := &model.TimelineMessage{MsgID: "m1", ConversationID: "g1", Seq: 1, SenderID: "u1"}
+
+	if err := fanout.Publish(context.Background(), msg, nil); err != nil {
+		t.Fatalf("Publish returned error: %v", err)
+	}
+	if len(offline.users) != 1 || offline.users[0] != "u2" {
+		t.Fatalf("only the other member should be notified offline, got %v", offline.users)
+	}
+}
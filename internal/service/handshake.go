@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+	"slices"
+
+	"go-im/internal/model"
+)
+
+// ErrUpgradeRequired 表示客户端协议版本低于服务端接受的最低版本。
+var ErrUpgradeRequired = errors.New("protocol version too old")
+
+// ServerCapabilities 为服务端支持的能力，握手时与客户端声明的能力取交集。
+var ServerCapabilities = []string{model.CapCompression, model.CapBatchPull, model.CapReceipts}
+
+// legacyCapabilities 为未握手的旧客户端默认具备的能力，保持握手引入前的行为。
+var legacyCapabilities = []string{model.CapReceipts}
+
+// Protocol 是一条连接协商后的协议版本与能力。
+type Protocol struct {
+	Version      int
+	Capabilities []string
+}
+
+// Has 判断连接是否协商了能力 capability。
+func (p Protocol) Has(capability string) bool {
+	return slices.Contains(p.Capabilities, capability)
+}
+
+// Negotiate 根据客户端声明的协议版本与能力协商连接协议。version 为 0 表示客户端未握手，按 v1 旧客户端处理；
+// 高于服务端的版本降为服务端版本，低于 minVersion 时返回 ErrUpgradeRequired。
+func Negotiate(version int, capabilities []string, minVersion int) (Protocol, error) {
+	if version == 0 {
+		version, capabilities = model.ProtocolV1, legacyCapabilities
+	}
+	if version < minVersion {
+		return Protocol{Version: version}, ErrUpgradeRequired
+	}
+	p := Protocol{Version: min(version, model.ProtocolVersion), Capabilities: []string{}}
+	for _, c := range ServerCapabilities {
+		if slices.Contains(capabilities, c) {
+			p.Capabilities = append(p.Capabilities, c)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"go-im/internal/model"
+)
+
+// maxHideBatch 为单次隐藏消息的上限。
+const maxHideBatch = 100
+
+// ErrTooManyMessages 表示单次隐藏的消息数超过上限。
+var ErrTooManyMessages = errors.New("too many messages in one request")
+
+// ErrInvalidMessageRef 表示请求中的消息既没有给出 conversation_id + seq，也没有给出 sender_id + msg_id。
+var ErrInvalidMessageRef = errors.New("message must be identified by conversation_id and seq, or sender_id and msg_id")
+
+// MessageFinder 批量查询消息。
+type MessageFinder interface {
+	FindMessages(ctx context.Context, refs []model.MessageRef) ([]model.TimelineMessage, error)
+}
+
+// HiddenStore 描述隐藏消息集合的读写能力。
+type HiddenStore interface {
+	HideMessages(ctx context.Context, userID string, msgs []model.TimelineMessage) error
+	ListHidden(ctx context.Context, userID, conversationID string) ([]model.HiddenMessage, error)
+}
+
+// HiddenMessageRef 是隐藏消息在协议中的表示。
+type HiddenMessageRef struct {
+	MsgID          string `json:"msg_id"`
+	ConversationID string `json:"conversation_id"`
+	Seq            int64  `json:"seq"`
+}
+
+// HideService 处理“仅对自己删除”：隐藏的消息在该用户的所有拉取路径中不可见，并同步到其他设备。
+type HideService struct {
+	messages MessageFinder
+	hidden   HiddenStore
+	members  MemberLookup
+	push     *PushService
+}
+
+func NewHideService(messages MessageFinder, hidden HiddenStore, members MemberLookup, push *PushService) *HideService {
+	return &HideService{messages: messages, hidden: hidden, members: members, push: push}
+}
+
+// HideMessages 为 userID 隐藏 refs 指定的消息，返回实际隐藏的消息。
+// 不存在或用户不在其会话内的消息被忽略；origin 连接不会收到同步包。
+func (s *HideService) HideMessages(ctx context.Context, userID string, refs []model.MessageRef, origin ConnWriter) ([]HiddenMessageRef, error) {
+	if len(refs) > maxHideBatch {
+		return nil, ErrTooManyMessages
+	}
+	for _, ref := range refs {
+		if !ref.Valid() {
+			return nil, ErrInvalidMessageRef
+		}
+	}
+	found, err := s.messages.FindMessages(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	membership := make(map[string]bool)
+	visible := make([]model.TimelineMessage, 0, len(found))
+	for _, m := range found {
+		isMember, checked := membership[m.ConversationID]
+		if !checked {
+			if isMember, err = s.isMember(ctx, userID, m.ConversationID); err != nil {
+				return nil, err
+			}
+			membership[m.ConversationID] = isMember
+		}
+		if isMember {
+			visible = append(visible, m)
+		}
+	}
+	if len(visible) == 0 {
+		return []HiddenMessageRef{}, nil
+	}
+	if err := s.hidden.HideMessages(ctx, userID, visible); err != nil {
+		return nil, err
+	}
+
+	hidden := make([]HiddenMessageRef, 0, len(visible))
+	for _, m := range visible {
+		hidden = append(hidden, HiddenMessageRef{MsgID: m.MsgID, ConversationID: m.ConversationID, Seq: int64(m.Seq)})
+	}
+	if s.push != nil {
+		sync := model.OutputPacket{Cmd: model.CmdHideMessages, Code: model.CodeOK, Payload: hidden}
+		_ = s.push.SyncUser(ctx, userID, sync, origin)
+	}
+	return hidden, nil
+}
+
+// ListHidden 返回用户在会话内隐藏的全部消息，供设备上线时对齐本地缓存。
+func (s *HideService) ListHidden(ctx context.Context, userID, conversationID string) ([]HiddenMessageRef, error) {
+	rows, err := s.hidden.ListHidden(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]HiddenMessageRef, 0, len(rows))
+	for _, r := range rows {
+		refs = append(refs, HiddenMessageRef{MsgID: r.MsgID, ConversationID: r.ConversationID, Seq: int64(r.Seq)})
+	}
+	return refs, nil
+}
+
+func (s *HideService) isMember(ctx context.Context, userID, conversationID string) (bool, error) {
+	members, err := s.members.ListMembers(ctx, conversationID)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range members {
+		if m == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,79 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+// stubFinder 以 sender_id/msg_id 索引消息。
+type stubFinder map[string]model.TimelineMessage
+
+func (f stubFinder) FindMessages(ctx context.Context, refs []model.MessageRef) ([]model.TimelineMessage, error) {
+	var out []model.TimelineMessage
+	for _, ref := range refs {
+		for _, m := range f {
+			if (m.ConversationID == ref.ConversationID && int64(m.Seq) == ref.Seq) || (m.SenderID == ref.SenderID && m.MsgID == ref.MsgID) {
+				out = append(out, m)
+			}
+		}
+	}
+	return out, nil
+}
+
+type memHiddenStore struct {
+	rows []model.HiddenMessage
+}
+
+func (s *memHiddenStore) HideMessages(ctx context.Context, userID string, msgs []model.TimelineMessage) error {
+	for _, m := range msgs {
+		s.rows = append(s.rows, model.HiddenMessage{UserID: userID, MsgID: m.MsgID, ConversationID: m.ConversationID, Seq: m.Seq})
+	}
+	return nil
+}
+
+func (s *memHiddenStore) ListHidden(ctx context.Context, userID, conversationID string) ([]model.HiddenMessage, error) {
+	return s.rows, nil
+}
+
+func TestHideMessagesSkipsForeignConversationsAndSyncs(t *testing.T) {
+	finder := stubFinder{
+		"u2/m1": {MsgID: "m1", SenderID: "u2", ConversationID: "g1", Seq: 1},
+		"u9/m2": {MsgID: "m2", SenderID: "u9", ConversationID: "secret", Seq: 1},
+	}
+	store := &memHiddenStore{}
+	lookup := &stubConnLookup{conns: map[string]*stubConn{"u1": {}}}
+	svc := service.NewHideService(finder, store, stubMembers{"g1": {"u1"}, "secret": {"u9"}}, service.NewPushService(lookup, nil, nil))
+
+	refs, err := svc.HideMessages(context.Background(), "u1", []model.MessageRef{
+		{ConversationID: "g1", Seq: 1},
+		{SenderID: "u9", MsgID: "m2"},
+		{SenderID: "u2", MsgID: "missing"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("HideMessages error: %v", err)
+	}
+	if len(refs) != 1 || refs[0].MsgID != "m1" {
+		t.Fatalf("expected only m1 hidden, got %+v", refs)
+	}
+	if len(store.rows) != 1 {
+		t.Fatalf("expected one stored row, got %d", len(store.rows))
+	}
+	if pkt := lookup.conns["u1"].writes[0].(model.OutputPacket); pkt.Cmd != model.CmdHideMessages {
+		t.Fatalf("unexpected sync packet: %#v", pkt)
+	}
+}
+
+func TestHideMessagesRejectsOversizedBatch(t *testing.T) {
+	svc := service.NewHideService(stubFinder{}, &memHiddenStore{}, stubMembers{}, nil)
+	refs := make([]model.MessageRef, 101)
+	if _, err := svc.HideMessages(context.Background(), "u1", refs, nil); !errors.Is(err, service.ErrTooManyMessages) {
+		t.Fatalf("expected ErrTooManyMessages, got %v", err)
+	}
+	if _, err := svc.HideMessages(context.Background(), "u1", []model.MessageRef{{MsgID: "m1"}}, nil); !errors.Is(err, service.ErrInvalidMessageRef) {
+		t.Fatalf("msg_id without sender_id should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotMember 表示用户不是会话成员，不区分会话是否存在。
+var ErrNotMember = errors.New("not a member of the conversation")
+
+// MembershipChecker 校验用户是否为会话成员，会话 ID 即 group_member.group_id。
+type MembershipChecker interface {
+	// MemberConversations 返回 conversationIDs 中 userID 为成员的会话，一次查询完成。
+	MemberConversations(ctx context.Context, userID string, conversationIDs []string) ([]string, error)
+}
+
+// requireMember 在 userID 不是会话成员时返回 ErrNotMember，members 为 nil 时不校验。
+func requireMember(ctx context.Context, members MembershipChecker, userID, conversationID string) error {
+	if members == nil {
+		return nil
+	}
+	ids, err := members.MemberConversations(ctx, userID, []string{conversationID})
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// memberSet 返回 conversationIDs 中 userID 为成员的会话集合，members 为 nil 时视为全部是成员。
+func memberSet(ctx context.Context, members MembershipChecker, userID string, conversationIDs []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(conversationIDs))
+	if members == nil {
+		for _, id := range conversationIDs {
+			set[id] = true
+		}
+		return set, nil
+	}
+	ids, err := members.MemberConversations(ctx, userID, conversationIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set, nil
+}
@@ -0,0 +1,101 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go-im/internal/model"
+	"go-im/internal/repository"
+	"go-im/internal/service"
+)
+
+func TestNonMembersAreRejected(t *testing.T) {
+	store := repository.NewMemoryStore()
+	store.AddMember("g1", "u1")
+	ctx := context.Background()
+
+	msgSvc := service.NewMessageService(store, store, nil, nil)
+	out, err := msgSvc.HandleChat(ctx, "u2", nil, model.InputPacket{Cmd: model.CmdChat, ConversationId: "g1", MsgId: "m1"}, service.ChatPayload{Content: "hi"})
+	if err != nil || out.Code != model.CodeNotMember || out.Reason != "not_member" {
+		t.Fatalf("chat from non-member should be rejected, got %+v, %v", out, err)
+	}
+	if out, err := msgSvc.HandleChat(ctx, "u1", nil, model.InputPacket{Cmd: model.CmdChat, ConversationId: "g1", MsgId: "m1"}, service.ChatPayload{Content: "hi"}); err != nil || out.Code != model.CodeOK {
+		t.Fatalf("chat from member should succeed, got %+v, %v", out, err)
+	}
+
+	pull := service.NewPullService(store, nil, store, 0, 0, 0)
+	if _, err := pull.PullMessages(ctx, "u2", "g1", 0, 0); !errors.Is(err, service.ErrNotMember) {
+		t.Fatalf("pull by non-member should fail with ErrNotMember, got %v", err)
+	}
+	if res, err := pull.PullMessages(ctx, "u1", "g1", 0, 0); err != nil || len(res.Messages) != 1 {
+		t.Fatalf("pull by member should return the message, got %+v, %v", res, err)
+	}
+
+	acks := service.NewAckCoalescer(store, store, 0, nil)
+	if err := acks.Ack(ctx, "u2", "g1", 1); !errors.Is(err, service.ErrNotMember) {
+		t.Fatalf("ack by non-member should fail with ErrNotMember, got %v", err)
+	}
+	if err := acks.AckBatch(ctx, "u1", []model.AckEntry{{ConversationId: "g1", Seq: 1}, {ConversationId: "g2", Seq: 1}}); !errors.Is(err, service.ErrNotMember) {
+		t.Fatalf("batch ack with a foreign conversation should fail with ErrNotMember, got %v", err)
+	}
+	if list, _ := store.ListConversations(ctx, "u1"); len(list) != 1 || list[0].LastAckSeq != 0 {
+		t.Fatalf("rejected batch should not ack anything, got %+v", list)
+	}
+
+	conv := service.NewConversationService(store, store, nil)
+	muted := true
+	if _, err := conv.UpdateSettings(ctx, "u2", "g1", service.SettingsPatch{Muted: &muted}, nil); !errors.Is(err, service.ErrNotMember) {
+		t.Fatalf("settings change by non-member should fail with ErrNotMember, got %v", err)
+	}
+	if _, err := conv.ClearHistory(ctx, "u2", "g1", 0, nil); !errors.Is(err, service.ErrNotMember) {
+		t.Fatalf("clear by non-member should fail with ErrNotMember, got %v", err)
+	}
+	if service.ErrorCode(service.ErrNotMember) != model.CodeNotMember {
+		t.Fatalf("ErrNotMember should map to CodeNotMember")
+	}
+}
+
+// countingMembers 统计成员关系查询次数。
+type countingMembers struct {
+	*repository.MemoryStore
+	calls int
+}
+
+func (m *countingMembers) MemberConversations(ctx context.Context, userID string, conversationIDs []string) ([]string, error) {
+	m.calls++
+	return m.MemoryStore.MemberConversations(ctx, userID, conversationIDs)
+}
+
+func TestBatchPullSkipsConversationsOfOthers(t *testing.T) {
+	store := repository.NewMemoryStore()
+	ctx := context.Background()
+	for _, conv := range []string{"g1", "g2", "g3"} {
+		store.AddMember(conv, "u2")
+		if err := store.SaveMessage(ctx, &model.TimelineMessage{MsgID: conv + "-m", ConversationID: conv, SenderID: "u2"}); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+	store.AddMember("g2", "u1")
+	members := &countingMembers{MemoryStore: store}
+	svc := service.NewPullService(store, nil, members, 0, 0, 0)
+
+	pages, err := svc.BatchPull(ctx, "u1", []model.PullCursor{{ConversationId: "g1"}, {ConversationId: "g2"}, {ConversationId: "g3", CursorSeq: 7}}, 0, 0)
+	if err != nil {
+		t.Fatalf("BatchPull failed: %v", err)
+	}
+	if members.calls != 1 {
+		t.Fatalf("membership should be checked in one query, got %d", members.calls)
+	}
+	if len(pages) != 3 || pages[1].ConversationId != "g2" || len(pages[1].Messages) != 1 || pages[1].Code != model.CodeOK {
+		t.Fatalf("member conversation should be pulled in request order, got %+v", pages)
+	}
+	for _, i := range []int{0, 2} {
+		if pages[i].Code != model.CodeNotMember || pages[i].Reason != "not_member" || len(pages[i].Messages) != 0 {
+			t.Fatalf("page %d should be rejected as not_member, got %+v", i, pages[i])
+		}
+	}
+	if pages[2].NextCursorSeq != 7 {
+		t.Fatalf("rejected page should keep the cursor, got %d", pages[2].NextCursorSeq)
+	}
+}
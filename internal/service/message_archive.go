@@ -0,0 +1,94 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-im/internal/model"
+)
+
+// ArchiveWriter 保存按保留策略归档的消息。本地实现为 FileArchive，接入对象存储时实现该接口即可。
+type ArchiveWriter interface {
+	// WriteArchive 持久化同一会话内按 seq 升序的一批消息，返回 nil 后这些消息即可从数据库删除。
+	// 同一批消息可能因删除失败而重复写入，实现需保证幂等。
+	WriteArchive(ctx context.Context, conversationID string, msgs []model.TimelineMessage) error
+}
+
+// archivedMessage 是归档文件中的一行，保留 timeline_message 的全部列，便于导回。
+type archivedMessage struct {
+	ID             uint64    `json:"id"`
+	MsgID          string    `json:"msg_id"`
+	ConversationID string    `json:"conversation_id"`
+	Seq            uint64    `json:"seq"`
+	SenderID       string    `json:"sender_id"`
+	Content        string    `json:"content"`
+	MsgType        int8      `json:"msg_type"`
+	Status         int8      `json:"status"`
+	SendTime       int64     `json:"send_time"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// FileArchive 把每批消息写成 dir/<会话 ID>/<首条 seq>-<末条 seq>.jsonl.gz。
+// 文件先写入临时文件并落盘再改名，同一批消息重复写入时覆盖为相同内容。
+type FileArchive struct {
+	dir string
+}
+
+func NewFileArchive(dir string) *FileArchive {
+	return &FileArchive{dir: dir}
+}
+
+func (a *FileArchive) WriteArchive(ctx context.Context, conversationID string, msgs []model.TimelineMessage) (err error) {
+	if len(msgs) == 0 {
+		return nil
+	}
+	dir := filepath.Join(a.dir, archiveDirName(conversationID))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".archive-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	for _, m := range msgs {
+		if err := enc.Encode(archivedMessage(m)); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%d.jsonl.gz", msgs[0].Seq, msgs[len(msgs)-1].Seq)
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// archiveDirName 把会话 ID 转义为单层目录名，不会越出归档目录。
+func archiveDirName(conversationID string) string {
+	name := url.PathEscape(conversationID)
+	if strings.Trim(name, ".") == "" {
+		name = strings.ReplaceAll(name, ".", "%2E")
+	}
+	return name
+}
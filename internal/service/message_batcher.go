@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go-im/internal/metrics"
+	"go-im/internal/model"
+)
+
+// ErrWriterClosed 表示组提交管道已停止，不再接收消息。
+var ErrWriterClosed = errors.New("message writer closed")
+
+// MessageBatchSaver 描述支持组提交的消息存储。
+type MessageBatchSaver interface {
+	MessageSaver
+	// SaveMessages 在一个事务中写入一批消息，results 与 msgs 一一对应；err 非 nil 时没有任何消息写入。
+	SaveMessages(ctx context.Context, msgs []*model.TimelineMessage) (results []error, err error)
+}
+
+type writeRequest struct {
+	ctx  context.Context
+	msg  *model.TimelineMessage
+	done chan error
+}
+
+// MessageBatcher 组提交聊天消息：收集并发写入，凑满 maxBatch 条或等满 maxDelay 后在一个事务内提交，
+// 再把每条消息的结果分别交还给等待的调用方。它实现 MessageSaver，可替换仓储注入 MessageService。
+// 同一连接的消息由读循环串行提交，批内顺序即到达顺序，会话内 seq 顺序与逐条提交一致；
+// 批量事务失败时逐条重试，单条消息的错误不影响同批其他消息。
+type MessageBatcher struct {
+	store    MessageBatchSaver
+	maxBatch int
+	maxDelay time.Duration
+	logger   *slog.Logger
+
+	requests chan writeRequest // 无缓冲：Run 退出后不会有请求滞留在队列中
+	stopped  chan struct{}
+}
+
+// NewMessageBatcher 创建组提交管道，需启动 Run 后才能写入；logger 为 nil 时使用 slog.Default()。
+func NewMessageBatcher(store MessageBatchSaver, maxBatch int, maxDelay time.Duration, logger *slog.Logger) *MessageBatcher {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	return &MessageBatcher{
+		store:    store,
+		maxBatch: maxBatch,
+		maxDelay: maxDelay,
+		logger:   logger,
+		requests: make(chan writeRequest),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// SaveMessage 提交一条消息并等待所在批次提交完成。
+// ctx 结束时立即返回，消息仍可能随批次写入，客户端按 msg_id 重发即可得到幂等结果。
+func (b *MessageBatcher) SaveMessage(ctx context.Context, msg *model.TimelineMessage) error {
+	req := writeRequest{ctx: ctx, msg: msg, done: make(chan error, 1)}
+	select {
+	case b.requests <- req:
+	case <-b.stopped:
+		return ErrWriterClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FindDedup 直接查询底层存储。
+func (b *MessageBatcher) FindDedup(ctx context.Context, senderID, msgID string) (*model.MessageDedup, error) {
+	return b.store.FindDedup(ctx, senderID, msgID)
+}
+
+// FindMessages 直接查询底层存储。
+func (b *MessageBatcher) FindMessages(ctx context.Context, refs []model.MessageRef) ([]model.TimelineMessage, error) {
+	return b.store.FindMessages(ctx, refs)
+}
+
+// Run 收集并提交消息，直到 ctx 取消；之后的写入返回 ErrWriterClosed。
+func (b *MessageBatcher) Run(ctx context.Context) {
+	defer close(b.stopped)
+	batch := make([]writeRequest, 0, b.maxBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-b.requests:
+			batch = append(batch[:0], req)
+		}
+
+		timer := time.NewTimer(b.maxDelay)
+	collect:
+		for len(batch) < b.maxBatch {
+			select {
+			case req := <-b.requests:
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		b.flush(batch)
+	}
+}
+
+// flush 提交一批消息并逐个通知调用方，已放弃等待的请求不再写入。
+func (b *MessageBatcher) flush(batch []writeRequest) {
+	live := make([]writeRequest, 0, len(batch))
+	for _, req := range batch {
+		if err := req.ctx.Err(); err != nil {
+			req.done <- err
+			continue
+		}
+		live = append(live, req)
+	}
+	if len(live) == 0 {
+		return
+	}
+	metrics.ChatWriteBatchSize.Observe(float64(len(live)))
+
+	msgs := make([]*model.TimelineMessage, len(live))
+	for i, req := range live {
+		msgs[i] = req.msg
+	}
+	ctx, cancel := batchContext(live)
+	defer cancel()
+
+	results, err := b.store.SaveMessages(ctx, msgs)
+	if err != nil && len(live) > 1 {
+		b.logger.Warn("批量写入消息失败，逐条重试", "size", len(live), "err", err)
+		for _, req := range live {
+			req.done <- b.store.SaveMessage(req.ctx, req.msg)
+		}
+		return
+	}
+	for i, req := range live {
+		if err != nil {
+			req.done <- err
+			continue
+		}
+		req.done <- results[i]
+	}
+}
+
+// batchContext 返回批次事务使用的 ctx：沿用首条消息的链路，截止时间取批内最晚的一个，
+// 单个调用方超时不会中断整批提交。
+func batchContext(reqs []writeRequest) (context.Context, context.CancelFunc) {
+	ctx := context.WithoutCancel(reqs[0].ctx)
+	var latest time.Time
+	for _, req := range reqs {
+		deadline, ok := req.ctx.Deadline()
+		if !ok {
+			return context.WithCancel(ctx)
+		}
+		if deadline.After(latest) {
+			latest = deadline
+		}
+	}
+	return context.WithDeadline(ctx, latest)
+}
@@ -0,0 +1,179 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-im/internal/config"
+	"go-im/internal/model"
+	"go-im/internal/repository"
+	"go-im/internal/service"
+)
+
+// batchCountingStore 统计组提交的事务数，可模拟整批失败。
+type batchCountingStore struct {
+	*repository.MemoryStore
+	batches   atomic.Int64
+	failBatch bool
+}
+
+func (s *batchCountingStore) SaveMessages(ctx context.Context, msgs []*model.TimelineMessage) ([]error, error) {
+	s.batches.Add(1)
+	if s.failBatch {
+		return nil, errors.New("deadlock")
+	}
+	return s.MemoryStore.SaveMessages(ctx, msgs)
+}
+
+func startBatcher(t *testing.T, store service.MessageBatchSaver, maxBatch int, maxDelay time.Duration) *service.MessageBatcher {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	b := service.NewMessageBatcher(store, maxBatch, maxDelay, nil)
+	go b.Run(ctx)
+	return b
+}
+
+func TestMessageBatcherGroupsConcurrentWrites(t *testing.T) {
+	store := &batchCountingStore{MemoryStore: repository.NewMemoryStore()}
+	b := startBatcher(t, store, 64, 20*time.Millisecond)
+
+	const n = 32
+	msgs := make([]*model.TimelineMessage, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range msgs {
+		msgs[i] = &model.TimelineMessage{MsgID: fmt.Sprintf("m%d", i), ConversationID: "c1", SenderID: "u1"}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = b.SaveMessage(context.Background(), msgs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool)
+	for i, m := range msgs {
+		if errs[i] != nil {
+			t.Fatalf("msg %d: %v", i, errs[i])
+		}
+		if m.Seq < 1 || m.Seq > n || seen[m.Seq] {
+			t.Fatalf("msg %d got invalid or repeated seq %d", i, m.Seq)
+		}
+		seen[m.Seq] = true
+	}
+	if got := store.batches.Load(); got >= n {
+		t.Fatalf("expected concurrent writes to share transactions, got %d batches for %d messages", got, n)
+	}
+
+	// 批内重复的 msg_id 只影响自己
+	dup := &model.TimelineMessage{MsgID: "m0", ConversationID: "c1", SenderID: "u1"}
+	if err := b.SaveMessage(context.Background(), dup); !errors.Is(err, repository.ErrDuplicateMsgID) {
+		t.Fatalf("expected ErrDuplicateMsgID, got %v", err)
+	}
+}
+
+func TestMessageBatcherRetriesEachMessageWhenBatchFails(t *testing.T) {
+	store := &batchCountingStore{MemoryStore: repository.NewMemoryStore(), failBatch: true}
+	b := startBatcher(t, store, 8, 20*time.Millisecond)
+
+	// 预先写入 m0，逐条重试时它单独返回重复错误
+	if err := store.SaveMessage(context.Background(), &model.TimelineMessage{MsgID: "m0", ConversationID: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	errs := make([]error, 3)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = b.SaveMessage(context.Background(), &model.TimelineMessage{MsgID: fmt.Sprintf("m%d", i), ConversationID: "c1"})
+		}(i)
+	}
+	wg.Wait()
+	if !errors.Is(errs[0], repository.ErrDuplicateMsgID) || errs[1] != nil || errs[2] != nil {
+		t.Fatalf("unexpected per-message results: %v", errs)
+	}
+}
+
+func TestMessageBatcherRejectsWritesAfterStop(t *testing.T) {
+	b := service.NewMessageBatcher(&batchCountingStore{MemoryStore: repository.NewMemoryStore()}, 8, time.Millisecond, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	err := b.SaveMessage(context.Background(), &model.TimelineMessage{MsgID: "late", ConversationID: "c1"})
+	if !errors.Is(err, service.ErrWriterClosed) {
+		t.Fatalf("expected ErrWriterClosed, got %v", err)
+	}
+	if code := service.ErrorCode(err); code != model.CodeUnavailable {
+		t.Fatalf("expected retryable unavailable code, got %d", code)
+	}
+}
+
+// BenchmarkChatWrite 对比逐条提交与组提交在并发写入下的吞吐。
+// 默认使用临时目录中的 SQLite 文件库，每次提交都会落盘；设置 IM_DB_DRIVER 时按正式配置连接数据库。
+func BenchmarkChatWrite(b *testing.B) {
+	cfg := config.Default().Storage
+	cfg.Driver, cfg.SQLitePath = repository.DriverSQLite, filepath.Join(b.TempDir(), "bench.db")
+	if os.Getenv("IM_DB_DRIVER") != "" {
+		loaded, err := config.Load(os.Getenv("IM_CONFIG"))
+		if err != nil {
+			b.Fatalf("failed to load config: %v", err)
+		}
+		cfg = loaded.Storage
+	}
+	db, err := repository.OpenConfig(cfg, nil)
+	if err != nil {
+		b.Fatalf("failed to init db: %v", err)
+	}
+	if err := repository.MigrateLatest(context.Background(), db); err != nil {
+		b.Fatalf("failed to migrate: %v", err)
+	}
+	repo := repository.NewMessageRepository(db)
+
+	run := func(b *testing.B, saver service.MessageSaver) {
+		prefix := fmt.Sprintf("bench-%s-%d", b.Name(), time.Now().UnixNano())
+		var seq atomic.Int64
+		b.SetParallelism(16)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				i := seq.Add(1)
+				msg := &model.TimelineMessage{
+					MsgID:          fmt.Sprintf("%s-%d", prefix, i),
+					ConversationID: fmt.Sprintf("%s-conv-%d", prefix, i%32),
+					SenderID:       "u1",
+					Content:        "hello",
+					MsgType:        1,
+					SendTime:       time.Now().UnixMilli(),
+				}
+				if err := saver.SaveMessage(context.Background(), msg); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	}
+
+	b.Run("direct", func(b *testing.B) { run(b, repo) })
+	b.Run("group_commit", func(b *testing.B) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		batcher := service.NewMessageBatcher(repo, cfg.WriteBatchSize, cfg.WriteBatchDelay, nil)
+		go batcher.Run(ctx)
+		run(b, batcher)
+	})
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"go-im/internal/metrics"
+	"go-im/internal/model"
+)
+
+// MessageCache 缓存热点会话的最近消息，拉取命中时不访问数据库。实现需并发安全，
+// 进程内实现为 RecentMessageCache，共享缓存（如 Redis）实现同一接口即可替换。
+// 缓存的消息视为不可变：目前没有修改已写入消息的路径，加入撤回、编辑时须同时让缓存中的副本失效。
+type MessageCache interface {
+	// Add 记录本节点新写入的消息，会话不在缓存中时新建。
+	Add(msg model.TimelineMessage)
+	// Fill 用从数据库读到的消息补全已缓存的会话，不新建会话。
+	Fill(conversationID string, msgs []model.TimelineMessage)
+	// Range 返回会话中 seq 在 (afterSeq, afterSeq+limit] 的消息；缓存无法确定完整结果时 ok 为 false。
+	Range(conversationID string, afterSeq int64, limit int) (msgs []model.TimelineMessage, ok bool)
+}
+
+// RecentMessageCache 是进程内的 MessageCache：每个会话一个按 seq 寻址的环形缓冲，保存连续的 [lo, hi]，
+// 会话按 LRU 淘汰，内存上限约为 maxConversations × perConversation 条消息。
+//
+// 缓冲的尾部被视为会话的最新位置，因此要求会话的所有写入都经过本节点：
+// 其他节点写入的消息在本节点再次写入该会话、发现 seq 断档之前不可见。多节点同时写同一会话时应关闭缓存。
+// 并发提交导致的乱序写入先暂存，断档补齐前尾部读取回落到数据库。
+type RecentMessageCache struct {
+	maxConversations int
+	perConversation  int
+
+	mu    sync.Mutex
+	convs map[string]*list.Element // 值为 *convBuffer，链表头部为最近使用
+	lru   *list.List
+}
+
+type convBuffer struct {
+	id       string
+	capacity int                     // 最多缓存的条数
+	ring     []model.TimelineMessage // 下标为 seq % len(ring)，按需扩容至 capacity
+	lo, hi   int64                   // 已缓存的连续区间，hi < lo 表示为空
+	pending  map[int64]model.TimelineMessage
+}
+
+// initialRing 为新会话缓冲的初始容量，冷门会话不预占满额内存。
+const initialRing = 8
+
+// NewRecentMessageCache 创建进程内缓存，最多缓存 maxConversations 个会话，每个会话最近 perConversation 条消息。
+func NewRecentMessageCache(maxConversations, perConversation int) *RecentMessageCache {
+	return &RecentMessageCache{
+		maxConversations: max(maxConversations, 1),
+		perConversation:  max(perConversation, 1),
+		convs:            make(map[string]*list.Element),
+		lru:              list.New(),
+	}
+}
+
+func (c *RecentMessageCache) Add(msg model.TimelineMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.buffer(msg.ConversationID, true)
+	b.put(msg)
+}
+
+func (c *RecentMessageCache) Fill(conversationID string, msgs []model.TimelineMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.buffer(conversationID, false)
+	if b == nil {
+		return
+	}
+	for _, m := range msgs {
+		b.put(m)
+	}
+}
+
+func (c *RecentMessageCache) Range(conversationID string, afterSeq int64, limit int) ([]model.TimelineMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.buffer(conversationID, false)
+	if b == nil || b.hi < b.lo || afterSeq+1 < b.lo || limit <= 0 {
+		metrics.PullCacheLookups.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	end := afterSeq + int64(limit)
+	if end > b.hi {
+		// 请求越过缓存尾部：只有没有断档时尾部才是会话的最新位置
+		if len(b.pending) > 0 {
+			metrics.PullCacheLookups.WithLabelValues("miss").Inc()
+			return nil, false
+		}
+		end = b.hi
+	}
+	msgs := make([]model.TimelineMessage, 0, max(end-afterSeq, 0))
+	for seq := afterSeq + 1; seq <= end; seq++ {
+		msgs = append(msgs, b.ring[seq%int64(len(b.ring))])
+	}
+	metrics.PullCacheLookups.WithLabelValues("hit").Inc()
+	return msgs, true
+}
+
+// buffer 返回会话的缓冲并标记为最近使用，create 为 true 时按需新建并淘汰最久未用的会话。调用方需持有锁。
+func (c *RecentMessageCache) buffer(conversationID string, create bool) *convBuffer {
+	if e, ok := c.convs[conversationID]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*convBuffer)
+	}
+	if !create {
+		return nil
+	}
+	b := &convBuffer{id: conversationID, capacity: c.perConversation, ring: make([]model.TimelineMessage, min(initialRing, c.perConversation)), lo: 1}
+	c.convs[conversationID] = c.lru.PushFront(b)
+	for c.lru.Len() > c.maxConversations {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.convs, oldest.Value.(*convBuffer).id)
+	}
+	return b
+}
+
+// put 写入一条消息：已缓存的覆盖，紧接尾部的追加并吸收暂存的后续消息，其余暂存等待断档补齐。
+func (b *convBuffer) put(msg model.TimelineMessage) {
+	seq := int64(msg.Seq)
+	switch {
+	case b.hi < b.lo:
+		// 空缓冲从第一条消息开始，更早的 seq 一律视为未缓存
+		b.lo, b.hi = seq, seq
+		b.ring[seq%int64(len(b.ring))] = msg
+	case seq < b.lo:
+		return
+	case seq <= b.hi:
+		b.ring[seq%int64(len(b.ring))] = msg
+		return
+	case seq == b.hi+1:
+		b.append(msg)
+	default:
+		if b.pending == nil {
+			b.pending = make(map[int64]model.TimelineMessage)
+		}
+		b.pending[seq] = msg
+		if len(b.pending) > b.capacity {
+			// 断档迟迟未补齐（通常是其他节点写入），丢弃旧数据，从暂存的最小 seq 重新开始
+			b.restart()
+		}
+		return
+	}
+	for {
+		next, ok := b.pending[b.hi+1]
+		if !ok {
+			return
+		}
+		delete(b.pending, b.hi+1)
+		b.append(next)
+	}
+}
+
+func (b *convBuffer) append(msg model.TimelineMessage) {
+	b.hi++
+	if b.hi-b.lo+1 > int64(len(b.ring)) {
+		if len(b.ring) < b.capacity {
+			b.grow()
+		} else {
+			b.lo++
+		}
+	}
+	b.ring[b.hi%int64(len(b.ring))] = msg
+}
+
+// grow 将环形缓冲扩容一倍（不超过 capacity），已缓存的消息按新长度重新寻址。
+func (b *convBuffer) grow() {
+	ring := make([]model.TimelineMessage, min(len(b.ring)*2, b.capacity))
+	for seq := b.lo; seq < b.hi; seq++ {
+		ring[seq%int64(len(ring))] = b.ring[seq%int64(len(b.ring))]
+	}
+	b.ring = ring
+}
+
+// restart 以暂存中最小的 seq 为新的起点重建缓冲。
+func (b *convBuffer) restart() {
+	pending := b.pending
+	first := int64(-1)
+	for seq := range pending {
+		if first < 0 || seq < first {
+			first = seq
+		}
+	}
+	b.pending = nil
+	b.lo, b.hi = first, first-1
+	for {
+		msg, ok := pending[b.hi+1]
+		if !ok {
+			break
+		}
+		delete(pending, b.hi+1)
+		b.append(msg)
+	}
+	if len(pending) > 0 {
+		b.pending = pending
+	}
+}
+
+// CachingSaver 在消息写库成功后把消息加入缓存。
+type CachingSaver struct {
+	MessageSaver
+	cache MessageCache
+}
+
+func NewCachingSaver(saver MessageSaver, cache MessageCache) *CachingSaver {
+	return &CachingSaver{MessageSaver: saver, cache: cache}
+}
+
+func (s *CachingSaver) SaveMessage(ctx context.Context, msg *model.TimelineMessage) error {
+	if err := s.MessageSaver.SaveMessage(ctx, msg); err != nil {
+		return err
+	}
+	s.cache.Add(*msg)
+	return nil
+}
+
+// CachingPullStorage 拉取消息时先查缓存，未命中的会话查库后用结果补全缓存；其余方法直接使用底层存储。
+// 缓存只省去消息本身的查询：每次拉取仍会查询用户的清空水位（GetClearedSeq）、会话的归档位点（GetArchivedSeqs）
+// 与用户隐藏的消息，这些按用户变化的水位不缓存。缓存只感知经过本节点 CachingSaver 的写入，
+// 多个节点同时写同一会话时其他节点的消息会被漏掉，因此默认关闭。
+type CachingPullStorage struct {
+	PullStorage
+	cache MessageCache
+}
+
+func NewCachingPullStorage(store PullStorage, cache MessageCache) *CachingPullStorage {
+	return &CachingPullStorage{PullStorage: store, cache: cache}
+}
+
+func (s *CachingPullStorage) ListMessages(ctx context.Context, conversationID string, afterSeq int64, limit int) ([]model.TimelineMessage, error) {
+	if msgs, ok := s.cache.Range(conversationID, afterSeq, limit); ok {
+		return msgs, nil
+	}
+	msgs, err := s.PullStorage.ListMessages(ctx, conversationID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.Fill(conversationID, msgs)
+	return msgs, nil
+}
+
+func (s *CachingPullStorage) ListMessagesBatch(ctx context.Context, cursors []model.PullCursor, limit int) (map[string][]model.TimelineMessage, error) {
+	found := make(map[string][]model.TimelineMessage, len(cursors))
+	var misses []model.PullCursor
+	for _, c := range cursors {
+		if msgs, ok := s.cache.Range(c.ConversationId, c.CursorSeq, limit); ok {
+			found[c.ConversationId] = msgs
+			continue
+		}
+		misses = append(misses, c)
+	}
+	if len(misses) == 0 {
+		return found, nil
+	}
+	fetched, err := s.PullStorage.ListMessagesBatch(ctx, misses, limit)
+	if err != nil {
+		return nil, err
+	}
+	for id, msgs := range fetched {
+		s.cache.Fill(id, msgs)
+		found[id] = msgs
+	}
+	return found, nil
+}
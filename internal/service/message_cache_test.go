@@ -0,0 +1,148 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go-im/internal/model"
+	"go-im/internal/repository"
+	"go-im/internal/service"
+)
+
+func cachedMsg(conv string, seq uint64) model.TimelineMessage {
+	return model.TimelineMessage{MsgID: fmt.Sprintf("%s-%d", conv, seq), ConversationID: conv, Seq: seq, Content: "c"}
+}
+
+func seqsOf(msgs []model.TimelineMessage) []uint64 {
+	seqs := make([]uint64, len(msgs))
+	for i, m := range msgs {
+		seqs[i] = m.Seq
+	}
+	return seqs
+}
+
+func TestRecentMessageCacheRanges(t *testing.T) {
+	c := service.NewRecentMessageCache(10, 16)
+	for seq := uint64(5); seq <= 30; seq++ {
+		c.Add(cachedMsg("c1", seq))
+	}
+	// 容量 16：只保留 15..30
+
+	tests := []struct {
+		name     string
+		after    int64
+		limit    int
+		wantOK   bool
+		wantSeqs []uint64
+	}{
+		{"fully covered", 16, 3, true, []uint64{17, 18, 19}},
+		{"tail reaches head", 27, 10, true, []uint64{28, 29, 30}},
+		{"cursor at head", 30, 10, true, []uint64{}},
+		{"starts right at oldest", 14, 2, true, []uint64{15, 16}},
+		{"evicted range", 10, 3, false, nil},
+	}
+	for _, tt := range tests {
+		msgs, ok := c.Range("c1", tt.after, tt.limit)
+		if ok != tt.wantOK || (ok && fmt.Sprint(seqsOf(msgs)) != fmt.Sprint(tt.wantSeqs)) {
+			t.Fatalf("%s: got %v %v, want %v %v", tt.name, seqsOf(msgs), ok, tt.wantSeqs, tt.wantOK)
+		}
+	}
+	if _, ok := c.Range("unknown", 0, 10); ok {
+		t.Fatal("uncached conversation should miss")
+	}
+}
+
+func TestRecentMessageCacheOutOfOrderAdds(t *testing.T) {
+	c := service.NewRecentMessageCache(10, 16)
+	c.Add(cachedMsg("c1", 1))
+	c.Add(cachedMsg("c1", 3)) // 2 尚未写入缓存（并发提交乱序）
+
+	if _, ok := c.Range("c1", 0, 10); ok {
+		t.Fatal("tail read across a gap must fall back to the database")
+	}
+	if msgs, ok := c.Range("c1", 0, 1); !ok || len(msgs) != 1 {
+		t.Fatalf("range below the gap is still covered, got %v %v", seqsOf(msgs), ok)
+	}
+
+	c.Add(cachedMsg("c1", 2))
+	msgs, ok := c.Range("c1", 0, 10)
+	if !ok || fmt.Sprint(seqsOf(msgs)) != "[1 2 3]" {
+		t.Fatalf("gap should be closed, got %v %v", seqsOf(msgs), ok)
+	}
+
+	// 从数据库读到的消息同样可以补齐断档
+	c.Add(cachedMsg("c1", 5))
+	c.Fill("c1", []model.TimelineMessage{cachedMsg("c1", 4), cachedMsg("c1", 5)})
+	if msgs, ok := c.Range("c1", 3, 10); !ok || fmt.Sprint(seqsOf(msgs)) != "[4 5]" {
+		t.Fatalf("fill should close the gap, got %v %v", seqsOf(msgs), ok)
+	}
+}
+
+func TestRecentMessageCacheBounds(t *testing.T) {
+	c := service.NewRecentMessageCache(2, 16)
+	c.Add(cachedMsg("c1", 1))
+	c.Add(cachedMsg("c2", 1))
+	c.Range("c1", 0, 1) // c1 最近使用，c2 被淘汰
+	c.Add(cachedMsg("c3", 1))
+	if _, ok := c.Range("c2", 0, 1); ok {
+		t.Fatal("least recently used conversation should be evicted")
+	}
+	if _, ok := c.Range("c1", 0, 1); !ok {
+		t.Fatal("recently used conversation should be kept")
+	}
+
+	// Fill 不会为未缓存的会话建立缓存
+	c.Fill("c4", []model.TimelineMessage{cachedMsg("c4", 1)})
+	if _, ok := c.Range("c4", 0, 1); ok {
+		t.Fatal("fill must not create conversations")
+	}
+}
+
+func TestPullServiceServesHotConversationFromCache(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	cache := service.NewRecentMessageCache(10, 16)
+	saver := service.NewCachingSaver(repository.NewMessageRepository(db), cache)
+	store := &countingPullStore{PullRepository: repository.NewPullRepository(db)}
+	svc := service.NewPullService(service.NewCachingPullStorage(store, cache), stubHidden{1 << 40}, nil, 5, 0, 0)
+
+	conv := uniqueID("conv-hot")
+	for i := 0; i < 8; i++ {
+		msg := &model.TimelineMessage{MsgID: uniqueID("hot"), ConversationID: conv, SenderID: "u1", Content: "c", MsgType: 1}
+		if err := saver.SaveMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 多个成员拉取同一段最近消息，全部命中缓存
+	for i := 0; i < 3; i++ {
+		res, err := svc.PullMessages(ctx, fmt.Sprintf("member-%d", i), conv, 6, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(seqsOf(res.Messages)) != "[7 8]" || res.HasMore || res.NextCursorSeq != 8 {
+			t.Fatalf("unexpected page %v more=%v next=%d", seqsOf(res.Messages), res.HasMore, res.NextCursorSeq)
+		}
+	}
+	res, err := svc.PullMessages(ctx, "u1", conv, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Messages) != 5 || !res.HasMore {
+		t.Fatalf("full page should report more, got %v more=%v", seqsOf(res.Messages), res.HasMore)
+	}
+	if store.single != 0 {
+		t.Fatalf("covered pulls should not query the database, got %d queries", store.single)
+	}
+
+	// 未缓存的会话照常查库
+	cold := uniqueID("conv-cold")
+	seedMessages(t, db, cold, []int64{1, 2})
+	if res, err := svc.PullMessages(ctx, "u1", cold, 0, 0); err != nil || len(res.Messages) != 2 {
+		t.Fatalf("cold pull: %v %v", seqsOf(res.Messages), err)
+	}
+	if store.single != 1 {
+		t.Fatalf("cold conversation should hit the database once, got %d", store.single)
+	}
+}
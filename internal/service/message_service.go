@@ -3,28 +3,52 @@ package service
 import (
 	"context"
 	"errors"
-	"log"
+	"log/slog"
+	"strconv"
 	"time"
 
+	"go-im/internal/metrics"
 	"go-im/internal/model"
 	"go-im/internal/repository"
+	"go-im/internal/tracing"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("go-im/internal/service")
+
 // MessageService 封装消息写库逻辑。
 type MessageService struct {
-	msgRepo MessageSaver
+	msgRepo   MessageSaver
+	members   MembershipChecker // 可为 nil，此时不校验发送者是否为会话成员
+	publisher MessagePublisher  // 可为 nil，此时只写库不推送
+	logger    *slog.Logger
 }
 
 // MessageSaver 描述消息持久化需要实现的接口，便于测试替换。
+// SaveMessage 在发送方用过该 msg_id 时返回 repository.ErrDuplicateMsgID，FindDedup 返回对应的去重记录，
+// 重发的回包由 FindMessages 按记录中的 (conversation_id, seq) 读出的已存消息构造。
 type MessageSaver interface {
+	MessageFinder
 	SaveMessage(ctx context.Context, msg *model.TimelineMessage) error
-	FindByMsgID(ctx context.Context, msgID string) (*model.TimelineMessage, error)
+	FindDedup(ctx context.Context, senderID, msgID string) (*model.MessageDedup, error)
+}
+
+// MessagePublisher 在消息写库成功后负责投递给在线成员。
+type MessagePublisher interface {
+	// Publish 推送 msg，origin 为发出消息的连接（可为 nil），它已经收到回包，不再推送。
+	Publish(ctx context.Context, msg *model.TimelineMessage, origin ConnWriter) error
 }
 
-func NewMessageService(msgRepo MessageSaver) *MessageService {
-	return &MessageService{msgRepo: msgRepo}
+// NewMessageService 创建消息服务，logger 为 nil 时使用 slog.Default()。
+func NewMessageService(msgRepo MessageSaver, members MembershipChecker, publisher MessagePublisher, logger *slog.Logger) *MessageService {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &MessageService{msgRepo: msgRepo, members: members, publisher: publisher, logger: logger}
 }
 
 // ChatPayload 表示聊天消息的负载体。
@@ -33,10 +57,31 @@ type ChatPayload struct {
 	MsgType int8   `json:"msg_type"` // 1: 文本，2: 图片
 }
 
-// HandleChat 保存消息并返回写入后的 seq。
-func (s *MessageService) HandleChat(ctx context.Context, userID string, packet model.InputPacket, payload ChatPayload) (model.OutputPacket, error) {
+// HandleChat 保存消息，回包的 Payload 为写入后的 ChatMessage，携带 seq 与服务端时间。
+// 发送者不是会话成员时返回 CodeNotMember，消息不写入；origin 为发出消息的连接，写库后不再向它推送。
+// msg_id 按发送方去重：同一会话、同一内容的重发返回已有 seq；msg_id 已被发送方的另一条消息使用时返回 CodeDuplicate，消息不写入。
+func (s *MessageService) HandleChat(ctx context.Context, userID string, origin ConnWriter, packet model.InputPacket, payload ChatPayload) (out model.OutputPacket, err error) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "MessageService.HandleChat", trace.WithAttributes(
+		attribute.String("im.conversation_id", packet.ConversationId),
+		attribute.String("im.msg_id", packet.MsgId),
+	))
+	defer func() {
+		metrics.ChatDuration.Observe(time.Since(start).Seconds())
+		metrics.ChatResults.WithLabelValues(strconv.Itoa(int(out.Code))).Inc()
+		span.SetAttributes(attribute.Int("im.code", int(out.Code)), attribute.Int64("im.seq", out.Seq))
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	// TODO: 生成 msg_id（若缺省）、填充默认 msg_type，调用仓储写库并处理幂等/错误，返回 seq
 	msg_id := packet.MsgId
+	if err = requireMember(ctx, s.members, userID, packet.ConversationId); err != nil {
+		if errors.Is(err, ErrNotMember) {
+			return model.OutputPacket{Cmd: model.CmdChat, MsgId: msg_id, ConversationId: packet.ConversationId}.WithError(model.CodeNotMember, "不是会话成员!"), nil
+		}
+		return model.OutputPacket{Cmd: model.CmdChat, MsgId: msg_id}.WithError(ErrorCode(err), "消息发送失败!"), err
+	}
 	if msg_id == "" {
 		msg_id = uuid.NewString()
 	}
@@ -50,33 +95,60 @@ func (s *MessageService) HandleChat(ctx context.Context, userID string, packet m
 		SenderID:       userID,
 		Content:        payload.Content,
 		MsgType:        payload.MsgType,
-		Status:         1,
+		Status:         model.MsgStatusNormal,
 		SendTime:       time.Now().UnixMilli(),
 	}
 
-	err := s.msgRepo.SaveMessage(ctx, msg)
+	err = s.msgRepo.SaveMessage(ctx, msg)
 	if err != nil {
 		if errors.Is(err, repository.ErrDuplicateMsgID) {
-			log.Printf("重复消息 msg_id=%s，返回幂等结果", msg_id)
-			// 幂等场景：查已有记录并返回已有 seq
-			existing, findErr := s.msgRepo.FindByMsgID(ctx, msg_id)
+			// 幂等场景：查发送方的去重记录，确认是同一条消息后返回已有 seq
+			existing, findErr := s.msgRepo.FindDedup(ctx, userID, msg_id)
 			if findErr != nil {
-				return model.OutputPacket{Cmd: model.CmdChat, Code: 1, MsgId: msg_id}, findErr
+				return model.OutputPacket{Cmd: model.CmdChat, MsgId: msg_id}.WithError(ErrorCode(findErr), "消息发送失败!"), findErr
+			}
+			if !existing.Matches(msg) {
+				s.logger.WarnContext(ctx, "msg_id 与已有消息冲突", "user", userID, "msg_id", msg_id, "conversation_id", packet.ConversationId, "existing_conversation_id", existing.ConversationID)
+				return model.OutputPacket{Cmd: model.CmdChat, MsgId: msg_id}.WithError(model.CodeDuplicate, "msg_id 已被其他消息使用!"), nil
 			}
-			return model.OutputPacket{
-				Cmd:   model.CmdChat,
-				Code:  0,
-				MsgId: msg_id,
-				Seq:   int64(existing.Seq),
-			}, nil
+			s.logger.InfoContext(ctx, "重复消息，返回幂等结果", "user", userID, "msg_id", msg_id, "conversation_id", packet.ConversationId)
+			return s.replayStored(ctx, existing)
 		} else {
-			return model.OutputPacket{Cmd: model.CmdChat, Code: 1, MsgId: msg_id}, err
+			return model.OutputPacket{Cmd: model.CmdChat, MsgId: msg_id}.WithError(ErrorCode(err), "消息发送失败!"), err
+		}
+	}
+	if s.publisher != nil {
+		// 推送是最佳努力：消息已落库，失败的成员可通过拉取补齐
+		if pubErr := s.publisher.Publish(ctx, msg, origin); pubErr != nil {
+			s.logger.WarnContext(ctx, "推送消息失败", "msg_id", msg_id, "conversation_id", msg.ConversationID, "err", pubErr)
 		}
 	}
 	return model.OutputPacket{
-		Cmd:   model.CmdChat,
-		Code:  0,
-		MsgId: msg_id,
-		Seq:   int64(msg.Seq),
+		Cmd:            model.CmdChat,
+		Code:           model.CodeOK,
+		MsgId:          msg_id,
+		ConversationId: msg.ConversationID,
+		Seq:            int64(msg.Seq),
+		Payload:        model.NewChatMessage(msg),
 	}, nil
 }
+
+// replayStored 为重发构造回包：按去重记录的 (conversation_id, seq) 读出已存消息，回包与首次写入一致，
+// 之后被撤回或编辑的消息按当前状态下发。消息已被归档删除时只返回 seq。
+func (s *MessageService) replayStored(ctx context.Context, dedup *model.MessageDedup) (model.OutputPacket, error) {
+	out := model.OutputPacket{
+		Cmd:            model.CmdChat,
+		Code:           model.CodeOK,
+		MsgId:          dedup.MsgID,
+		ConversationId: dedup.ConversationID,
+		Seq:            int64(dedup.Seq),
+	}
+	found, err := s.msgRepo.FindMessages(ctx, []model.MessageRef{{ConversationID: dedup.ConversationID, Seq: int64(dedup.Seq)}})
+	if err != nil {
+		return model.OutputPacket{Cmd: model.CmdChat, MsgId: dedup.MsgID}.WithError(ErrorCode(err), "消息发送失败!"), err
+	}
+	if len(found) > 0 {
+		out.Payload = model.NewChatMessage(&found[0])
+	}
+	return out, nil
+}
@@ -2,37 +2,60 @@ package service_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"os"
+	"reflect"
 	"testing"
 	"time"
 
+	"go-im/internal/config"
 	"go-im/internal/model"
 	"go-im/internal/repository"
 	"go-im/internal/service"
 
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"gorm.io/gorm"
 )
 
-func newServiceWithMySQL(t *testing.T) (*service.MessageService, *gorm.DB) {
+// openTestDB 默认使用 SQLite 内存库，无需外部服务；设置 IM_DB_DRIVER 时按正式配置（可用 IM_CONFIG 指定文件）连接数据库。
+func openTestDB(t *testing.T) *gorm.DB {
 	t.Helper()
-	db, err := repository.NewDB()
+	cfg := config.Default().Storage
+	cfg.Driver, cfg.SQLitePath = repository.DriverSQLite, ":memory:"
+	if os.Getenv("IM_DB_DRIVER") != "" {
+		loaded, err := config.Load(os.Getenv("IM_CONFIG"))
+		if err != nil {
+			t.Fatalf("failed to load config: %v", err)
+		}
+		cfg = loaded.Storage
+	}
+	db, err := repository.OpenConfig(cfg, nil)
 	if err != nil {
 		t.Fatalf("failed to init db: %v", err)
 	}
-	if err := db.AutoMigrate(&model.TimelineMessage{}); err != nil {
+	if err := repository.MigrateLatest(context.Background(), db); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
+	return db
+}
+
+func newServiceWithDB(t *testing.T) (*service.MessageService, *gorm.DB) {
+	t.Helper()
+	db := openTestDB(t)
 	repo := repository.NewMessageRepository(db)
-	return service.NewMessageService(repo), db
+	return service.NewMessageService(repo, nil, nil, nil), db
 }
 
 func TestHandleChatFillsDefaultsAndPersists(t *testing.T) {
-	svc, db := newServiceWithMySQL(t)
+	svc, db := newServiceWithDB(t)
 	ctx := context.Background()
 
 	packet := model.InputPacket{Cmd: model.CmdChat, ConversationId: uniqueID("conv-defaults")}
 	payload := service.ChatPayload{Content: "hi"} // msg_type 缺省
-	out, err := svc.HandleChat(ctx, "u1", packet, payload)
+	out, err := svc.HandleChat(ctx, "u1", nil, packet, payload)
 	if err != nil {
 		t.Fatalf("HandleChat returned error: %v", err)
 	}
@@ -62,13 +85,13 @@ func TestHandleChatFillsDefaultsAndPersists(t *testing.T) {
 }
 
 func TestHandleChatIdempotentMsgID(t *testing.T) {
-	svc, db := newServiceWithMySQL(t)
+	svc, db := newServiceWithDB(t)
 	ctx := context.Background()
 
 	packet := model.InputPacket{Cmd: model.CmdChat, ConversationId: uniqueID("conv-idem"), MsgId: uniqueID("fixed-id")}
 	payload := service.ChatPayload{Content: "hello", MsgType: 1}
 
-	out1, err := svc.HandleChat(ctx, "u1", packet, payload)
+	out1, err := svc.HandleChat(ctx, "u1", nil, packet, payload)
 	if err != nil {
 		t.Fatalf("HandleChat first error: %v", err)
 	}
@@ -76,7 +99,7 @@ func TestHandleChatIdempotentMsgID(t *testing.T) {
 		t.Fatalf("expected Code=0 first call, got %d", out1.Code)
 	}
 
-	out2, err := svc.HandleChat(ctx, "u1", packet, payload)
+	out2, err := svc.HandleChat(ctx, "u1", nil, packet, payload)
 	if err != nil {
 		t.Fatalf("HandleChat second error: %v", err)
 	}
@@ -99,6 +122,211 @@ func TestHandleChatIdempotentMsgID(t *testing.T) {
 	}
 }
 
+func TestHandleChatReplyCarriesWireMessage(t *testing.T) {
+	svc, _ := newServiceWithDB(t)
+	ctx := context.Background()
+
+	packet := model.InputPacket{Cmd: model.CmdChat, ConversationId: uniqueID("conv-wire"), MsgId: uniqueID("wire")}
+	out, err := svc.HandleChat(ctx, "u1", nil, packet, service.ChatPayload{Content: "hi", MsgType: 2})
+	if err != nil {
+		t.Fatalf("HandleChat returned error: %v", err)
+	}
+	msg, ok := out.Payload.(model.ChatMessage)
+	if !ok {
+		t.Fatalf("expected ChatMessage payload, got %T", out.Payload)
+	}
+	if msg.MsgId != packet.MsgId || msg.ConversationId != packet.ConversationId || msg.Seq != out.Seq ||
+		msg.SenderId != "u1" || msg.MsgType != 2 || msg.Content != "hi" || msg.State != model.StateNormal || msg.ServerTime == 0 {
+		t.Fatalf("unexpected reply message: %+v", msg)
+	}
+
+	raw, err := json.Marshal(out)
+	if err != nil {
+		t.Fatalf("marshal reply: %v", err)
+	}
+	var wire struct {
+		Payload map[string]any `json:"payload"`
+	}
+	if err := json.Unmarshal(raw, &wire); err != nil {
+		t.Fatalf("unmarshal reply: %v", err)
+	}
+	for _, key := range []string{"msg_id", "conversation_id", "seq", "sender_id", "msg_type", "content", "server_time", "state"} {
+		if _, ok := wire.Payload[key]; !ok {
+			t.Fatalf("payload missing %q: %s", key, raw)
+		}
+	}
+	for _, key := range []string{"ID", "CreatedAt", "SendTime"} {
+		if _, ok := wire.Payload[key]; ok {
+			t.Fatalf("payload leaks storage field %q: %s", key, raw)
+		}
+	}
+
+	again, err := svc.HandleChat(ctx, "u1", nil, packet, service.ChatPayload{Content: "hi", MsgType: 2})
+	if err != nil {
+		t.Fatalf("HandleChat retry returned error: %v", err)
+	}
+	dup, ok := again.Payload.(model.ChatMessage)
+	if !ok || dup.Seq != msg.Seq || dup.ServerTime == 0 {
+		t.Fatalf("idempotent reply should carry the stored message, got %+v", again.Payload)
+	}
+}
+
+func TestHandleChatRetryRepliesWithStoredMessage(t *testing.T) {
+	svc, db := newServiceWithDB(t)
+	ctx := context.Background()
+
+	packet := model.InputPacket{Cmd: model.CmdChat, ConversationId: uniqueID("conv-retry"), MsgId: uniqueID("retry")}
+	payload := service.ChatPayload{Content: "hi", MsgType: 2}
+	first, err := svc.HandleChat(ctx, "u1", nil, packet, payload)
+	if err != nil {
+		t.Fatalf("HandleChat returned error: %v", err)
+	}
+	// 去重记录的写入时间与消息的发送时间不同，回复仍应使用发送时间
+	if err := db.WithContext(ctx).Model(&model.MessageDedup{}).
+		Where("sender_id = ? AND msg_id = ?", "u1", packet.MsgId).
+		Update("created_at", time.Now().Add(-time.Hour)).Error; err != nil {
+		t.Fatalf("backdate dedup: %v", err)
+	}
+
+	retry, err := svc.HandleChat(ctx, "u1", nil, packet, payload)
+	if err != nil {
+		t.Fatalf("HandleChat retry returned error: %v", err)
+	}
+	if !reflect.DeepEqual(first, retry) {
+		t.Fatalf("retry reply differs from the first reply:\nfirst %+v\nretry %+v", first, retry)
+	}
+}
+
+func TestHandleChatRetryReflectsStoredState(t *testing.T) {
+	svc, db := newServiceWithDB(t)
+	ctx := context.Background()
+
+	packet := model.InputPacket{Cmd: model.CmdChat, ConversationId: uniqueID("conv-replay"), MsgId: uniqueID("replay")}
+	if _, err := svc.HandleChat(ctx, "u1", nil, packet, service.ChatPayload{Content: "stored", MsgType: 1}); err != nil {
+		t.Fatalf("HandleChat returned error: %v", err)
+	}
+	// 迁移回填的记录没有内容摘要，任何内容的重发都会被接受，回包仍应是库里的消息
+	if err := db.WithContext(ctx).Model(&model.MessageDedup{}).
+		Where("sender_id = ? AND msg_id = ?", "u1", packet.MsgId).
+		Update("content_hash", "").Error; err != nil {
+		t.Fatalf("clear content hash: %v", err)
+	}
+	retry, err := svc.HandleChat(ctx, "u1", nil, packet, service.ChatPayload{Content: "echoed", MsgType: 2})
+	if err != nil {
+		t.Fatalf("HandleChat retry returned error: %v", err)
+	}
+	if msg, ok := retry.Payload.(model.ChatMessage); !ok || msg.Content != "stored" || msg.MsgType != 1 {
+		t.Fatalf("retry should reply with the stored message, got %+v", retry.Payload)
+	}
+
+	if err := db.WithContext(ctx).Model(&model.TimelineMessage{}).
+		Where("conversation_id = ? AND seq = ?", packet.ConversationId, retry.Seq).
+		Update("status", model.MsgStatusRecalled).Error; err != nil {
+		t.Fatalf("recall message: %v", err)
+	}
+	retry, err = svc.HandleChat(ctx, "u1", nil, packet, service.ChatPayload{Content: "stored", MsgType: 1})
+	if err != nil {
+		t.Fatalf("HandleChat retry returned error: %v", err)
+	}
+	if msg, ok := retry.Payload.(model.ChatMessage); !ok || msg.State != model.StateRecalled || msg.Content != "" {
+		t.Fatalf("retry of a recalled message should reply recalled, got %+v", retry.Payload)
+	}
+}
+
+func TestNewChatMessageHidesRecalledContent(t *testing.T) {
+	cases := []struct {
+		status  int8
+		state   model.MessageState
+		content string
+	}{
+		{0, model.StateNormal, "hi"},
+		{model.MsgStatusNormal, model.StateNormal, "hi"},
+		{model.MsgStatusEdited, model.StateEdited, "hi"},
+		{model.MsgStatusRecalled, model.StateRecalled, ""},
+	}
+	for _, c := range cases {
+		got := model.NewChatMessage(&model.TimelineMessage{MsgID: "m", Content: "hi", Status: c.status, SendTime: 42})
+		if got.State != c.state || got.Content != c.content || got.ServerTime != 42 {
+			t.Fatalf("status %d: unexpected %+v", c.status, got)
+		}
+	}
+}
+
+func TestHandleChatScopesMsgIDToSender(t *testing.T) {
+	svc, db := newServiceWithDB(t)
+	ctx := context.Background()
+
+	conv := uniqueID("conv-scope")
+	msgID := uniqueID("shared-id")
+	packet := model.InputPacket{Cmd: model.CmdChat, ConversationId: conv, MsgId: msgID}
+	first, err := svc.HandleChat(ctx, "u1", nil, packet, service.ChatPayload{Content: "from u1"})
+	if err != nil || first.Code != model.CodeOK {
+		t.Fatalf("first send: %v %d", err, first.Code)
+	}
+
+	// 其他用户使用相同的 msg_id 是一条新消息，不会拿到 u1 消息的 seq
+	other, err := svc.HandleChat(ctx, "u2", nil, packet, service.ChatPayload{Content: "from u2"})
+	if err != nil || other.Code != model.CodeOK {
+		t.Fatalf("other sender: %v %d", err, other.Code)
+	}
+	if other.Seq == first.Seq {
+		t.Fatalf("other sender must get its own seq, got %d for both", other.Seq)
+	}
+
+	// 同一发送方以相同 msg_id 发出不同内容或发往其他会话，明确报冲突
+	conflicts := []struct {
+		name    string
+		packet  model.InputPacket
+		payload service.ChatPayload
+	}{
+		{"different content", packet, service.ChatPayload{Content: "edited"}},
+		{"different type", packet, service.ChatPayload{Content: "from u1", MsgType: 2}},
+		{"different conversation", model.InputPacket{Cmd: model.CmdChat, ConversationId: uniqueID("conv-other"), MsgId: msgID}, service.ChatPayload{Content: "from u1"}},
+	}
+	for _, c := range conflicts {
+		out, err := svc.HandleChat(ctx, "u1", nil, c.packet, c.payload)
+		if err != nil {
+			t.Fatalf("%s: conflict is a client error, got %v", c.name, err)
+		}
+		if out.Code != model.CodeDuplicate || out.Seq != 0 {
+			t.Fatalf("%s: expected CodeDuplicate without seq, got code=%d seq=%d", c.name, out.Code, out.Seq)
+		}
+	}
+
+	var count int64
+	if err := db.WithContext(ctx).Model(&model.TimelineMessage{}).Where("msg_id = ?", msgID).Count(&count).Error; err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected one row per sender, got %d", count)
+	}
+}
+
+func TestDedupWindowExpires(t *testing.T) {
+	store := repository.NewMemoryStore()
+	svc := service.NewMessageService(store, nil, nil, nil)
+	janitor := service.NewDedupJanitor(store, time.Hour, nil)
+	ctx := context.Background()
+
+	packet := model.InputPacket{Cmd: model.CmdChat, ConversationId: "c1", MsgId: "m1"}
+	first, _ := svc.HandleChat(ctx, "u1", nil, packet, service.ChatPayload{Content: "hi"})
+
+	if n, err := janitor.Purge(ctx, time.Now()); err != nil || n != 0 {
+		t.Fatalf("records inside the window must be kept, purged %d (%v)", n, err)
+	}
+	if again, _ := svc.HandleChat(ctx, "u1", nil, packet, service.ChatPayload{Content: "hi"}); again.Seq != first.Seq {
+		t.Fatalf("resend inside the window should be deduplicated, got seq %d want %d", again.Seq, first.Seq)
+	}
+
+	if n, err := janitor.Purge(ctx, time.Now().Add(2*time.Hour)); err != nil || n != 1 {
+		t.Fatalf("expired record should be purged, purged %d (%v)", n, err)
+	}
+	late, _ := svc.HandleChat(ctx, "u1", nil, packet, service.ChatPayload{Content: "hi"})
+	if late.Code != model.CodeOK || late.Seq == first.Seq {
+		t.Fatalf("msg_id outside the window is a new message, got code=%d seq=%d", late.Code, late.Seq)
+	}
+}
+
 type errorRepo struct {
 	err error
 }
@@ -106,23 +334,81 @@ type errorRepo struct {
 func (e errorRepo) SaveMessage(ctx context.Context, msg *model.TimelineMessage) error {
 	return e.err
 }
-func (e errorRepo) FindByMsgID(ctx context.Context, msgID string) (*model.TimelineMessage, error) {
+func (e errorRepo) FindDedup(ctx context.Context, senderID, msgID string) (*model.MessageDedup, error) {
 	return nil, gorm.ErrRecordNotFound
 }
+func (e errorRepo) FindMessages(ctx context.Context, refs []model.MessageRef) ([]model.TimelineMessage, error) {
+	return nil, nil
+}
 
 func TestHandleChatPropagatesRepoError(t *testing.T) {
 	repoErr := errors.New("db down")
-	svc := service.NewMessageService(errorRepo{err: repoErr})
+	svc := service.NewMessageService(errorRepo{err: repoErr}, nil, nil, nil)
 	packet := model.InputPacket{Cmd: model.CmdChat, ConversationId: "conv-error", MsgId: "x"}
 	payload := service.ChatPayload{Content: "msg", MsgType: 1}
 
-	_, err := svc.HandleChat(context.Background(), "u1", packet, payload)
+	out, err := svc.HandleChat(context.Background(), "u1", nil, packet, payload)
 	if !errors.Is(err, repoErr) {
 		t.Fatalf("expected repo error to propagate, got %v", err)
 	}
+	if out.Code != model.CodeInternal || out.Reason != "internal" || out.Retryable {
+		t.Fatalf("unexpected error packet: %+v", out)
+	}
+
+	// 超时视为暂时不可用，客户端可按 msg_id 原样重发
+	svc = service.NewMessageService(errorRepo{err: context.DeadlineExceeded}, nil, nil, nil)
+	out, _ = svc.HandleChat(context.Background(), "u1", nil, packet, payload)
+	if out.Code != model.CodeUnavailable || out.Reason != "unavailable" || !out.Retryable {
+		t.Fatalf("unexpected timeout packet: %+v", out)
+	}
 }
 
 // helper 生成唯一 ID，避免测试间冲突
 func uniqueID(prefix string) string {
 	return prefix + "-" + time.Now().Format("20060102-150405.000000000")
 }
+
+func TestHandleChatTraceCoversWriteFanoutAndRedelivery(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	conv := uniqueID("conv-trace")
+	lookup := &stubConnLookup{conns: map[string]*stubConn{"u2": {}}}
+	tracker := service.NewDeliveryTracker(nil, time.Second, 1)
+	push := service.NewPushService(lookup, tracker, nil)
+	fanout := service.NewFanout(stubMembers{conv: {"u1", "u2"}}, push)
+	svc := service.NewMessageService(repository.NewMessageRepository(openTestDB(t)), nil, fanout, nil)
+
+	packet := model.InputPacket{Cmd: model.CmdChat, ConversationId: conv}
+	if _, err := svc.HandleChat(context.Background(), "u1", nil, packet, service.ChatPayload{Content: "hi"}); err != nil {
+		t.Fatalf("HandleChat returned error: %v", err)
+	}
+	tracker.Sweep(time.Now().Add(2 * time.Second)) // 未回执，触发一次重投
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	root, ok := spans["MessageService.HandleChat"]
+	if !ok {
+		t.Fatalf("missing HandleChat span, got %v", spans)
+	}
+	parents := map[string]string{
+		"MessageRepository.SaveMessage": "MessageService.HandleChat",
+		"Fanout.Publish":                "MessageService.HandleChat",
+		"PushService.write":             "Fanout.Publish",
+		"DeliveryTracker.redeliver":     "Fanout.Publish",
+	}
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("missing span %s", name)
+		}
+		if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Fatalf("span %s is not in the HandleChat trace", name)
+		}
+		if span.Parent().SpanID() != spans[parent].SpanContext().SpanID() {
+			t.Fatalf("span %s should be a child of %s", name, parent)
+		}
+	}
+}
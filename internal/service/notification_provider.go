@@ -0,0 +1,76 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// WebhookProvider 以 JSON POST 的方式把通知投递到一个 HTTP 地址，用于本地联调。
+type WebhookProvider struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookProvider(url string) *WebhookProvider {
+	return &WebhookProvider{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Send 投递通知，非 2xx 响应视为失败。
+func (p *WebhookProvider) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// 把链路上下文带给下游推送网关
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FileSinkProvider 把通知逐行追加到 JSONL 文件，便于测试时断言。
+type FileSinkProvider struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileSinkProvider(path string) *FileSinkProvider {
+	return &FileSinkProvider{path: path}
+}
+
+// Send 追加一行 JSON。
+func (p *FileSinkProvider) Send(ctx context.Context, n Notification) error {
+	line, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, err := os.OpenFile(p.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
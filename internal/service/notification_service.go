@@ -0,0 +1,277 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-im/internal/model"
+	"go-im/internal/tracing"
+)
+
+// Notification 是发给某台设备的一条离线通知，可能合并了同一会话的多条消息。
+type Notification struct {
+	UserID         string `json:"user_id"`
+	Platform       string `json:"platform"`
+	DeviceToken    string `json:"device_token"`
+	ConversationID string `json:"conversation_id"`
+	Title          string `json:"title"`
+	Body           string `json:"body"`
+	Count          int    `json:"count"`    // 合并的消息条数，可用作角标
+	LastSeq        int64  `json:"last_seq"` // 合并窗口内最大的 seq
+}
+
+// NotificationProvider 负责把通知投递到厂商通道（APNs/FCM）或本地测试通道。
+type NotificationProvider interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// DeviceStore 提供用户设备令牌。
+type DeviceStore interface {
+	ListDevices(ctx context.Context, userID string) ([]model.DeviceToken, error)
+}
+
+// SettingsReader 查询用户对会话的个人设置（免打扰、通知级别）。
+type SettingsReader interface {
+	// ListSettings 一次查询多个用户在同一会话的设置，没有记录的用户不在结果中，按默认设置处理。
+	ListSettings(ctx context.Context, conversationID string, userIDs []string) (map[string]model.ConversationSettings, error)
+}
+
+// NotificationTemplates 定义通知标题与正文模板，模板数据见 notificationData。
+// PrivateBody 用于隐私模式，不应引用 .Content。
+type NotificationTemplates struct {
+	Title       string
+	Body        string
+	PrivateBody string
+}
+
+// DefaultNotificationTemplates 为默认的中文通知模板。
+var DefaultNotificationTemplates = NotificationTemplates{
+	Title:       "{{.ConversationID}}",
+	Body:        "{{if gt .Count 1}}[{{.Count}}条] {{end}}{{.SenderID}}: {{.Content}}",
+	PrivateBody: "你收到 {{.Count}} 条新消息",
+}
+
+type notificationData struct {
+	ConversationID string
+	SenderID       string
+	Content        string
+	Count          int
+}
+
+// notifyBatch 为合并窗口内同一用户同一会话累计的消息。
+type notifyBatch struct {
+	last  model.ChatMessage
+	count int
+	links []trace.Link // 被合并的各条推送所在链路
+}
+
+// NotificationService 为不在线的用户生成离线通知。
+// 会话收到首条离线推送后开启 collapseWindow 合并窗口，窗口内同一用户的多条消息合并为一条通知；
+// 窗口到期时一次查询该会话所有待通知用户的设置，免打扰与通知级别以发送时为准。
+type NotificationService struct {
+	devices        DeviceStore
+	settings       SettingsReader
+	provider       NotificationProvider
+	privacy        bool
+	collapseWindow time.Duration
+	title          *template.Template
+	body           *template.Template
+	privateBody    *template.Template
+	logger         *slog.Logger
+
+	mu      sync.Mutex
+	pending map[string]map[string]*notifyBatch // 会话 ID -> 用户 ID -> 合并中的通知
+}
+
+// NewNotificationService 创建离线通知服务，privacy 为 true 时通知正文不包含消息内容。
+// logger 为 nil 时使用 slog.Default()。
+func NewNotificationService(devices DeviceStore, settings SettingsReader, provider NotificationProvider, tmpl NotificationTemplates, privacy bool, collapseWindow time.Duration, logger *slog.Logger) (*NotificationService, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	title, err := template.New("title").Parse(tmpl.Title)
+	if err != nil {
+		return nil, err
+	}
+	privateBody, err := template.New("private_body").Parse(tmpl.PrivateBody)
+	if err != nil {
+		return nil, err
+	}
+	body := privateBody
+	if !privacy {
+		if body, err = template.New("body").Parse(tmpl.Body); err != nil {
+			return nil, err
+		}
+	}
+	return &NotificationService{
+		devices:        devices,
+		settings:       settings,
+		provider:       provider,
+		privacy:        privacy,
+		collapseWindow: collapseWindow,
+		title:          title,
+		body:           body,
+		privateBody:    privateBody,
+		logger:         logger,
+		pending:        make(map[string]map[string]*notifyBatch),
+	}, nil
+}
+
+// Notify 登记一条发给离线用户的推送，实现 OfflineNotifier。
+// 只处理带消息体的聊天推送；Notify 在推送路径上调用，只入队不访问存储，会话设置在窗口到期时查询。
+func (s *NotificationService) Notify(ctx context.Context, userID string, packet model.OutputPacket) {
+	msg, ok := packet.Payload.(model.ChatMessage)
+	if packet.Cmd != model.CmdChat || !ok {
+		return
+	}
+
+	link := trace.LinkFromContext(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users, ok := s.pending[msg.ConversationId]
+	if !ok {
+		users = make(map[string]*notifyBatch)
+		s.pending[msg.ConversationId] = users
+		conversationID := msg.ConversationId
+		time.AfterFunc(s.collapseWindow, func() { s.flush(conversationID) })
+	}
+	batch, ok := users[userID]
+	if !ok {
+		batch = &notifyBatch{last: msg}
+		users[userID] = batch
+	}
+	batch.count++
+	if link.SpanContext.IsValid() {
+		batch.links = append(batch.links, link)
+	}
+	if msg.Seq > batch.last.Seq {
+		batch.last = msg
+	}
+}
+
+// PendingBatches 返回合并窗口内尚未发送的通知数。
+func (s *NotificationService) PendingBatches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, users := range s.pending {
+		n += len(users)
+	}
+	return n
+}
+
+// Flush 立即发送所有未到期的合并通知，用于停机前清空。
+func (s *NotificationService) Flush() {
+	s.mu.Lock()
+	conversations := make([]string, 0, len(s.pending))
+	for id := range s.pending {
+		conversations = append(conversations, id)
+	}
+	s.mu.Unlock()
+	for _, id := range conversations {
+		s.flush(id)
+	}
+}
+
+// flush 发送会话 conversationID 合并窗口内的全部通知，免打扰或通知级别为 NotifyNone 的用户跳过。
+func (s *NotificationService) flush(conversationID string) {
+	s.mu.Lock()
+	users, ok := s.pending[conversationID]
+	delete(s.pending, conversationID)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// 合并窗口把多条推送汇成一次发送，以 link 关联各条推送的链路
+	var links []trace.Link
+	userIDs := make([]string, 0, len(users))
+	for userID, batch := range users {
+		userIDs = append(userIDs, userID)
+		links = append(links, batch.links...)
+	}
+	ctx, span := tracer.Start(ctx, "NotificationService.flush", trace.WithLinks(links...), trace.WithAttributes(
+		attribute.String("im.conversation_id", conversationID),
+		attribute.Int("im.users", len(users)),
+	))
+	defer span.End()
+
+	settings, err := s.settings.ListSettings(ctx, conversationID, userIDs)
+	if err != nil {
+		tracing.RecordError(span, err)
+		s.logger.Warn("查询会话设置失败", "conversation_id", conversationID, "users", len(userIDs), "err", err)
+		return
+	}
+	now := time.Now()
+	for userID, batch := range users {
+		st := settings[userID]
+		if st.MutedAt(now) || st.NotifyLevel == model.NotifyNone {
+			continue
+		}
+		s.send(ctx, span, userID, conversationID, batch, st.NotifyLevel == model.NotifyNoPreview)
+	}
+}
+
+// send 把一个用户的合并通知发到其全部设备，hidePreview 为 true 时使用隐私正文。
+func (s *NotificationService) send(ctx context.Context, span trace.Span, userID, conversationID string, batch *notifyBatch, hidePreview bool) {
+	devices, err := s.devices.ListDevices(ctx, userID)
+	if err != nil {
+		s.logger.Warn("查询设备失败", "user", userID, "err", err)
+		return
+	}
+	if len(devices) == 0 {
+		return
+	}
+	title, body, err := s.render(batch, hidePreview)
+	if err != nil {
+		s.logger.Error("渲染通知失败", "user", userID, "conversation_id", conversationID, "err", err)
+		return
+	}
+	for _, d := range devices {
+		n := Notification{
+			UserID:         userID,
+			Platform:       d.Platform,
+			DeviceToken:    d.Token,
+			ConversationID: conversationID,
+			Title:          title,
+			Body:           body,
+			Count:          batch.count,
+			LastSeq:        batch.last.Seq,
+		}
+		if err := s.provider.Send(ctx, n); err != nil {
+			tracing.RecordError(span, err)
+			s.logger.Warn("发送离线通知失败", "user", userID, "conversation_id", conversationID, "platform", d.Platform, "err", err)
+		}
+	}
+}
+
+func (s *NotificationService) render(batch *notifyBatch, hidePreview bool) (string, string, error) {
+	data := notificationData{
+		ConversationID: batch.last.ConversationId,
+		SenderID:       batch.last.SenderId,
+		Count:          batch.count,
+	}
+	body := s.body
+	if hidePreview {
+		body = s.privateBody
+	} else if !s.privacy {
+		data.Content = batch.last.Content
+	}
+	var titleBuf, bodyBuf bytes.Buffer
+	if err := s.title.Execute(&titleBuf, data); err != nil {
+		return "", "", err
+	}
+	if err := body.Execute(&bodyBuf, data); err != nil {
+		return "", "", err
+	}
+	return titleBuf.String(), bodyBuf.String(), nil
+}
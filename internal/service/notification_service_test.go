@@ -0,0 +1,234 @@
+package service_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+type stubDevices map[string][]model.DeviceToken
+
+func (d stubDevices) ListDevices(ctx context.Context, userID string) ([]model.DeviceToken, error) {
+	return d[userID], nil
+}
+
+type stubSettings map[string]model.ConversationSettings
+
+func (m stubSettings) ListSettings(ctx context.Context, conversationID string, userIDs []string) (map[string]model.ConversationSettings, error) {
+	out := make(map[string]model.ConversationSettings)
+	for _, userID := range userIDs {
+		if st, ok := m[userID+"/"+conversationID]; ok {
+			out[userID] = st
+		}
+	}
+	return out, nil
+}
+
+// countingSettings 记录设置查询次数与每次查询的用户。
+type countingSettings struct {
+	stubSettings
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (c *countingSettings) ListSettings(ctx context.Context, conversationID string, userIDs []string) (map[string]model.ConversationSettings, error) {
+	c.mu.Lock()
+	c.calls = append(c.calls, userIDs)
+	c.mu.Unlock()
+	return c.stubSettings.ListSettings(ctx, conversationID, userIDs)
+}
+
+type recordingProvider struct {
+	mu   sync.Mutex
+	sent []service.Notification
+}
+
+func (p *recordingProvider) Send(ctx context.Context, n service.Notification) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, n)
+	return nil
+}
+
+func chatPush(conv, sender, content string, seq uint64) model.OutputPacket {
+	msg := model.NewChatMessage(&model.TimelineMessage{MsgID: "m", ConversationID: conv, SenderID: sender, Content: content, Seq: seq})
+	return model.OutputPacket{Cmd: model.CmdChat, ConversationId: conv, Seq: int64(seq), Payload: msg}
+}
+
+func newNotifier(t *testing.T, provider service.NotificationProvider, settings service.SettingsReader, privacy bool) *service.NotificationService {
+	t.Helper()
+	if settings == nil {
+		settings = stubSettings(nil)
+	}
+	devices := stubDevices{
+		"u2": {{UserID: "u2", Platform: service.PlatformAPNs, Token: "tok"}},
+		"u3": {{UserID: "u3", Platform: service.PlatformAPNs, Token: "tok3"}},
+	}
+	n, err := service.NewNotificationService(devices, settings, provider, service.DefaultNotificationTemplates, privacy, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("NewNotificationService error: %v", err)
+	}
+	return n
+}
+
+func TestOfflineTargetsAreNotifiedAndCollapsed(t *testing.T) {
+	provider := &recordingProvider{}
+	notifier := newNotifier(t, provider, nil, false)
+	push := service.NewPushService(&stubConnLookup{}, nil, notifier)
+
+	ctx := context.Background()
+	_ = push.Broadcast(ctx, chatPush("g1", "u1", "first", 1), []string{"u2"})
+	_ = push.Broadcast(ctx, chatPush("g1", "u1", "second", 2), []string{"u2"})
+	notifier.Flush()
+
+	if len(provider.sent) != 1 {
+		t.Fatalf("expected messages to collapse into 1 notification, got %d", len(provider.sent))
+	}
+	n := provider.sent[0]
+	if n.Count != 2 || n.LastSeq != 2 || n.DeviceToken != "tok" {
+		t.Fatalf("unexpected notification: %+v", n)
+	}
+	if n.Body != "[2条] u1: second" {
+		t.Fatalf("unexpected body: %q", n.Body)
+	}
+}
+
+func TestPrivacyModeHidesContent(t *testing.T) {
+	provider := &recordingProvider{}
+	notifier := newNotifier(t, provider, nil, true)
+
+	notifier.Notify(context.Background(), "u2", chatPush("g1", "u1", "secret", 1))
+	notifier.Flush()
+
+	if len(provider.sent) != 1 {
+		t.Fatalf("expected 1 notification, got %d", len(provider.sent))
+	}
+	if provider.sent[0].Body != "你收到 1 条新消息" {
+		t.Fatalf("privacy body should not contain content, got %q", provider.sent[0].Body)
+	}
+}
+
+func TestMutedConversationIsNotNotified(t *testing.T) {
+	provider := &recordingProvider{}
+	notifier := newNotifier(t, provider, stubSettings{"u2/g1": {Muted: true}}, false)
+
+	notifier.Notify(context.Background(), "u2", chatPush("g1", "u1", "hi", 1))
+	notifier.Flush()
+
+	if len(provider.sent) != 0 {
+		t.Fatalf("muted conversation should not notify, got %d", len(provider.sent))
+	}
+}
+
+func TestExpiredMuteNotifiesAgain(t *testing.T) {
+	provider := &recordingProvider{}
+	expired := time.Now().Add(-time.Minute).UnixMilli()
+	notifier := newNotifier(t, provider, stubSettings{"u2/g1": {Muted: true, MuteUntil: expired}}, false)
+
+	notifier.Notify(context.Background(), "u2", chatPush("g1", "u1", "hi", 1))
+	notifier.Flush()
+
+	if len(provider.sent) != 1 {
+		t.Fatalf("expired mute should notify, got %d", len(provider.sent))
+	}
+}
+
+func TestNotifyLevelControlsPreviewAndDelivery(t *testing.T) {
+	provider := &recordingProvider{}
+	notifier := newNotifier(t, provider, stubSettings{
+		"u2/g1": {NotifyLevel: model.NotifyNoPreview},
+		"u2/g2": {NotifyLevel: model.NotifyNone},
+	}, false)
+
+	notifier.Notify(context.Background(), "u2", chatPush("g1", "u1", "secret", 1))
+	notifier.Notify(context.Background(), "u2", chatPush("g2", "u1", "hi", 1))
+	notifier.Flush()
+
+	if len(provider.sent) != 1 {
+		t.Fatalf("expected only g1 to notify, got %d", len(provider.sent))
+	}
+	if provider.sent[0].Body != "你收到 1 条新消息" {
+		t.Fatalf("no-preview level should hide content, got %q", provider.sent[0].Body)
+	}
+}
+
+func TestNotifyDefersSettingsToOneQueryPerConversation(t *testing.T) {
+	provider := &recordingProvider{}
+	settings := &countingSettings{stubSettings: stubSettings{}}
+	notifier := newNotifier(t, provider, settings, false)
+
+	ctx := context.Background()
+	notifier.Notify(ctx, "u2", chatPush("g1", "u1", "hi", 1))
+	notifier.Notify(ctx, "u3", chatPush("g1", "u1", "hi", 1))
+	notifier.Notify(ctx, "u2", chatPush("g1", "u1", "again", 2))
+	if len(settings.calls) != 0 {
+		t.Fatalf("Notify should not read settings, got %d queries", len(settings.calls))
+	}
+	if n := notifier.PendingBatches(); n != 2 {
+		t.Fatalf("expected 2 pending batches, got %d", n)
+	}
+	notifier.Flush()
+
+	if len(settings.calls) != 1 || len(settings.calls[0]) != 2 {
+		t.Fatalf("expected one settings query for both users, got %v", settings.calls)
+	}
+	if len(provider.sent) != 2 {
+		t.Fatalf("expected a notification per user, got %d", len(provider.sent))
+	}
+}
+
+func TestSettingsAreReadWhenTheBatchFlushes(t *testing.T) {
+	provider := &recordingProvider{}
+	settings := stubSettings{}
+	notifier := newNotifier(t, provider, settings, false)
+
+	notifier.Notify(context.Background(), "u2", chatPush("g1", "u1", "secret", 1))
+	notifier.Notify(context.Background(), "u3", chatPush("g1", "u1", "secret", 1))
+	// 窗口内修改的设置在发送时生效
+	settings["u2/g1"] = model.ConversationSettings{Muted: true}
+	settings["u3/g1"] = model.ConversationSettings{NotifyLevel: model.NotifyNoPreview}
+	notifier.Flush()
+
+	if len(provider.sent) != 1 || provider.sent[0].UserID != "u3" {
+		t.Fatalf("muting inside the window should suppress u2, got %+v", provider.sent)
+	}
+	if provider.sent[0].Body != "你收到 1 条新消息" {
+		t.Fatalf("no-preview set inside the window should hide content, got %q", provider.sent[0].Body)
+	}
+}
+
+func TestFileSinkProviderAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.jsonl")
+	sink := service.NewFileSinkProvider(path)
+	for i := 1; i <= 2; i++ {
+		if err := sink.Send(context.Background(), service.Notification{UserID: "u2", Count: i}); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open sink: %v", err)
+	}
+	defer f.Close()
+	var lines []service.Notification
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var n service.Notification
+		if err := json.Unmarshal(scanner.Bytes(), &n); err != nil {
+			t.Fatalf("decode line: %v", err)
+		}
+		lines = append(lines, n)
+	}
+	if len(lines) != 2 || lines[1].Count != 2 {
+		t.Fatalf("unexpected sink contents: %+v", lines)
+	}
+}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"errors"
 
+	"go-im/internal/metrics"
 	"go-im/internal/model"
 )
 
@@ -11,26 +13,91 @@ type PullResult struct {
 	Messages      []model.TimelineMessage
 	NextCursorSeq int64
 	HasMore       bool
+	ArchivedSeq   int64 // 游标早于归档位点时为该位点，<= 它的消息已归档，拉取从其后开始
 }
 
 // PullStorage 抽象仓储接口，便于测试替换。
 type PullStorage interface {
 	ListMessages(ctx context.Context, conversationID string, afterSeq int64, limit int) ([]model.TimelineMessage, error)
+	ListMessagesBatch(ctx context.Context, cursors []model.PullCursor, limit int) (map[string][]model.TimelineMessage, error)
 	UpsertAck(ctx context.Context, userID, conversationID string, ackSeq int64) error
+	GetClearedSeq(ctx context.Context, userID, conversationID string) (int64, error)
+	GetClearedSeqs(ctx context.Context, userID string, conversationIDs []string) (map[string]int64, error)
+	GetArchivedSeqs(ctx context.Context, conversationIDs []string) (map[string]int64, error)
 }
 
+// HiddenFilter 提供用户“仅对自己删除”的消息集合，消息以会话内 seq 标识。
+type HiddenFilter interface {
+	ListHiddenSeqs(ctx context.Context, userID, conversationID string, fromSeq, toSeq int64) ([]int64, error)
+	ListHiddenInRanges(ctx context.Context, userID string, ranges []model.SeqRange) (map[string][]int64, error)
+}
+
+// ErrTooManyConversations 表示批量拉取的会话数超过上限。
+var ErrTooManyConversations = errors.New("too many conversations in one request")
+
+// 未配置时的默认值。
+const (
+	defaultPageSize              = 50
+	defaultBatchMaxConversations = 200
+	defaultBatchMaxMessages      = 1000
+)
+
 type PullService struct {
-	store PullStorage
+	store    PullStorage
+	hidden   HiddenFilter      // 可为 nil，此时不过滤隐藏消息
+	members  MembershipChecker // 可为 nil，此时不校验拉取者是否为会话成员
+	pageSize int               // 调用方未指定 limit 时的单页条数，也是批量拉取中单个会话的上限
+
+	batchMaxConversations int // 批量拉取单次最多的会话数
+	batchMaxMessages      int // 批量拉取单次最多返回的消息数
 }
 
-func NewPullService(store PullStorage) *PullService {
-	return &PullService{store: store}
+// NewPullService 创建拉取服务，pageSize、batchMaxConversations 与 batchMaxMessages 不大于 0 时使用默认值。
+func NewPullService(store PullStorage, hidden HiddenFilter, members MembershipChecker, pageSize, batchMaxConversations, batchMaxMessages int) *PullService {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if batchMaxConversations <= 0 {
+		batchMaxConversations = defaultBatchMaxConversations
+	}
+	if batchMaxMessages <= 0 {
+		batchMaxMessages = defaultBatchMaxMessages
+	}
+	return &PullService{
+		store:                 store,
+		hidden:                hidden,
+		members:               members,
+		pageSize:              pageSize,
+		batchMaxConversations: batchMaxConversations,
+		batchMaxMessages:      batchMaxMessages,
+	}
 }
 
-// PullMessages 按会话内 seq 拉取消息，返回游标信息。
-func (s *PullService) PullMessages(ctx context.Context, conversationID string, cursorSeq int64, limit int) (PullResult, error) {
+// PullMessages 按会话内 seq 拉取 userID 可见的消息，返回游标信息；userID 不是会话成员时返回 ErrNotMember。
+// 用户清空过历史时，游标至少从清空水位开始，更早的消息对该用户不可见；
+// 游标早于会话的归档位点时从位点之后开始，并在结果中返回 ArchivedSeq；
+// 用户隐藏的消息从结果中剔除，但游标仍按原始页推进，因此一页可能少于 limit 条。
+func (s *PullService) PullMessages(ctx context.Context, userID, conversationID string, cursorSeq int64, limit int) (PullResult, error) {
 	if limit <= 0 {
-		limit = 50
+		limit = s.pageSize
+	}
+	if err := requireMember(ctx, s.members, userID, conversationID); err != nil {
+		return PullResult{}, err
+	}
+	cleared, err := s.store.GetClearedSeq(ctx, userID, conversationID)
+	if err != nil {
+		return PullResult{}, err
+	}
+	if cursorSeq < cleared {
+		cursorSeq = cleared
+	}
+	archived, err := s.store.GetArchivedSeqs(ctx, []string{conversationID})
+	if err != nil {
+		return PullResult{}, err
+	}
+	var archivedSeq int64
+	if seq := archived[conversationID]; cursorSeq < seq {
+		cursorSeq, archivedSeq = seq, seq
 	}
 	// 多查一条用于判断是否还有更多
 	msgs, err := s.store.ListMessages(ctx, conversationID, cursorSeq, limit+1)
@@ -38,7 +105,8 @@ func (s *PullService) PullMessages(ctx context.Context, conversationID string, c
 		return PullResult{}, err
 	}
 	if len(msgs) == 0 {
-		return PullResult{NextCursorSeq: cursorSeq, Messages: msgs, HasMore: false}, nil
+		metrics.PullPageSize.Observe(0)
+		return PullResult{NextCursorSeq: cursorSeq, Messages: msgs, HasMore: false, ArchivedSeq: archivedSeq}, nil
 	}
 	hasMore := len(msgs) > limit
 	if hasMore {
@@ -46,13 +114,165 @@ func (s *PullService) PullMessages(ctx context.Context, conversationID string, c
 	}
 	next := int64(msgs[len(msgs)-1].Seq)
 
+	msgs, err = s.filterHidden(ctx, userID, conversationID, cursorSeq, next, msgs)
+	if err != nil {
+		return PullResult{}, err
+	}
+	metrics.PullPageSize.Observe(float64(len(msgs)))
+
 	return PullResult{
 		Messages:      msgs,
 		NextCursorSeq: next,
 		HasMore:       hasMore,
+		ArchivedSeq:   archivedSeq,
 	}, nil
 }
 
+// filterHidden 剔除用户在 (fromSeq, toSeq] 内隐藏的消息。
+func (s *PullService) filterHidden(ctx context.Context, userID, conversationID string, fromSeq, toSeq int64, msgs []model.TimelineMessage) ([]model.TimelineMessage, error) {
+	if s.hidden == nil {
+		return msgs, nil
+	}
+	seqs, err := s.hidden.ListHiddenSeqs(ctx, userID, conversationID, fromSeq, toSeq)
+	if err != nil {
+		return msgs, err
+	}
+	return dropHidden(msgs, seqs), nil
+}
+
+// dropHidden 原地剔除 seq 在 seqs 中的消息，msgs 属于同一会话。
+func dropHidden(msgs []model.TimelineMessage, seqs []int64) []model.TimelineMessage {
+	if len(seqs) == 0 {
+		return msgs
+	}
+	hidden := make(map[uint64]struct{}, len(seqs))
+	for _, seq := range seqs {
+		hidden[uint64(seq)] = struct{}{}
+	}
+	visible := msgs[:0]
+	for _, m := range msgs {
+		if _, ok := hidden[m.Seq]; !ok {
+			visible = append(visible, m)
+		}
+	}
+	return visible
+}
+
+// BatchPull 一次拉取多个会话，每个会话的语义与 PullMessages 一致：游标从清空水位与归档位点开始，
+// 隐藏的消息被剔除但游标照常推进。limit 为单个会话的条数，totalLimit 为整批的条数，均不超过服务端配置。
+// 条数预算按请求顺序分配，预算用尽的会话返回空页、游标不变且 HasMore 为 true，客户端下一批继续拉取。
+// userID 不是成员的会话不拉取，其页的 Code 为 CodeNotMember、游标不变。
+// 查询次数与会话数近似无关：成员关系、清空水位、归档位点与隐藏消息各一次，消息按仓储的分批 UNION 查询。
+func (s *PullService) BatchPull(ctx context.Context, userID string, cursors []model.PullCursor, limit, totalLimit int) ([]model.PullPage, error) {
+	// 同一会话重复出现时以第一次为准
+	seen := make(map[string]bool, len(cursors))
+	unique := make([]model.PullCursor, 0, len(cursors))
+	for _, c := range cursors {
+		if !seen[c.ConversationId] {
+			seen[c.ConversationId] = true
+			unique = append(unique, c)
+		}
+	}
+	if len(unique) > s.batchMaxConversations {
+		return nil, ErrTooManyConversations
+	}
+	if len(unique) == 0 {
+		return []model.PullPage{}, nil
+	}
+	if limit <= 0 || limit > s.pageSize {
+		limit = s.pageSize
+	}
+	if totalLimit <= 0 || totalLimit > s.batchMaxMessages {
+		totalLimit = s.batchMaxMessages
+	}
+
+	ids := make([]string, len(unique))
+	for i, c := range unique {
+		ids[i] = c.ConversationId
+	}
+	member, err := memberSet(ctx, s.members, userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	readable := make([]model.PullCursor, 0, len(unique))
+	for _, c := range unique {
+		if member[c.ConversationId] {
+			readable = append(readable, c)
+		}
+	}
+	archivedAt := make([]int64, len(readable))
+	var found map[string][]model.TimelineMessage
+	if len(readable) > 0 {
+		ids = ids[:0]
+		for _, c := range readable {
+			ids = append(ids, c.ConversationId)
+		}
+		cleared, err := s.store.GetClearedSeqs(ctx, userID, ids)
+		if err != nil {
+			return nil, err
+		}
+		archived, err := s.store.GetArchivedSeqs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for i := range readable {
+			readable[i].CursorSeq = max(readable[i].CursorSeq, cleared[readable[i].ConversationId])
+			if seq := archived[readable[i].ConversationId]; readable[i].CursorSeq < seq {
+				readable[i].CursorSeq, archivedAt[i] = seq, seq
+			}
+		}
+		// 多查一条用于判断是否还有更多
+		if found, err = s.store.ListMessagesBatch(ctx, readable, min(limit, totalLimit)+1); err != nil {
+			return nil, err
+		}
+	}
+
+	pages := make([]model.PullPage, len(unique))
+	taken := make([][]model.TimelineMessage, len(unique))
+	var ranges []model.SeqRange
+	remaining := totalLimit
+	next := 0
+	for i, c := range unique {
+		if !member[c.ConversationId] {
+			code := ErrorCode(ErrNotMember)
+			pages[i] = model.PullPage{ConversationId: c.ConversationId, NextCursorSeq: c.CursorSeq, Code: code, Reason: code.Reason()}
+			continue
+		}
+		c, archivedSeq := readable[next], archivedAt[next]
+		next++
+		msgs := found[c.ConversationId]
+		take := min(limit, remaining, len(msgs))
+		taken[i] = msgs[:take]
+		page := model.PullPage{
+			ConversationId: c.ConversationId,
+			NextCursorSeq:  c.CursorSeq,
+			HasMore:        len(msgs) > take,
+			ArchivedSeq:    archivedSeq,
+		}
+		if take > 0 {
+			page.NextCursorSeq = int64(msgs[take-1].Seq)
+			ranges = append(ranges, model.SeqRange{ConversationID: c.ConversationId, FromSeq: c.CursorSeq, ToSeq: page.NextCursorSeq})
+		}
+		remaining -= take
+		pages[i] = page
+	}
+
+	if s.hidden != nil && len(ranges) > 0 {
+		hidden, err := s.hidden.ListHiddenInRanges(ctx, userID, ranges)
+		if err != nil {
+			return nil, err
+		}
+		for i := range pages {
+			taken[i] = dropHidden(taken[i], hidden[pages[i].ConversationId])
+		}
+	}
+	for i := range pages {
+		pages[i].Messages = model.NewChatMessages(taken[i])
+		metrics.PullPageSize.Observe(float64(len(pages[i].Messages)))
+	}
+	return pages, nil
+}
+
 // AckConversation 更新用户在会话的 last_ack_seq。
 func (s *PullService) AckConversation(ctx context.Context, userID, conversationID string, ackSeq int64) error {
 	// TODO: 调用 store.UpsertAck，并确保 ackSeq 回退不覆盖已有较大值（可在仓储或此处处理）
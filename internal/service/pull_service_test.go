@@ -2,6 +2,7 @@ package service_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -14,18 +15,8 @@ import (
 
 func newPullService(t *testing.T) (*service.PullService, *repository.PullRepository) {
 	t.Helper()
-	db, err := repository.NewDB()
-	if err != nil {
-		t.Fatalf("init db: %v", err)
-	}
-	if err := db.AutoMigrate(&model.TimelineMessage{}); err != nil {
-		t.Fatalf("migrate timeline_message: %v", err)
-	}
-	if err := db.AutoMigrate(&model.UserConversationState{}); err != nil {
-		t.Fatalf("migrate user_conversation_state: %v", err)
-	}
-	repo := repository.NewPullRepository(db)
-	return service.NewPullService(repo), repo
+	repo := repository.NewPullRepository(openTestDB(t))
+	return service.NewPullService(repo, nil, nil, 0, 0, 0), repo
 }
 
 func seedMessages(t *testing.T, db *gorm.DB, conv string, seqs []int64) {
@@ -53,7 +44,7 @@ func TestPullMessagesNoMore(t *testing.T) {
 	convID := uniqueID("conv1")
 	seedMessages(t, repo.DB(), convID, []int64{1, 2, 3})
 
-	res, err := svc.PullMessages(ctx, convID, 0, 50)
+	res, err := svc.PullMessages(ctx, "u1", convID, 0, 50)
 	if err != nil {
 		t.Fatalf("PullMessages error: %v", err)
 	}
@@ -75,7 +66,7 @@ func TestPullMessagesWithMore(t *testing.T) {
 	convID := uniqueID("conv2")
 	seedMessages(t, repo.DB(), convID, seqs)
 
-	res, err := svc.PullMessages(ctx, convID, 5, 2)
+	res, err := svc.PullMessages(ctx, "u1", convID, 5, 2)
 	if err != nil {
 		t.Fatalf("PullMessages error: %v", err)
 	}
@@ -131,7 +122,164 @@ func TestAckConversationInsertAndUpdate(t *testing.T) {
 	}
 }
 
-// helper 生成唯一 ID，避免测试间冲突
-func uniqueID(prefix string) string {
-	return prefix + "-" + time.Now().Format("20060102-150405.000000000")
+type clearedPullStore struct {
+	cleared  int64
+	afterSeq int64
+}
+
+func (s *clearedPullStore) ListMessages(ctx context.Context, conversationID string, afterSeq int64, limit int) ([]model.TimelineMessage, error) {
+	s.afterSeq = afterSeq
+	return nil, nil
+}
+func (s *clearedPullStore) UpsertAck(ctx context.Context, userID, conversationID string, ackSeq int64) error {
+	return nil
+}
+func (s *clearedPullStore) GetClearedSeq(ctx context.Context, userID, conversationID string) (int64, error) {
+	return s.cleared, nil
+}
+func (s *clearedPullStore) ListMessagesBatch(ctx context.Context, cursors []model.PullCursor, limit int) (map[string][]model.TimelineMessage, error) {
+	return nil, nil
+}
+func (s *clearedPullStore) GetClearedSeqs(ctx context.Context, userID string, conversationIDs []string) (map[string]int64, error) {
+	return nil, nil
+}
+func (s *clearedPullStore) GetArchivedSeqs(ctx context.Context, conversationIDs []string) (map[string]int64, error) {
+	return nil, nil
+}
+
+func TestPullMessagesStartsAfterClearedSeq(t *testing.T) {
+	store := &clearedPullStore{cleared: 10}
+	svc := service.NewPullService(store, nil, nil, 0, 0, 0)
+
+	res, err := svc.PullMessages(context.Background(), "u1", "conv", 3, 20)
+	if err != nil {
+		t.Fatalf("PullMessages error: %v", err)
+	}
+	if store.afterSeq != 10 {
+		t.Fatalf("expected query after cleared seq 10, got %d", store.afterSeq)
+	}
+	if res.NextCursorSeq != 10 {
+		t.Fatalf("expected NextCursorSeq=10, got %d", res.NextCursorSeq)
+	}
+}
+
+type pagePullStore struct {
+	clearedPullStore
+	msgs []model.TimelineMessage
+}
+
+func (s *pagePullStore) ListMessages(ctx context.Context, conversationID string, afterSeq int64, limit int) ([]model.TimelineMessage, error) {
+	var out []model.TimelineMessage
+	for _, m := range s.msgs {
+		if int64(m.Seq) > afterSeq && len(out) < limit {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+type stubHidden []int64
+
+func (h stubHidden) ListHiddenSeqs(ctx context.Context, userID, conversationID string, fromSeq, toSeq int64) ([]int64, error) {
+	return h, nil
+}
+func (h stubHidden) ListHiddenInRanges(ctx context.Context, userID string, ranges []model.SeqRange) (map[string][]int64, error) {
+	return nil, nil
+}
+
+func TestPullMessagesFiltersHiddenButAdvancesCursor(t *testing.T) {
+	store := &pagePullStore{msgs: []model.TimelineMessage{
+		{MsgID: "a", Seq: 1}, {MsgID: "b", Seq: 2}, {MsgID: "c", Seq: 3}, {MsgID: "d", Seq: 4},
+	}}
+	svc := service.NewPullService(store, stubHidden{2, 3}, nil, 0, 0, 0)
+
+	res, err := svc.PullMessages(context.Background(), "u1", "conv", 0, 3)
+	if err != nil {
+		t.Fatalf("PullMessages error: %v", err)
+	}
+	if len(res.Messages) != 1 || res.Messages[0].MsgID != "a" {
+		t.Fatalf("expected only visible message a, got %+v", res.Messages)
+	}
+	if res.NextCursorSeq != 3 || !res.HasMore {
+		t.Fatalf("expected cursor to advance past hidden messages, got next=%d more=%v", res.NextCursorSeq, res.HasMore)
+	}
+}
+
+// countingPullStore 统计各读方法的调用次数，用于确认批量拉取的查询次数与会话数无关。
+type countingPullStore struct {
+	*repository.PullRepository
+	single, batch, cleared int
+}
+
+func (s *countingPullStore) ListMessages(ctx context.Context, conversationID string, afterSeq int64, limit int) ([]model.TimelineMessage, error) {
+	s.single++
+	return s.PullRepository.ListMessages(ctx, conversationID, afterSeq, limit)
+}
+func (s *countingPullStore) ListMessagesBatch(ctx context.Context, cursors []model.PullCursor, limit int) (map[string][]model.TimelineMessage, error) {
+	s.batch++
+	return s.PullRepository.ListMessagesBatch(ctx, cursors, limit)
+}
+func (s *countingPullStore) GetClearedSeq(ctx context.Context, userID, conversationID string) (int64, error) {
+	s.cleared++
+	return s.PullRepository.GetClearedSeq(ctx, userID, conversationID)
+}
+func (s *countingPullStore) GetClearedSeqs(ctx context.Context, userID string, conversationIDs []string) (map[string]int64, error) {
+	s.cleared++
+	return s.PullRepository.GetClearedSeqs(ctx, userID, conversationIDs)
+}
+
+func TestBatchPullPagesEachConversation(t *testing.T) {
+	db := openTestDB(t)
+	store := &countingPullStore{PullRepository: repository.NewPullRepository(db)}
+	hidden := repository.NewHiddenMessageRepository(db)
+	svc := service.NewPullService(store, hidden, nil, 2, 10, 5)
+	ctx := context.Background()
+
+	convA, convB, convC := uniqueID("batch-a"), uniqueID("batch-b"), uniqueID("batch-c")
+	seedMessages(t, db, convA, []int64{1, 2, 3})
+	seedMessages(t, db, convB, []int64{1, 2, 3, 4})
+	seedMessages(t, db, convC, []int64{1, 2})
+	// convB 清空到 2，convA 隐藏 seq 2
+	if err := db.Create(&model.UserConversationState{UserID: "u1", ConversationID: convB, ClearedSeq: 2}).Error; err != nil {
+		t.Fatalf("seed cleared seq: %v", err)
+	}
+	var msgA2 model.TimelineMessage
+	if err := db.Where("conversation_id = ? AND seq = 2", convA).Take(&msgA2).Error; err != nil {
+		t.Fatalf("find message: %v", err)
+	}
+	if err := hidden.HideMessages(ctx, "u1", []model.TimelineMessage{msgA2}); err != nil {
+		t.Fatalf("hide message: %v", err)
+	}
+
+	cursors := []model.PullCursor{{ConversationId: convA}, {ConversationId: convB}, {ConversationId: convA, CursorSeq: 2}, {ConversationId: convC}}
+	pages, err := svc.BatchPull(ctx, "u1", cursors, 0, 0)
+	if err != nil {
+		t.Fatalf("BatchPull error: %v", err)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("duplicate conversation should be pulled once, got %d pages", len(pages))
+	}
+
+	// 单会话上限为 page_size=2，整批上限为 5
+	a, b, c := pages[0], pages[1], pages[2]
+	if len(a.Messages) != 1 || a.Messages[0].Seq != 1 || a.NextCursorSeq != 2 || !a.HasMore {
+		t.Fatalf("convA should skip hidden seq 2 but advance the cursor: %+v", a)
+	}
+	if len(b.Messages) != 2 || b.Messages[0].Seq != 3 || b.NextCursorSeq != 4 || b.HasMore {
+		t.Fatalf("convB should start after cleared seq: %+v", b)
+	}
+	if len(c.Messages) != 1 || c.NextCursorSeq != 1 || !c.HasMore {
+		t.Fatalf("convC should be cut by the total limit: %+v", c)
+	}
+	if store.single != 0 || store.batch != 1 || store.cleared != 1 {
+		t.Fatalf("expected one batch query and one cleared-seq query, got single=%d batch=%d cleared=%d", store.single, store.batch, store.cleared)
+	}
+
+	many := make([]model.PullCursor, 11)
+	for i := range many {
+		many[i] = model.PullCursor{ConversationId: uniqueID("batch-many") + string(rune('a'+i))}
+	}
+	if _, err := svc.BatchPull(ctx, "u1", many, 0, 0); !errors.Is(err, service.ErrTooManyConversations) {
+		t.Fatalf("expected ErrTooManyConversations, got %v", err)
+	}
 }
@@ -4,7 +4,12 @@ import (
 	"context"
 	"reflect"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-im/internal/metrics"
 	"go-im/internal/model"
+	"go-im/internal/tracing"
 )
 
 // ConnWriter 抽象 WebSocket 连接的 JSON 写入能力，便于测试替换。
@@ -12,37 +17,147 @@ type ConnWriter interface {
 	WriteJSON(v interface{}) error
 }
 
-// ConnLookup 提供按用户 ID 获取连接的能力。
+// ConnLookup 提供按用户 ID 获取连接的能力，同一用户的多端各有一条连接。
 type ConnLookup interface {
-	Get(userID string) ConnWriter
+	Get(userID string) []ConnWriter
+}
+
+// OfflineNotifier 处理发给不在线用户的推送，例如转为离线通知。
+type OfflineNotifier interface {
+	Notify(ctx context.Context, userID string, packet model.OutputPacket)
 }
 
 // PushService 负责将 OutputPacket 推送到在线用户。
 type PushService struct {
-	conns ConnLookup
+	conns   ConnLookup
+	tracker *DeliveryTracker // 可为 nil，此时不跟踪送达回执
+	offline OfflineNotifier  // 可为 nil，此时离线用户直接跳过
 }
 
-func NewPushService(conns ConnLookup) *PushService {
-	return &PushService{conns: conns}
+func NewPushService(conns ConnLookup, tracker *DeliveryTracker, offline OfflineNotifier) *PushService {
+	return &PushService{conns: conns, tracker: tracker, offline: offline}
 }
 
-// Broadcast 将消息推送给 targets 中的用户，最佳努力发送。
+// Broadcast 将消息推送给 targets 中用户的全部在线连接，最佳努力发送。
+// 推送在写入前交给 DeliveryTracker 等待客户端回执，写入失败时撤销（未协商 receipts 的连接除外），不在线的用户交给 OfflineNotifier。
 func (s *PushService) Broadcast(ctx context.Context, packet model.OutputPacket, targets []string) error {
-	// TODO: 遍历 targets，获取连接并 WriteJSON；缺失连接或写失败时继续其他用户，最后返回汇总/首个错误。
 	var err error
 	for _, target := range targets {
-		conn := s.conns.Get(target)
-		if conn == nil {
+		conns := liveConns(s.conns.Get(target))
+		if len(conns) == 0 {
+			if s.offline != nil {
+				s.offline.Notify(ctx, target, packet)
+				metrics.PushResults.WithLabelValues(metrics.PushOffline).Inc()
+			} else {
+				metrics.PushResults.WithLabelValues(metrics.PushSkipped).Inc()
+			}
 			continue // 连接不存在，跳过
 		}
-		// 处理“带类型的 nil”场景（接口非 nil，但底层指针为 nil）
-		if rv := reflect.ValueOf(conn); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		for _, conn := range conns {
+			if curErr := s.push(ctx, target, conn, packet); curErr != nil && err == nil {
+				err = curErr // 返回首个错误
+			}
+		}
+	}
+	return err
+}
+
+// push 向一条连接写入推送并统计结果。
+func (s *PushService) push(ctx context.Context, userID string, conn ConnWriter, packet model.OutputPacket) error {
+	// 先登记再写入：客户端的回执可能在 WriteJSON 返回前就已到达
+	track := s.tracker != nil && wantsReceipts(conn)
+	if track {
+		s.tracker.Track(ctx, userID, conn, packet)
+	}
+	if err := s.write(ctx, userID, conn, packet); err != nil {
+		if track {
+			s.tracker.Untrack(conn, packet)
+		}
+		metrics.PushResults.WithLabelValues(metrics.PushFailure).Inc()
+		return err
+	}
+	metrics.PushResults.WithLabelValues(metrics.PushSuccess).Inc()
+	return nil
+}
+
+// liveConns 剔除“带类型的 nil”（接口非 nil，但底层指针为 nil）。
+func liveConns(conns []ConnWriter) []ConnWriter {
+	live := conns[:0:0]
+	for _, c := range conns {
+		if c != nil && !isNilPointer(c) {
+			live = append(live, c)
+		}
+	}
+	return live
+}
+
+// wantsReceipts 判断连接是否会发送送达回执：协商了能力的连接以 receipts 为准，其余连接按旧行为跟踪。
+func wantsReceipts(conn ConnWriter) bool {
+	if c, ok := conn.(interface{ Has(string) bool }); ok {
+		return c.Has(model.CapReceipts)
+	}
+	return true
+}
+
+func isNilPointer(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// SyncUser 把多端同步包写给用户的其他在线连接，跳过发起变更的 origin 连接，返回首个写入错误。
+// 同步包不跟踪送达回执，也不转为离线通知，离线设备上线后自行拉取最新状态。
+func (s *PushService) SyncUser(ctx context.Context, userID string, packet model.OutputPacket, origin ConnWriter) error {
+	var err error
+	synced := false
+	for _, conn := range liveConns(s.conns.Get(userID)) {
+		if conn == origin {
+			continue
+		}
+		synced = true
+		if curErr := s.write(ctx, userID, conn, packet); curErr != nil {
+			metrics.PushResults.WithLabelValues(metrics.PushFailure).Inc()
+			if err == nil {
+				err = curErr
+			}
+			continue
+		}
+		metrics.PushResults.WithLabelValues(metrics.PushSuccess).Inc()
+	}
+	if !synced {
+		metrics.PushResults.WithLabelValues(metrics.PushSkipped).Inc()
+	}
+	return err
+}
+
+// PushOtherDevices 把推送写给 userID 除 origin 外的在线连接，用于把发送者自己的消息同步到其他设备。
+// 与 Broadcast 一样跟踪送达回执；没有其他在线连接时直接跳过，不转为离线通知。
+func (s *PushService) PushOtherDevices(ctx context.Context, userID string, packet model.OutputPacket, origin ConnWriter) error {
+	var err error
+	pushed := false
+	for _, conn := range liveConns(s.conns.Get(userID)) {
+		if conn == origin {
 			continue
 		}
-		curErr := conn.WriteJSON(packet)
-		if curErr != nil && err == nil {
-			err = curErr // 返回首个错误
+		pushed = true
+		if curErr := s.push(ctx, userID, conn, packet); curErr != nil && err == nil {
+			err = curErr
 		}
 	}
+	if !pushed {
+		metrics.PushResults.WithLabelValues(metrics.PushSkipped).Inc()
+	}
+	return err
+}
+
+// write 在独立 span 中写一次推送，span 时长包含等待连接写锁的时间。
+func (s *PushService) write(ctx context.Context, userID string, conn ConnWriter, packet model.OutputPacket) error {
+	_, span := tracer.Start(ctx, "PushService.write", trace.WithAttributes(
+		attribute.String("im.user", userID),
+		attribute.String("im.cmd", packet.Cmd.String()),
+		attribute.Int64("im.seq", packet.Seq),
+	))
+	defer span.End()
+	err := conn.WriteJSON(packet)
+	tracing.RecordError(span, err)
 	return err
 }
@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"go-im/internal/metrics"
 	"go-im/internal/model"
 	"go-im/internal/service"
 )
@@ -23,11 +27,12 @@ type stubConnLookup struct {
 	conns map[string]*stubConn
 }
 
-func (l *stubConnLookup) Get(userID string) service.ConnWriter {
-	if l.conns == nil {
+func (l *stubConnLookup) Get(userID string) []service.ConnWriter {
+	conn, ok := l.conns[userID]
+	if !ok {
 		return nil
 	}
-	return l.conns[userID]
+	return []service.ConnWriter{conn}
 }
 
 func TestBroadcastSuccessToAllTargets(t *testing.T) {
@@ -37,7 +42,7 @@ func TestBroadcastSuccessToAllTargets(t *testing.T) {
 			"u2": {},
 		},
 	}
-	push := service.NewPushService(lookup)
+	push := service.NewPushService(lookup, nil, nil)
 	packet := model.OutputPacket{Cmd: model.CmdChat, Code: 0, MsgId: "m1", Seq: 1}
 
 	if err := push.Broadcast(context.Background(), packet, []string{"u1", "u2"}); err != nil {
@@ -62,7 +67,7 @@ func TestBroadcastContinuesOnWriteError(t *testing.T) {
 			"fail": {err: errWrite},
 		},
 	}
-	push := service.NewPushService(lookup)
+	push := service.NewPushService(lookup, nil, nil)
 	packet := model.OutputPacket{Cmd: model.CmdChat, Code: 0, MsgId: "m2", Seq: 2}
 
 	err := push.Broadcast(context.Background(), packet, []string{"ok", "fail"})
@@ -85,7 +90,7 @@ func TestBroadcastSkipsMissingConnection(t *testing.T) {
 			"only": {},
 		},
 	}
-	push := service.NewPushService(lookup)
+	push := service.NewPushService(lookup, nil, nil)
 	packet := model.OutputPacket{Cmd: model.CmdChat, Code: 0, MsgId: "m3", Seq: 3}
 
 	err := push.Broadcast(context.Background(), packet, []string{"only", "absent"})
@@ -96,3 +101,66 @@ func TestBroadcastSkipsMissingConnection(t *testing.T) {
 		t.Fatalf("expected present connection to receive payload")
 	}
 }
+
+func TestBroadcastCountsPushResults(t *testing.T) {
+	counter := func(result string) float64 {
+		return testutil.ToFloat64(metrics.PushResults.WithLabelValues(result))
+	}
+	success, failure, skipped := counter(metrics.PushSuccess), counter(metrics.PushFailure), counter(metrics.PushSkipped)
+
+	lookup := &stubConnLookup{
+		conns: map[string]*stubConn{
+			"ok":  {},
+			"bad": {err: errors.New("write failed")},
+		},
+	}
+	push := service.NewPushService(lookup, nil, nil)
+	packet := model.OutputPacket{Cmd: model.CmdChat, MsgId: "m1", Seq: 1}
+	_ = push.Broadcast(context.Background(), packet, []string{"ok", "bad", "gone"})
+
+	if got := counter(metrics.PushSuccess) - success; got != 1 {
+		t.Fatalf("expected 1 success, got %v", got)
+	}
+	if got := counter(metrics.PushFailure) - failure; got != 1 {
+		t.Fatalf("expected 1 failure, got %v", got)
+	}
+	if got := counter(metrics.PushSkipped) - skipped; got != 1 {
+		t.Fatalf("expected 1 skipped, got %v", got)
+	}
+}
+
+// capConn 是声明了协商能力的连接。
+type capConn struct {
+	stubConn
+	protocol service.Protocol
+}
+
+func (c *capConn) Has(capability string) bool { return c.protocol.Has(capability) }
+
+type capLookup map[string]*capConn
+
+func (l capLookup) Get(userID string) []service.ConnWriter {
+	if conn, ok := l[userID]; ok {
+		return []service.ConnWriter{conn}
+	}
+	return nil
+}
+
+func TestBroadcastTracksOnlyReceiptCapableConnections(t *testing.T) {
+	tracker := service.NewDeliveryTracker(nil, time.Second, 1)
+	lookup := capLookup{
+		"u1": {protocol: service.Protocol{Version: model.ProtocolVersion, Capabilities: []string{model.CapReceipts}}},
+		"u2": {protocol: service.Protocol{Version: model.ProtocolVersion}},
+	}
+	push := service.NewPushService(lookup, tracker, nil)
+	packet := model.OutputPacket{Cmd: model.CmdChat, ConversationId: "c1", Seq: 1}
+	if err := push.Broadcast(context.Background(), packet, []string{"u1", "u2"}); err != nil {
+		t.Fatalf("Broadcast returned error: %v", err)
+	}
+	if len(lookup["u1"].writes) != 1 || len(lookup["u2"].writes) != 1 {
+		t.Fatalf("both connections should receive the push")
+	}
+	if n := tracker.PendingTotal(); n != 1 {
+		t.Fatalf("only the receipts connection should be tracked, got %d pending", n)
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go-im/internal/config"
+	"go-im/internal/metrics"
+	"go-im/internal/model"
+)
+
+// RateVerdict 是限流判定结果。
+type RateVerdict int
+
+const (
+	RateAllowed    RateVerdict = iota
+	RateLimited                // 超出某一维度的限制，本条指令被拒绝
+	RateMuted                  // 用户处于临时禁言，聊天消息被拒绝
+	RateDisconnect             // 屡次违规，应断开连接
+)
+
+// 限流维度，同时用作指标标签。
+const (
+	RateScopeUser         = "user"
+	RateScopeConnection   = "connection"
+	RateScopeConversation = "conversation"
+	RateScopeCommand      = "command"
+	RateScopeMuted        = "muted"
+)
+
+// rateIdleTTL 为用户状态的保留时长，超过该时长无指令且未被禁言的用户状态被回收；
+// 令牌桶在此期间早已补满，回收不会放宽限制。
+const rateIdleTTL = 10 * time.Minute
+
+// ConnLimits 保存单条连接的连接级与指令级令牌桶，由连接的读循环独占使用，连接关闭即释放。
+type ConnLimits struct {
+	conn TokenBucket
+	cmds map[model.CmdType]*TokenBucket
+}
+
+func NewConnLimits() *ConnLimits {
+	return &ConnLimits{cmds: make(map[model.CmdType]*TokenBucket)}
+}
+
+// RateLimiter 维护跨连接共享的用户级、用户在会话内的令牌桶以及违规计数，
+// 用户重连不会重置限制与禁言。并发安全。
+type RateLimiter struct {
+	mu    sync.Mutex
+	users map[string]*userLimits
+}
+
+type userLimits struct {
+	bucket      TokenBucket
+	convs       map[string]*TokenBucket
+	strikes     int
+	windowStart time.Time
+	mutedUntil  time.Time
+	lastSeen    time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{users: make(map[string]*userLimits)}
+}
+
+// Check 判定用户经 conn 发送的一条指令，返回判定结果与触发的限流维度。
+// 任一维度超限记一次违规：窗口内违规达到 MuteAfter 次时禁言 MuteDuration，达到 DisconnectAfter 次时要求断开连接。
+// 桶按连接、指令、用户、会话的顺序检查，先通过的桶已扣除令牌。回执类指令（见 rateExempt）只受指令级限制且不记违规。
+func (l *RateLimiter) Check(now time.Time, cfg config.RateLimitConfig, userID string, conn *ConnLimits, packet model.InputPacket) (RateVerdict, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u := l.users[userID]
+	if u == nil {
+		u = &userLimits{convs: make(map[string]*TokenBucket)}
+		l.users[userID] = u
+	}
+	u.lastSeen = now
+	muted := now.Before(u.mutedUntil)
+	exempt := rateExempt(packet.Cmd)
+
+	scope := ""
+	switch {
+	case muted && packet.Cmd == model.CmdChat:
+		scope = RateScopeMuted
+	case !exempt && !conn.conn.Allow(now, cfg.Connection.Rate, cfg.Connection.Burst):
+		scope = RateScopeConnection
+	case !conn.allowCmd(now, cfg, packet.Cmd):
+		scope = RateScopeCommand
+	case !exempt && !u.bucket.Allow(now, cfg.User.Rate, cfg.User.Burst):
+		scope = RateScopeUser
+	case !exempt && packet.ConversationId != "" && !u.conv(packet.ConversationId).Allow(now, cfg.Conversation.Rate, cfg.Conversation.Burst):
+		scope = RateScopeConversation
+	default:
+		return RateAllowed, ""
+	}
+	metrics.RateLimitHits.WithLabelValues(scope).Inc()
+	if exempt {
+		return RateLimited, scope
+	}
+
+	if u.windowStart.IsZero() || now.Sub(u.windowStart) >= cfg.Window {
+		u.strikes, u.windowStart = 0, now
+	}
+	u.strikes++
+	switch {
+	case cfg.DisconnectAfter > 0 && u.strikes >= cfg.DisconnectAfter:
+		// 断开后重新计数，禁言仍然有效，重连后继续刷屏会再次被断开
+		u.strikes, u.windowStart = 0, now
+		metrics.RateLimitActions.WithLabelValues("disconnect").Inc()
+		return RateDisconnect, scope
+	case cfg.MuteAfter > 0 && u.strikes >= cfg.MuteAfter && !muted:
+		u.mutedUntil = now.Add(cfg.MuteDuration)
+		metrics.RateLimitActions.WithLabelValues("mute").Inc()
+		return RateMuted, scope
+	case scope == RateScopeMuted:
+		return RateMuted, scope
+	}
+	return RateLimited, scope
+}
+
+// MutedUntil 返回用户禁言的截止时间，未被禁言时返回零值。
+func (l *RateLimiter) MutedUntil(userID string, now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if u := l.users[userID]; u != nil && now.Before(u.mutedUntil) {
+		return u.mutedUntil
+	}
+	return time.Time{}
+}
+
+// Sweep 回收长时间空闲的用户状态与会话令牌桶。
+func (l *RateLimiter) Sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, u := range l.users {
+		if now.Sub(u.lastSeen) > rateIdleTTL && !now.Before(u.mutedUntil) {
+			delete(l.users, id)
+			continue
+		}
+		for conv, b := range u.convs {
+			if now.Sub(b.last) > rateIdleTTL {
+				delete(u.convs, conv)
+			}
+		}
+	}
+}
+
+// Run 定期执行 Sweep，直到 ctx 取消。
+func (l *RateLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.Sweep(now)
+		}
+	}
+}
+
+// rateExempt 报告指令是否为心跳或回执。这类指令的频率由服务端推送决定而非用户行为，
+// 活跃群聊的接收方逐条回执时不应因此被限流、禁言或断开，也不占用用户发消息的额度。
+func rateExempt(cmd model.CmdType) bool {
+	switch cmd {
+	case model.CmdHeartbeat, model.CmdAck, model.CmdDeliverAck, model.CmdBatchAck:
+		return true
+	}
+	return false
+}
+
+func (c *ConnLimits) allowCmd(now time.Time, cfg config.RateLimitConfig, cmd model.CmdType) bool {
+	limit, ok := cfg.Commands[cmd.String()]
+	if !ok {
+		return true
+	}
+	b := c.cmds[cmd]
+	if b == nil {
+		b = &TokenBucket{}
+		c.cmds[cmd] = b
+	}
+	return b.Allow(now, limit.Rate, limit.Burst)
+}
+
+func (u *userLimits) conv(id string) *TokenBucket {
+	b := u.convs[id]
+	if b == nil {
+		b = &TokenBucket{}
+		u.convs[id] = b
+	}
+	return b
+}
@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"go-im/internal/config"
+	"go-im/internal/model"
+	"go-im/internal/service"
+)
+
+func TestRateLimiterSharesUserLimitAcrossConnections(t *testing.T) {
+	l := service.NewRateLimiter()
+	cfg := config.RateLimitConfig{User: config.RateLimit{Rate: 1, Burst: 2}}
+	now := time.Now()
+	chat := model.InputPacket{Cmd: model.CmdChat, ConversationId: "c1"}
+
+	a, b := service.NewConnLimits(), service.NewConnLimits()
+	if v, _ := l.Check(now, cfg, "u1", a, chat); v != service.RateAllowed {
+		t.Fatalf("first packet should be allowed, got %v", v)
+	}
+	if v, _ := l.Check(now, cfg, "u1", b, chat); v != service.RateAllowed {
+		t.Fatalf("second packet should be allowed, got %v", v)
+	}
+	if v, scope := l.Check(now, cfg, "u1", service.NewConnLimits(), chat); v != service.RateLimited || scope != service.RateScopeUser {
+		t.Fatalf("user limit should apply across connections, got %v %q", v, scope)
+	}
+	if v, _ := l.Check(now, cfg, "u2", a, chat); v != service.RateAllowed {
+		t.Fatalf("other users are not affected, got %v", v)
+	}
+}
+
+func TestRateLimiterScopes(t *testing.T) {
+	l := service.NewRateLimiter()
+	cfg := config.RateLimitConfig{
+		Conversation: config.RateLimit{Rate: 1, Burst: 1},
+		Commands:     map[string]config.RateLimit{"pull": {Rate: 1, Burst: 1}},
+	}
+	now := time.Now()
+	conn := service.NewConnLimits()
+
+	l.Check(now, cfg, "u1", conn, model.InputPacket{Cmd: model.CmdChat, ConversationId: "c1"})
+	if _, scope := l.Check(now, cfg, "u1", conn, model.InputPacket{Cmd: model.CmdChat, ConversationId: "c1"}); scope != service.RateScopeConversation {
+		t.Fatalf("expected conversation scope, got %q", scope)
+	}
+	if v, _ := l.Check(now, cfg, "u1", conn, model.InputPacket{Cmd: model.CmdChat, ConversationId: "c2"}); v != service.RateAllowed {
+		t.Fatalf("other conversations are not affected, got %v", v)
+	}
+	l.Check(now, cfg, "u1", conn, model.InputPacket{Cmd: model.CmdPull})
+	if _, scope := l.Check(now, cfg, "u1", conn, model.InputPacket{Cmd: model.CmdPull}); scope != service.RateScopeCommand {
+		t.Fatalf("expected command scope, got %q", scope)
+	}
+}
+
+func TestRateLimiterEscalatesToMuteThenDisconnect(t *testing.T) {
+	l := service.NewRateLimiter()
+	cfg := config.RateLimitConfig{
+		Connection:      config.RateLimit{Rate: 1, Burst: 1},
+		Window:          time.Minute,
+		MuteAfter:       2,
+		MuteDuration:    30 * time.Second,
+		DisconnectAfter: 4,
+	}
+	now := time.Now()
+	conn := service.NewConnLimits()
+	chat := model.InputPacket{Cmd: model.CmdChat, ConversationId: "c1"}
+
+	want := []service.RateVerdict{service.RateAllowed, service.RateLimited, service.RateMuted, service.RateMuted, service.RateDisconnect}
+	for i, w := range want {
+		if v, _ := l.Check(now, cfg, "u1", conn, chat); v != w {
+			t.Fatalf("packet %d: expected %v, got %v", i, w, v)
+		}
+	}
+
+	// 重连后禁言仍然有效，非聊天指令不受禁言影响
+	conn = service.NewConnLimits()
+	if v, scope := l.Check(now, cfg, "u1", conn, chat); v != service.RateMuted || scope != service.RateScopeMuted {
+		t.Fatalf("mute should survive reconnect, got %v %q", v, scope)
+	}
+	if v, _ := l.Check(now, cfg, "u1", conn, model.InputPacket{Cmd: model.CmdPull}); v != service.RateAllowed {
+		t.Fatalf("pull should be allowed while muted, got %v", v)
+	}
+	if until := l.MutedUntil("u1", now); !until.Equal(now.Add(30 * time.Second)) {
+		t.Fatalf("unexpected mute deadline %v", until)
+	}
+
+	later := now.Add(31 * time.Second)
+	if v, _ := l.Check(later, cfg, "u1", service.NewConnLimits(), chat); v != service.RateAllowed {
+		t.Fatalf("mute should expire, got %v", v)
+	}
+}
+
+func TestRateLimiterDoesNotPunishReceiptsOfFastGroup(t *testing.T) {
+	l := service.NewRateLimiter()
+	cfg := config.RateLimitConfig{
+		User:            config.RateLimit{Rate: 1, Burst: 2},
+		Connection:      config.RateLimit{Rate: 1, Burst: 2},
+		Conversation:    config.RateLimit{Rate: 1, Burst: 2},
+		Window:          time.Minute,
+		MuteAfter:       2,
+		MuteDuration:    time.Minute,
+		DisconnectAfter: 3,
+	}
+	now := time.Now()
+	conn := service.NewConnLimits()
+
+	// 接收方对活跃群的每条推送回执送达并确认已读
+	for seq := int64(1); seq <= 100; seq++ {
+		for _, p := range []model.InputPacket{
+			{Cmd: model.CmdDeliverAck, ConversationId: "g1", Seq: seq},
+			{Cmd: model.CmdAck, ConversationId: "g1", Seq: seq},
+			{Cmd: model.CmdHeartbeat},
+		} {
+			if v, scope := l.Check(now, cfg, "u1", conn, p); v != service.RateAllowed {
+				t.Fatalf("seq %d %v: expected allowed, got %v %q", seq, p.Cmd, v, scope)
+			}
+		}
+	}
+	if until := l.MutedUntil("u1", now); !until.IsZero() {
+		t.Fatalf("receiver should not be muted, muted until %v", until)
+	}
+	// 回执没有占用发消息的额度
+	if v, _ := l.Check(now, cfg, "u1", conn, model.InputPacket{Cmd: model.CmdChat, ConversationId: "g1"}); v != service.RateAllowed {
+		t.Fatalf("chat after receipts should be allowed, got %v", v)
+	}
+}
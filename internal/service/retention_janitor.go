@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"go-im/internal/config"
+	"go-im/internal/metrics"
+	"go-im/internal/model"
+)
+
+// retentionConversationPage 为归档任务每次列出的会话数。
+const retentionConversationPage = 500
+
+// RetentionStore 提供按保留策略归档消息所需的数据访问。
+type RetentionStore interface {
+	ListConversationIDs(ctx context.Context, include, exclude []string, after string, limit int) ([]string, error)
+	ExpiredSeq(ctx context.Context, conversationID string, before int64) (int64, error)
+	ListArchivable(ctx context.Context, conversationID string, upToSeq int64, limit int) ([]model.TimelineMessage, error)
+	DeleteArchived(ctx context.Context, conversationID string, upToSeq int64) (int64, error)
+}
+
+// RetentionJanitor 按会话类型的保留时长定期归档过期消息：每批先写入归档，再在一个事务内删除并推进会话的归档位点，
+// 拉取越过位点时返回 archived_seq 提示客户端。任一步失败时该批消息保留在数据库，下一轮重新归档。
+// 归档是至少一次的：写入归档后删除失败，重试时同一批消息会再次写入。归档按首末 seq 命名，批次相同时覆盖原文件；
+// 期间又有消息过期导致批次末尾不同时，新旧文件的 seq 区间重叠，导回时按 (conversation_id, seq) 去重。
+type RetentionJanitor struct {
+	store   RetentionStore
+	archive ArchiveWriter
+	cfg     config.RetentionConfig
+	logger  *slog.Logger
+}
+
+// NewRetentionJanitor 创建归档任务，logger 为 nil 时使用 slog.Default()。
+func NewRetentionJanitor(store RetentionStore, archive ArchiveWriter, cfg config.RetentionConfig, logger *slog.Logger) *RetentionJanitor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &RetentionJanitor{store: store, archive: archive, cfg: cfg, logger: logger}
+}
+
+// retentionRule 是一条保留规则，include 为空时匹配 exclude 之外的全部会话类型。
+type retentionRule struct {
+	include, exclude []string
+	keep             time.Duration
+}
+
+func (j *RetentionJanitor) rules() []retentionRule {
+	listed := make([]string, 0, len(j.cfg.Policies))
+	for t := range j.cfg.Policies {
+		listed = append(listed, t)
+	}
+	sort.Strings(listed)
+	var rules []retentionRule
+	for _, t := range listed {
+		if keep := j.cfg.Policies[t]; keep > 0 {
+			rules = append(rules, retentionRule{include: []string{t}, keep: keep})
+		}
+	}
+	if j.cfg.Default > 0 {
+		rules = append(rules, retentionRule{exclude: listed, keep: j.cfg.Default})
+	}
+	return rules
+}
+
+// Sweep 归档 now 时刻已超过保留时长的消息，返回归档的条数。
+// 单个会话失败时记录日志与 RetentionFailures 后继续处理其余会话，返回的错误包含失败会话数与首个错误。
+func (j *RetentionJanitor) Sweep(ctx context.Context, now time.Time) (int64, error) {
+	var total int64
+	var failed int
+	var firstErr error
+	for _, rule := range j.rules() {
+		before := now.Add(-rule.keep).UnixMilli()
+		after := ""
+		for {
+			ids, err := j.store.ListConversationIDs(ctx, rule.include, rule.exclude, after, retentionConversationPage)
+			if err != nil {
+				return total, err
+			}
+			for _, id := range ids {
+				n, err := j.archiveConversation(ctx, id, before)
+				total += n
+				if err != nil {
+					if ctx.Err() != nil {
+						return total, ctx.Err()
+					}
+					j.logger.Warn("归档会话失败，下一轮重试", "conversation_id", id, "archived", n, "err", err)
+					metrics.RetentionFailures.Inc()
+					if failed++; firstErr == nil {
+						firstErr = fmt.Errorf("archive conversation %s: %w", id, err)
+					}
+				}
+			}
+			if len(ids) < retentionConversationPage {
+				break
+			}
+			after = ids[len(ids)-1]
+		}
+	}
+	if failed > 0 {
+		return total, fmt.Errorf("%d conversations failed, first: %w", failed, firstErr)
+	}
+	return total, nil
+}
+
+// archiveConversation 分批归档会话中 send_time 早于 before 的消息，每批不超过 batch_size 条。
+func (j *RetentionJanitor) archiveConversation(ctx context.Context, conversationID string, before int64) (int64, error) {
+	upTo, err := j.store.ExpiredSeq(ctx, conversationID, before)
+	if err != nil || upTo == 0 {
+		return 0, err
+	}
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		msgs, err := j.store.ListArchivable(ctx, conversationID, upTo, j.cfg.BatchSize)
+		if err != nil || len(msgs) == 0 {
+			return total, err
+		}
+		if err := j.archive.WriteArchive(ctx, conversationID, msgs); err != nil {
+			return total, err
+		}
+		last := int64(msgs[len(msgs)-1].Seq)
+		n, err := j.store.DeleteArchived(ctx, conversationID, last)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		metrics.MessagesArchived.Add(float64(n))
+		if last >= upTo {
+			return total, nil
+		}
+	}
+}
+
+// Run 启动时归档一次，之后按 interval 定期归档，直到 ctx 取消。
+func (j *RetentionJanitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := j.Sweep(ctx, time.Now())
+		switch {
+		case err != nil && ctx.Err() == nil:
+			j.logger.Warn("归档过期消息失败", "archived", n, "err", err)
+		case n > 0:
+			j.logger.Info("已归档过期消息", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package service_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-im/internal/config"
+	"go-im/internal/model"
+	"go-im/internal/repository"
+	"go-im/internal/service"
+)
+
+func seedOld(t *testing.T, store *repository.MemoryStore, conv string, n int, sendTime time.Time) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		msg := &model.TimelineMessage{MsgID: uniqueID(conv), ConversationID: conv, SenderID: "u1", Content: "c", MsgType: 1, SendTime: sendTime.UnixMilli()}
+		if err := store.SaveMessage(context.Background(), msg); err != nil {
+			t.Fatalf("seed %s: %v", conv, err)
+		}
+	}
+}
+
+func readArchive(t *testing.T, path string) []int64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	var seqs []int64
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		var line struct {
+			Seq     int64  `json:"seq"`
+			Content string `json:"content"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil || line.Content != "c" {
+			t.Fatalf("bad archive line %q: %v", scanner.Text(), err)
+		}
+		seqs = append(seqs, line.Seq)
+	}
+	return seqs
+}
+
+func TestRetentionJanitorArchivesByConversationType(t *testing.T) {
+	store := repository.NewMemoryStore()
+	dir := t.TempDir()
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	seedOld(t, store, "group_1", 5, old)
+	seedOld(t, store, "private_u1_u2", 3, old)
+	seedOld(t, store, "channel_1", 2, old)
+
+	cfg := config.Default().Retention
+	cfg.Policies = map[string]time.Duration{"private": 0, "group": 24 * time.Hour}
+	cfg.BatchSize = 2
+	janitor := service.NewRetentionJanitor(store, service.NewFileArchive(dir), cfg, nil)
+	ctx := context.Background()
+
+	n, err := janitor.Sweep(ctx, now)
+	if err != nil || n != 4 {
+		t.Fatalf("Sweep archived %d, err %v; want 4 (latest group message kept)", n, err)
+	}
+	for name, want := range map[string][]int64{"1-2.jsonl.gz": {1, 2}, "3-4.jsonl.gz": {3, 4}} {
+		got := readArchive(t, filepath.Join(dir, "group_1", name))
+		if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Fatalf("%s: got seqs %v, want %v", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "private_u1_u2")); !os.IsNotExist(err) {
+		t.Fatalf("private conversations are kept forever, got archive dir err %v", err)
+	}
+	if n, err := janitor.Sweep(ctx, now); err != nil || n != 0 {
+		t.Fatalf("second sweep should be a no-op, archived %d, err %v", n, err)
+	}
+
+	pull := service.NewPullService(store, nil, nil, 10, 0, 0)
+	res, err := pull.PullMessages(ctx, "u1", "group_1", 0, 0)
+	if err != nil {
+		t.Fatalf("PullMessages error: %v", err)
+	}
+	if res.ArchivedSeq != 4 || len(res.Messages) != 1 || res.Messages[0].Seq != 5 {
+		t.Fatalf("pull should report archived history before seq 5, got %+v", res)
+	}
+	if res, _ := pull.PullMessages(ctx, "u1", "group_1", 4, 0); res.ArchivedSeq != 0 {
+		t.Fatalf("cursor past the archive should not be marked, got %d", res.ArchivedSeq)
+	}
+	pages, err := pull.BatchPull(ctx, "u1", []model.PullCursor{{ConversationId: "group_1"}, {ConversationId: "private_u1_u2"}}, 0, 0)
+	if err != nil {
+		t.Fatalf("BatchPull error: %v", err)
+	}
+	if pages[0].ArchivedSeq != 4 || pages[0].NextCursorSeq != 5 || pages[1].ArchivedSeq != 0 || len(pages[1].Messages) != 3 {
+		t.Fatalf("unexpected batch pages: %+v", pages)
+	}
+
+	// 未列出的会话类型按 default 处理
+	cfg.Default = time.Hour
+	janitor = service.NewRetentionJanitor(store, service.NewFileArchive(dir), cfg, nil)
+	if n, err := janitor.Sweep(ctx, now); err != nil || n != 1 {
+		t.Fatalf("default policy should archive channel_1, archived %d, err %v", n, err)
+	}
+}
+
+// failingArchive 对指定会话返回写入错误，其余会话交给 next。
+type failingArchive struct {
+	next service.ArchiveWriter
+	fail string
+}
+
+func (a failingArchive) WriteArchive(ctx context.Context, conversationID string, msgs []model.TimelineMessage) error {
+	if conversationID == a.fail {
+		return errors.New("disk full")
+	}
+	return a.next.WriteArchive(ctx, conversationID, msgs)
+}
+
+func TestRetentionSweepContinuesPastFailedConversation(t *testing.T) {
+	store := repository.NewMemoryStore()
+	now := time.Now()
+	seedOld(t, store, "group_a", 2, now.Add(-48*time.Hour))
+	seedOld(t, store, "group_b", 2, now.Add(-48*time.Hour))
+
+	cfg := config.Default().Retention
+	cfg.Default = 24 * time.Hour
+	archive := failingArchive{next: service.NewFileArchive(t.TempDir()), fail: "group_a"}
+	janitor := service.NewRetentionJanitor(store, archive, cfg, nil)
+
+	n, err := janitor.Sweep(context.Background(), now)
+	if err == nil || !strings.Contains(err.Error(), "group_a") {
+		t.Fatalf("Sweep should report the failed conversation, got %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("group_b should still be archived up to its latest message, archived %d", n)
+	}
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"math"
+	"time"
+)
+
+// TokenBucket 是非并发安全的令牌桶，由调用方负责同步：连接级的桶由读循环独占，跨连接的桶由 RateLimiter 加锁访问。
+// 速率与容量在每次调用时传入，便于运行时调整限制后立即对存量连接生效。
+type TokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Allow 按 rate（每秒令牌数）与 burst（桶容量）补充令牌并尝试取出一个，rate <= 0 表示不限。
+func (b *TokenBucket) Allow(now time.Time, rate float64, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+	}
+	b.tokens = math.Min(b.tokens, float64(burst))
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
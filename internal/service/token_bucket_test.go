@@ -0,0 +1,45 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"go-im/internal/service"
+)
+
+func TestTokenBucketRefillsAtRate(t *testing.T) {
+	var b service.TokenBucket
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if !b.Allow(now, 1, 2) {
+			t.Fatalf("burst token %d should be allowed", i)
+		}
+	}
+	if b.Allow(now, 1, 2) {
+		t.Fatalf("bucket should be empty after burst")
+	}
+	if !b.Allow(now.Add(time.Second), 1, 2) {
+		t.Fatalf("one token should refill after 1s")
+	}
+	if !b.Allow(now.Add(time.Second), 0, 2) {
+		t.Fatalf("rate 0 means unlimited")
+	}
+}
+
+func TestConnectionManagerEnforcesMaxConnections(t *testing.T) {
+	m := service.NewConnectionManager()
+	m.SetMaxConnections(1)
+	if err := m.Add("u1", service.NewConnection("u1", "", "", nil, time.Second, nil)); err != nil {
+		t.Fatalf("first connection should be accepted: %v", err)
+	}
+	if err := m.Add("u2", service.NewConnection("u2", "", "", nil, time.Second, nil)); err != service.ErrTooManyConnections {
+		t.Fatalf("expected ErrTooManyConnections, got %v", err)
+	}
+	m.SetMaxConnections(0)
+	if err := m.Add("u2", service.NewConnection("u2", "", "", nil, time.Second, nil)); err != nil {
+		t.Fatalf("limit removed at runtime, got %v", err)
+	}
+	if m.Count() != 2 {
+		t.Fatalf("expected 2 connections, got %d", m.Count())
+	}
+}
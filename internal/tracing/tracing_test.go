@@ -0,0 +1,48 @@
+package tracing_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+
+	"go-im/internal/config"
+	"go-im/internal/tracing"
+)
+
+func TestFileExporterWritesSpans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	cfg := config.Default().Tracing
+	cfg.Exporter, cfg.File = "file", path
+
+	shutdown, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	ctx, span := otel.Tracer("test").Start(context.Background(), "ws.packet chat")
+	traceID := tracing.TraceID(ctx)
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	if len(traceID) != 32 {
+		t.Fatalf("expected a sampled trace id, got %q", traceID)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read spans: %v", err)
+	}
+	if !strings.Contains(string(data), "ws.packet chat") || !strings.Contains(string(data), traceID) {
+		t.Fatalf("span not exported: %s", data)
+	}
+}
+
+func TestTraceIDEmptyWithoutSpan(t *testing.T) {
+	if id := tracing.TraceID(context.Background()); id != "" {
+		t.Fatalf("expected empty trace id, got %q", id)
+	}
+}
@@ -0,0 +1,19 @@
+-- Go-IM 演示数据
+-- 表结构由内嵌迁移管理（internal/repository/migrations），先执行 `go run ./cmd/server migrate up`，再导入本脚本：
+--   docker-compose exec -T mysql mysql -u im_user -pim_pass123 go_im < scripts/seed.sql
+
+USE go_im;
+
+-- 插入测试数据
+INSERT INTO `user` (`user_id`, `nickname`) VALUES
+    ('user_1', '张三'),
+    ('user_2', '李四'),
+    ('user_3', '王五')
+ON DUPLICATE KEY UPDATE `nickname` = VALUES(`nickname`);
+
+-- 初始化一个测试群组
+INSERT INTO `group_member` (`group_id`, `user_id`, `join_time`) VALUES
+    ('group_1', 'user_1', UNIX_TIMESTAMP() * 1000),
+    ('group_1', 'user_2', UNIX_TIMESTAMP() * 1000),
+    ('group_1', 'user_3', UNIX_TIMESTAMP() * 1000)
+ON DUPLICATE KEY UPDATE `join_time` = VALUES(`join_time`);